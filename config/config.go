@@ -3,7 +3,9 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -15,7 +17,10 @@ type Config struct {
 	DBPassword           string
 	DBName               string
 	RedisAddr            string
-	JWTSecret            string
+	RedisRetryEvery      time.Duration // How often to retry Redis when it is down; zero gives up
+	AppEnv               string        // "production" enforces real JWT keys
+	JWTKeysDir           string
+	JWTActiveKID         string
 	ServerPort           string
 	CORSAllowOrigins     []string
 	CORSAllowMethods     []string
@@ -23,6 +28,42 @@ type Config struct {
 	CORSAllowCredentials bool
 	CORSExposeHeaders    string
 	CORSMaxAge           string
+	MailDriver           string // "smtp" or "log"
+	SMTPHost             string
+	SMTPPort             string
+	SMTPUsername         string
+	SMTPPassword         string
+	MailFrom             string
+	MailLogDir           string
+	PasswordResetURL     string
+	LoginMaxAttempts     int
+	LoginIPMaxAttempts   int
+	LoginLockoutDuration time.Duration
+	RequireAdmin2FA      bool
+	TOTPIssuer           string
+	OIDCIssuerURL        string // Single sign-on is disabled when empty
+	OIDCClientID         string
+	OIDCClientSecret     string
+	OIDCRedirectURL      string
+	OIDCScopes           []string
+	OIDCGroupsClaim      string
+	OIDCRoleMapping      string // e.g. "wms-admins=admin,wms-staff=user"
+	OIDCDefaultRole      string // Role when no group matches; empty denies login
+	OIDCFrontendURL      string // Receives tokens in the URL fragment after login
+	AuditCheckpointFile  string
+	AuditCheckpointEvery time.Duration // Zero disables signed audit checkpoints
+	AuditCheckpointKey   string        // File holding the checkpoint signing secret, created if missing
+	AuditChainInterval   time.Duration // How often committed entries are linked into the hash chain
+	AuditBufferSize      int
+	AuditBatchSize       int
+	AuditFlushInterval   time.Duration
+	AuditOverflow        string // "block" or "drop" when the audit buffer is full
+	AuditSkipReads       bool   // Leave successful GET requests out of the access log
+	ShutdownTimeout      time.Duration
+	NotifyChannels       []string // Any of "log", "email", "webhook"
+	NotifyEmails         []string
+	NotifyWebhookURL     string
+	DepreciationEvery    time.Duration // How often to check for unposted months; zero disables
 }
 
 func LoadConfig() *Config {
@@ -38,7 +79,10 @@ func LoadConfig() *Config {
 		DBPassword:           getEnv("DB_PASSWORD", "password"),
 		DBName:               getEnv("DB_NAME", "warehouse"),
 		RedisAddr:            getEnv("REDIS_ADDR", "localhost:6379"),
-		JWTSecret:            getEnv("JWT_SECRET", "Ult_wms"),
+		RedisRetryEvery:      getEnvDuration("REDIS_RETRY_INTERVAL", 30*time.Second),
+		AppEnv:               getEnv("APP_ENV", "development"),
+		JWTKeysDir:           getEnv("JWT_KEYS_DIR", "keys"),
+		JWTActiveKID:         getEnv("JWT_ACTIVE_KID", ""),
 		ServerPort:           getEnv("SERVER_PORT", "8080"),
 		CORSAllowOrigins:     splitEnv("CORS_ALLOW_ORIGINS", "*"),
 		CORSAllowMethods:     splitEnv("CORS_ALLOW_METHODS", "GET,POST,PUT,DELETE,OPTIONS"),
@@ -46,6 +90,42 @@ func LoadConfig() *Config {
 		CORSAllowCredentials: getEnv("CORS_ALLOW_CREDENTIALS", "true") == "true",
 		CORSExposeHeaders:    getEnv("CORS_EXPOSE_HEADERS", "Content-Length"),
 		CORSMaxAge:           getEnv("CORS_MAX_AGE", "86400"), //24 Hrs.
+		MailDriver:           getEnv("MAIL_DRIVER", "log"),
+		SMTPHost:             getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:             getEnv("SMTP_PORT", "587"),
+		SMTPUsername:         getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:         getEnv("SMTP_PASSWORD", ""),
+		MailFrom:             getEnv("MAIL_FROM", "no-reply@warehouse.local"),
+		MailLogDir:           getEnv("MAIL_LOG_DIR", "logs/mail"),
+		PasswordResetURL:     getEnv("PASSWORD_RESET_URL", "http://localhost:3000/reset-password"),
+		LoginMaxAttempts:     getEnvInt("LOGIN_MAX_ATTEMPTS", 5),
+		LoginIPMaxAttempts:   getEnvInt("LOGIN_IP_MAX_ATTEMPTS", 20),
+		LoginLockoutDuration: getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		RequireAdmin2FA:      getEnv("REQUIRE_ADMIN_2FA", "false") == "true",
+		TOTPIssuer:           getEnv("TOTP_ISSUER", "Warehouse"),
+		OIDCIssuerURL:        getEnv("OIDC_ISSUER_URL", ""),
+		OIDCClientID:         getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:     getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:      getEnv("OIDC_REDIRECT_URL", "http://localhost:8000/auth/oidc/callback"),
+		OIDCScopes:           splitEnv("OIDC_SCOPES", "openid,profile,email,groups"),
+		OIDCGroupsClaim:      getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCRoleMapping:      getEnv("OIDC_ROLE_MAPPING", ""),
+		OIDCDefaultRole:      getEnv("OIDC_DEFAULT_ROLE", "user"),
+		OIDCFrontendURL:      getEnv("OIDC_FRONTEND_URL", ""),
+		AuditCheckpointFile:  getEnv("AUDIT_CHECKPOINT_FILE", "logs/audit-checkpoints.jsonl"),
+		AuditCheckpointEvery: getEnvDuration("AUDIT_CHECKPOINT_INTERVAL", time.Hour),
+		AuditCheckpointKey:   getEnv("AUDIT_CHECKPOINT_KEY_FILE", "keys/audit-checkpoint.key"),
+		AuditChainInterval:   getEnvDuration("AUDIT_CHAIN_INTERVAL", time.Second),
+		AuditBufferSize:      getEnvInt("AUDIT_BUFFER_SIZE", 1000),
+		AuditBatchSize:       getEnvInt("AUDIT_BATCH_SIZE", 100),
+		AuditFlushInterval:   getEnvDuration("AUDIT_FLUSH_INTERVAL", time.Second),
+		AuditOverflow:        getEnv("AUDIT_OVERFLOW", "block"),
+		AuditSkipReads:       getEnv("AUDIT_SKIP_READS", "true") == "true",
+		ShutdownTimeout:      getEnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
+		NotifyChannels:       splitEnv("NOTIFY_CHANNELS", "log"),
+		NotifyEmails:         splitEnv("NOTIFY_EMAILS", ""),
+		NotifyWebhookURL:     getEnv("NOTIFY_WEBHOOK_URL", ""),
+		DepreciationEvery:    getEnvDuration("DEPRECIATION_RUN_INTERVAL", 24*time.Hour),
 	}
 }
 
@@ -61,3 +141,19 @@ func splitEnv(key, defaultValue string) []string {
 	value := getEnv(key, defaultValue)
 	return strings.Split(value, ",")
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+type APIKeyController struct {
+	DB *gorm.DB
+}
+
+func NewAPIKeyController(db *gorm.DB) *APIKeyController {
+	return &APIKeyController{DB: db}
+}
+
+func (ctrl *APIKeyController) GetAPIKeys(c *gin.Context) {
+	var keys []models.APIKey
+	if err := ctrl.DB.Preload("User", auditActorColumns).Preload("Project").Order("id desc").Find(&keys).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// CreateAPIKey issues a new key. The plain key is only returned in this
+// response; afterwards only its hash is kept.
+func (ctrl *APIKeyController) CreateAPIKey(c *gin.Context) {
+	adminID := c.MustGet("userID").(uint)
+
+	var input struct {
+		Name      string     `json:"name" binding:"required"`
+		Scopes    []string   `json:"scopes" binding:"required,min=1"`
+		UserID    uint       `json:"user_id"`    // Account the key acts as, defaults to the creating admin
+		ProjectID *uint      `json:"project_id"` // Optional project restriction
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, scope := range input.Scopes {
+		if !validAPIKeyScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope: " + scope, "valid_scopes": models.APIKeyScopes})
+			return
+		}
+	}
+
+	if input.UserID == 0 {
+		input.UserID = adminID
+	}
+	var owner models.User
+	if err := ctrl.DB.First(&owner, input.UserID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid User ID provided"})
+		return
+	}
+
+	if input.ProjectID != nil {
+		var project models.Project
+		if err := ctrl.DB.First(&project, *input.ProjectID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Project ID provided"})
+			return
+		}
+	}
+
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+		return
+	}
+
+	key, prefix, hash, err := utils.GenerateAPIKey()
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+
+	apiKey := models.APIKey{
+		Name:        input.Name,
+		Prefix:      prefix,
+		KeyHash:     hash,
+		Scopes:      strings.Join(input.Scopes, ","),
+		UserID:      owner.ID,
+		ProjectID:   input.ProjectID,
+		ExpiresAt:   input.ExpiresAt,
+		CreatedByID: adminID,
+	}
+	if err := ctrl.DB.WithContext(c).Create(&apiKey).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Store this key now, it cannot be shown again",
+		"key":     key,
+		"data":    apiKey,
+	})
+}
+
+// RevokeAPIKey disables a key immediately; the record is kept for the audit trail.
+func (ctrl *APIKeyController) RevokeAPIKey(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var apiKey models.APIKey
+	if err := ctrl.DB.First(&apiKey, id).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	if apiKey.RevokedAt == nil {
+		now := time.Now()
+		apiKey.RevokedAt = &now
+		if err := ctrl.DB.WithContext(c).Save(&apiKey).Error; err != nil {
+			utils.LogError("Failed", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
+
+func validAPIKeyScope(scope string) bool {
+	for _, s := range models.APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// projectAllowed reports whether the caller may act on projectID. Only API
+// keys restricted to a project are limited.
+func projectAllowed(c *gin.Context, projectID uint) bool {
+	restricted, ok := c.Get("apiKeyProjectID")
+	return !ok || restricted.(uint) == projectID
+}
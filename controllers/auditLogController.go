@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+const (
+	auditLogDefaultLimit = 50
+	auditLogMaxLimit     = 200
+	auditLogExportBatch  = 500
+)
+
+// Actions written by the data layer audit callbacks, as opposed to the
+// per-request access entries of the AuditLogger middleware.
+var auditChangeActions = []string{"create", "update", "delete", "restore"}
+
+type AuditLogController struct {
+	DB             *gorm.DB
+	CheckpointFile string
+}
+
+func NewAuditLogController(db *gorm.DB, checkpointFile string) *AuditLogController {
+	return &AuditLogController{DB: db, CheckpointFile: checkpointFile}
+}
+
+// GetAuditLogs lists audit entries newest first. Pass the returned
+// next_cursor as ?cursor= to fetch the following page.
+func (ctrl *AuditLogController) GetAuditLogs(c *gin.Context) {
+	query, err := ctrl.filteredQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctrl.respondPage(c, query)
+}
+
+// ExportAuditLogs streams every entry matching the same filters as
+// GetAuditLogs, oldest first, as CSV (default) or JSON.
+func (ctrl *AuditLogController) ExportAuditLogs(c *gin.Context) {
+	query, err := ctrl.filteredQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or json"})
+		return
+	}
+
+	filename := "audit-logs-" + time.Now().Format("20060102-150405") + "." + format
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+
+	var batches []models.AuditLog
+	var writeErr error
+	switch format {
+	case "csv":
+		c.Header("Content-Type", "text/csv")
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"id", "created_at", "user_id", "username", "api_key_id", "action", "entity", "record_id", "ip_address", "old_value", "new_value", "changes"})
+		err = query.Preload("User", auditActorColumns).FindInBatches(&batches, auditLogExportBatch, func(tx *gorm.DB, batch int) error {
+			for _, log := range batches {
+				w.Write(auditLogCSVRow(log))
+			}
+			w.Flush()
+			return w.Error()
+		}).Error
+		writeErr = w.Error()
+	case "json":
+		c.Header("Content-Type", "application/json")
+		c.Writer.WriteString("[")
+		first := true
+		err = query.Preload("User", auditActorColumns).FindInBatches(&batches, auditLogExportBatch, func(tx *gorm.DB, batch int) error {
+			for _, log := range batches {
+				data, err := json.Marshal(log)
+				if err != nil {
+					return err
+				}
+				if !first {
+					c.Writer.WriteString(",")
+				}
+				first = false
+				if _, err := c.Writer.Write(data); err != nil {
+					return err
+				}
+			}
+			c.Writer.Flush()
+			return nil
+		}).Error
+		c.Writer.WriteString("]")
+	}
+
+	// Headers are already sent at this point, so a failure can only be logged
+	if err != nil {
+		utils.LogError("Failed", err)
+	}
+	if writeErr != nil {
+		utils.LogError("Failed", writeErr)
+	}
+}
+
+// VerifyAuditLogs walks the audit hash chain and the signed checkpoints and
+// reports the first broken link, if any.
+func (ctrl *AuditLogController) VerifyAuditLogs(c *gin.Context) {
+	chain, err := utils.VerifyAuditChain(ctrl.DB)
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify audit chain"})
+		return
+	}
+	checkpoints, err := utils.VerifyAuditCheckpoints(ctrl.DB, ctrl.CheckpointFile)
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read audit checkpoints"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"valid":       chain.Valid && checkpoints.Valid,
+		"chain":       chain,
+		"checkpoints": checkpoints,
+	})
+}
+
+func (ctrl *AuditLogController) GetItemHistory(c *gin.Context) {
+	ctrl.entityHistory(c, "items")
+}
+
+func (ctrl *AuditLogController) GetProjectHistory(c *gin.Context) {
+	ctrl.entityHistory(c, "projects")
+}
+
+func (ctrl *AuditLogController) GetUserHistory(c *gin.Context) {
+	ctrl.entityHistory(c, "users")
+}
+
+// entityHistory returns the change timeline of one record, including after
+// it was deleted.
+func (ctrl *AuditLogController) entityHistory(c *gin.Context, table string) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	query := ctrl.DB.Model(&models.AuditLog{}).
+		Where("table_name = ? AND record_id = ?", table, id).
+		Where("action IN ?", auditChangeActions)
+	ctrl.respondPage(c, query)
+}
+
+// filteredQuery applies the audit log filters from the query string.
+func (ctrl *AuditLogController) filteredQuery(c *gin.Context) (*gorm.DB, error) {
+	query := ctrl.DB.Model(&models.AuditLog{})
+
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if apiKeyID := c.Query("api_key_id"); apiKeyID != "" {
+		query = query.Where("api_key_id = ?", apiKeyID)
+	}
+	if entity := c.Query("entity"); entity != "" {
+		query = query.Where("table_name = ?", entity)
+	}
+	if entityID := c.Query("entity_id"); entityID != "" {
+		query = query.Where("record_id = ?", entityID)
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if ip := c.Query("ip"); ip != "" {
+		query = query.Where("ip_address = ?", ip)
+	}
+	if c.Query("changes_only") == "true" {
+		query = query.Where("action IN ?", auditChangeActions)
+	}
+
+	if startDate := c.Query("start_date"); startDate != "" {
+		from, _, err := parseAuditTime(startDate)
+		if err != nil {
+			return nil, errors.New("start_date must be YYYY-MM-DD or RFC 3339")
+		}
+		query = query.Where("created_at >= ?", from)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		to, dateOnly, err := parseAuditTime(endDate)
+		if err != nil {
+			return nil, errors.New("end_date must be YYYY-MM-DD or RFC 3339")
+		}
+		// A plain date includes the whole day
+		if dateOnly {
+			query = query.Where("created_at < ?", to.AddDate(0, 0, 1))
+		} else {
+			query = query.Where("created_at <= ?", to)
+		}
+	}
+
+	return query, nil
+}
+
+// respondPage writes one cursor-paginated page of query, newest first.
+func (ctrl *AuditLogController) respondPage(c *gin.Context, query *gorm.DB) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(auditLogDefaultLimit)))
+	if err != nil || limit < 1 {
+		limit = auditLogDefaultLimit
+	}
+	if limit > auditLogMaxLimit {
+		limit = auditLogMaxLimit
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		before, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		query = query.Where("id < ?", before)
+	}
+
+	// One extra row tells whether another page exists
+	var logs []models.AuditLog
+	if err := query.Preload("User", auditActorColumns).Order("id desc").Limit(limit + 1).Find(&logs).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit logs"})
+		return
+	}
+
+	var nextCursor *uint
+	if len(logs) > limit {
+		logs = logs[:limit]
+		nextCursor = &logs[limit-1].ID
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": logs,
+		"meta": gin.H{
+			"limit":       limit,
+			"next_cursor": nextCursor,
+		},
+	})
+}
+
+// auditActorColumns keeps credentials of the acting user out of audit
+// responses. Deleted users are still named.
+func auditActorColumns(db *gorm.DB) *gorm.DB {
+	return db.Unscoped().Select("id", "username", "role")
+}
+
+// parseAuditTime accepts a date or an RFC 3339 timestamp and reports which
+// one it was.
+func parseAuditTime(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
+}
+
+func auditLogCSVRow(log models.AuditLog) []string {
+	optionalID := func(id *uint) string {
+		if id == nil {
+			return ""
+		}
+		return strconv.FormatUint(uint64(*id), 10)
+	}
+	return []string{
+		strconv.FormatUint(uint64(log.ID), 10),
+		log.CreatedAt.Format(time.RFC3339),
+		optionalID(log.UserID),
+		log.User.Username,
+		optionalID(log.APIKeyID),
+		log.Action,
+		log.TableName,
+		strconv.FormatUint(uint64(log.RecordID), 10),
+		log.IPAddress,
+		log.OldValue,
+		log.NewValue,
+		log.Changes,
+	}
+}
@@ -1,8 +1,12 @@
 package controllers
 
 import (
+	"fmt"
+	"math"
 	"net/http"
-	_ "time"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -12,11 +16,13 @@ import (
 )
 
 type AuthController struct {
-	DB *gorm.DB
+	DB          *gorm.DB
+	UserLimiter *utils.LoginLimiter // Failed attempts per username
+	IPLimiter   *utils.LoginLimiter // Failed attempts per client IP
 }
 
-func NewAuthController(db *gorm.DB) *AuthController {
-	return &AuthController{DB: db}
+func NewAuthController(db *gorm.DB, userLimiter, ipLimiter *utils.LoginLimiter) *AuthController {
+	return &AuthController{DB: db, UserLimiter: userLimiter, IPLimiter: ipLimiter}
 }
 
 func (ctrl *AuthController) Register(c *gin.Context) {
@@ -37,14 +43,20 @@ func (ctrl *AuthController) Register(c *gin.Context) {
 		user.Role = "user" // Default role
 	}
 
-	if err := ctrl.DB.Create(&user).Error; err != nil {
+	user.Email = normalizeEmail(user.Email)
+	// Two-factor and single sign-on are only switched on through their own flows
+	user.TOTPEnabled = false
+	user.OIDCSubject = nil
+	user.LocalLoginDisabled = false
+
+	if err := ctrl.DB.WithContext(c).Create(&user).Error; err != nil {
 		utils.LogError("Failed to create User", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
 		return
 	}
 
 	auditLog := models.AuditLog{
-		UserID:    user.ID, 
+		UserID:    &user.ID,
 		Action:    "register",
 		TableName: "users",
 		RecordID:  user.ID,
@@ -68,6 +80,22 @@ func (ctrl *AuthController) Login(c *gin.Context) {
 		return
 	}
 
+	ctx := c.Request.Context()
+	userKey := "user:" + strings.ToLower(input.Username)
+	ipKey := "ip:" + c.ClientIP()
+	if wait := maxDuration(ctrl.UserLimiter.RetryAfter(ctx, userKey), ctrl.IPLimiter.RetryAfter(ctx, ipKey)); wait > 0 {
+		auditLog := models.AuditLog{
+			Action:    "login_throttled",
+			TableName: "users",
+			NewValue:  "Throttled login attempt for username: " + input.Username,
+			IPAddress: c.ClientIP(),
+		}
+		ctrl.DB.Create(&auditLog)
+
+		respondTooManyAttempts(c, wait)
+		return
+	}
+
 	var user models.User
 	if err := ctrl.DB.Where("username = ?", input.Username).First(&user).Error; err != nil {
 		// Log failed login attempt
@@ -79,7 +107,7 @@ func (ctrl *AuthController) Login(c *gin.Context) {
 		}
 		ctrl.DB.Create(&auditLog)
 
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		ctrl.registerLoginFailure(c, userKey, ipKey)
 		return
 	}
 
@@ -88,31 +116,109 @@ func (ctrl *AuthController) Login(c *gin.Context) {
 			Action:    "login_failed",
 			TableName: "users",
 			RecordID:  user.ID,
-			NewValue:  "Failed login attempt for user ID: " + string(user.ID),
+			NewValue:  fmt.Sprintf("Failed login attempt for user ID: %d", user.ID),
 			IPAddress: c.ClientIP(),
 		}
 		ctrl.DB.Create(&auditLog)
 
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		ctrl.registerLoginFailure(c, userKey, ipKey)
 		return
 	}
 
-	token, err := utils.GenerateToken(user.ID, user.Username, user.Role)
-	if err != nil {
-		utils.LogError("Failed to generate token", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+	if user.LocalLoginDisabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password login is disabled for this account, use single sign-on"})
+		return
+	}
+
+	if user.TOTPEnabled {
+		challenge, err := utils.GenerateMFAChallengeToken(user.ID, user.TokenVersion)
+		if err != nil {
+			utils.LogError("Failed to generate MFA challenge token", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    challenge,
+		})
+		return
+	}
+
+	// The IP counter is left alone so one valid account cannot reset it
+	ctrl.UserLimiter.Reset(ctx, userKey)
+	ctrl.completeLogin(c, user, false)
+}
+
+// LoginMFA is the second step of login for users with two-factor
+// authentication: it exchanges the challenge token from Login plus a TOTP or
+// recovery code for access and refresh tokens.
+func (ctrl *AuthController) LoginMFA(c *gin.Context) {
+	var input struct {
+		MFAToken     string `json:"mfa_token" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed to bind json body", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := utils.ParseToken(input.MFAToken)
+	if err != nil || claims.Purpose != utils.MFAChallengePurpose {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	var user models.User
+	if err := ctrl.DB.First(&user, claims.UserID).Error; err != nil || claims.TokenVersion != user.TokenVersion || !user.TOTPEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	userKey := "user:" + strings.ToLower(user.Username)
+	ipKey := "ip:" + c.ClientIP()
+	if wait := maxDuration(ctrl.UserLimiter.RetryAfter(ctx, userKey), ctrl.IPLimiter.RetryAfter(ctx, ipKey)); wait > 0 {
+		respondTooManyAttempts(c, wait)
+		return
+	}
+
+	var verified bool
+	switch {
+	case input.Code != "":
+		verified = verifyTOTPCode(ctrl.DB.WithContext(c), &user, input.Code)
+	case input.RecoveryCode != "":
+		verified = useRecoveryCode(ctrl.DB.WithContext(c), user.ID, input.RecoveryCode)
+	}
+	if !verified {
+		auditLog := models.AuditLog{
+			Action:    "login_mfa_failed",
+			TableName: "users",
+			RecordID:  user.ID,
+			NewValue:  fmt.Sprintf("Failed second factor for user ID: %d", user.ID),
+			IPAddress: c.ClientIP(),
+		}
+		ctrl.DB.Create(&auditLog)
+
+		ctrl.registerLoginFailure(c, userKey, ipKey)
 		return
 	}
 
-	refreshToken, err := utils.GenerateRefreshToken(user.ID)
+	ctrl.UserLimiter.Reset(ctx, userKey)
+	ctrl.completeLogin(c, user, true)
+}
+
+func (ctrl *AuthController) completeLogin(c *gin.Context, user models.User, mfa bool) {
+	tokens, err := generateLoginTokens(user, mfa)
 	if err != nil {
-		utils.LogError("Failed to generate refresh token", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate refresh token"})
+		utils.LogError("Failed to generate token", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	auditLog := models.AuditLog{
-		UserID:    user.ID,
+		UserID:    &user.ID,
 		Action:    "login",
 		TableName: "users",
 		RecordID:  user.ID,
@@ -121,10 +227,25 @@ func (ctrl *AuthController) Login(c *gin.Context) {
 	}
 	ctrl.DB.Create(&auditLog)
 
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusOK, tokens)
+}
+
+// generateLoginTokens returns the access/refresh token pair sent to clients.
+func generateLoginTokens(user models.User, mfa bool) (gin.H, error) {
+	token, err := utils.GenerateToken(user.ID, user.Username, user.Role, user.TokenVersion, mfa)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := utils.GenerateRefreshToken(user.ID, user.TokenVersion, mfa)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
 		"token":         token,
 		"refresh_token": refreshToken,
-	})
+	}, nil
 }
 
 func (ctrl *AuthController) RefreshToken(c *gin.Context) {
@@ -137,7 +258,7 @@ func (ctrl *AuthController) RefreshToken(c *gin.Context) {
 	}
 
 	claims, err := utils.ParseToken(input.RefreshToken)
-	if err != nil {
+	if err != nil || claims.Purpose != "" {
 		utils.LogError("Failed to parse", err)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
 		return
@@ -149,13 +270,18 @@ func (ctrl *AuthController) RefreshToken(c *gin.Context) {
 		return
 	}
 
+	if claims.TokenVersion != user.TokenVersion {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token has been revoked"})
+		return
+	}
+
 	// Verify the role in the refresh token matches the user's current role
 	// if claims.Role != user.Role {
 	//     c.JSON(http.StatusUnauthorized, gin.H{"error": "Role mismatch"})
 	//     return
 	// }
 
-	token, err := utils.GenerateToken(user.ID, user.Username, user.Role)
+	token, err := utils.GenerateToken(user.ID, user.Username, user.Role, user.TokenVersion, claims.MFA)
 	if err != nil {
 		utils.LogError("Failed to generate Token", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
@@ -164,3 +290,79 @@ func (ctrl *AuthController) RefreshToken(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"token": token})
 }
+
+// JWKS publishes the public signing keys so other services can verify tokens.
+func (ctrl *AuthController) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, utils.JWKS())
+}
+
+// UnlockUser clears the failed login counters for a user, and optionally for
+// a client IP passed as ?ip=.
+func (ctrl *AuthController) UnlockUser(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var user models.User
+	if err := ctrl.DB.First(&user, id).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	ctrl.UserLimiter.Reset(ctx, "user:"+strings.ToLower(user.Username))
+	if ip := c.Query("ip"); ip != "" {
+		ctrl.IPLimiter.Reset(ctx, "ip:"+ip)
+	}
+
+	actorID := c.MustGet("userID").(uint)
+	auditLog := models.AuditLog{
+		UserID:    &actorID,
+		Action:    "unlock_user",
+		TableName: "users",
+		RecordID:  user.ID,
+		NewValue:  "Login lockout cleared",
+		IPAddress: c.ClientIP(),
+	}
+	ctrl.DB.Create(&auditLog)
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
+
+func (ctrl *AuthController) registerLoginFailure(c *gin.Context, userKey, ipKey string) {
+	ctx := c.Request.Context()
+	wait := maxDuration(ctrl.UserLimiter.RegisterFailure(ctx, userKey), ctrl.IPLimiter.RegisterFailure(ctx, ipKey))
+	if wait > 0 {
+		respondTooManyAttempts(c, wait)
+		return
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+}
+
+func respondTooManyAttempts(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Too many failed login attempts, please try again later",
+		"retry_after": seconds,
+	})
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// normalizeEmail lower-cases an address and maps blank input to NULL so it
+// does not collide with other accounts that have no email.
+func normalizeEmail(email *string) *string {
+	if email == nil {
+		return nil
+	}
+	trimmed := strings.ToLower(strings.TrimSpace(*email))
+	if trimmed == "" {
+		return nil
+	}
+	return &trimmed
+}
@@ -0,0 +1,769 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+type BoxController struct {
+	DB *gorm.DB
+}
+
+func NewBoxController(db *gorm.DB) *BoxController {
+	return &BoxController{DB: db}
+}
+
+type BoxInput struct {
+	Number      uint   `json:"number" binding:"required"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type BoxItemInput struct {
+	ItemID   uint `json:"item_id" binding:"required"`
+	Quantity int  `json:"quantity" binding:"required,min=1"`
+}
+
+type BoxContentsInput struct {
+	Serials []string       `json:"serials"`
+	Items   []BoxItemInput `json:"items"`
+}
+
+type SealInput struct {
+	SealNumber string `json:"seal_number"`
+	Note       string `json:"note"`
+}
+
+type BoxBorrowInput struct {
+	ProjectID  uint   `json:"project_id" binding:"required"`
+	BorrowDate string `json:"borrow_date" binding:"required"`
+	DueDate    string `json:"due_date" binding:"required"`
+}
+
+// BoxCheckInInput is what was found in a box: the scanned serial numbers
+// and counted accessories. Giving the number of a still intact seal instead
+// counts everything as present.
+type BoxCheckInInput struct {
+	Serials    []string       `json:"serials"`
+	Items      []BoxItemInput `json:"items"`
+	SealNumber string         `json:"seal_number"`
+	Note       string         `json:"note"`
+	ReturnDate string         `json:"return_date"`          // Required when returning the box
+	Usage      []UsageInput   `json:"usage" binding:"dive"` // Use of the serials while out, when returning the box
+}
+
+// boxShortage is content missing from a box at check-in.
+type boxShortage struct {
+	ItemID       uint   `json:"item_id"`
+	ItemName     string `json:"item_name"`
+	SerialNumber string `json:"serial_number,omitempty"`
+	Missing      int    `json:"missing"`
+}
+
+// boxCheckIn compares what was found in a box with what it should hold.
+type boxCheckIn struct {
+	Complete   bool          `json:"complete"`
+	SealIntact bool          `json:"seal_intact"`
+	Missing    []boxShortage `json:"missing"`
+	Unexpected []string      `json:"unexpected_serials"` // Scanned but not packed in this box
+	present    map[uint]int  // Quantity found per item
+}
+
+func (ctrl *BoxController) GetBoxes(c *gin.Context) {
+	query := ctrl.DB.Preload("Items.Item").Order("number")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var boxes []models.Box
+	if err := query.Find(&boxes).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch boxes"})
+		return
+	}
+	c.JSON(http.StatusOK, boxes)
+}
+
+func (ctrl *BoxController) GetBoxByID(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var box models.Box
+	if err := ctrl.DB.Preload("Items.Item").First(&box, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Box not found"})
+		return
+	}
+
+	serials, err := boxSerials(ctrl.DB, box)
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch box contents"})
+		return
+	}
+
+	response := gin.H{"box": box, "code": utils.BoxCode(box.Number), "serials": serials}
+	var loan models.BoxLoan
+	if err := ctrl.DB.Preload("Project").Preload("User", auditActorColumns).
+		Where("box_id = ? AND returned_at IS NULL", box.ID).Last(&loan).Error; err == nil {
+		response["loan"] = loan
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (ctrl *BoxController) CreateBox(c *gin.Context) {
+	var input BoxInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var count int64
+	ctrl.DB.Model(&models.Box{}).Where("number = ?", input.Number).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A box with this number already exists"})
+		return
+	}
+
+	box := models.Box{Number: input.Number, Name: input.Name, Description: input.Description, Status: models.BoxAvailable}
+	if box.Name == "" {
+		box.Name = utils.BoxCode(box.Number)
+	}
+	if err := ctrl.DB.WithContext(c).Create(&box).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create box"})
+		return
+	}
+	c.JSON(http.StatusCreated, box)
+}
+
+func (ctrl *BoxController) UpdateBox(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var box models.Box
+	if err := ctrl.DB.First(&box, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Box not found"})
+		return
+	}
+
+	var input BoxInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Serials refer to the box by number, so renumbering would unpack them
+	if input.Number != box.Number {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The box number cannot be changed"})
+		return
+	}
+
+	box.Name = input.Name
+	box.Description = input.Description
+	if err := ctrl.DB.WithContext(c).Save(&box).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update box"})
+		return
+	}
+	c.JSON(http.StatusOK, box)
+}
+
+func (ctrl *BoxController) DeleteBox(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var box models.Box
+	if err := ctrl.DB.First(&box, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Box not found"})
+		return
+	}
+	if box.Status == models.BoxBorrowed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Box is borrowed"})
+		return
+	}
+	var loans int64
+	ctrl.DB.Model(&models.BoxLoan{}).Where("box_id = ?", box.ID).Count(&loans)
+	if loans > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Box has been borrowed and is kept for its loan history"})
+		return
+	}
+
+	// Deleted for good so its number can be given to a new box
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Warranty{}).Where("box_id = ?", box.Number).Update("box_id", 0).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("box_id = ?", box.ID).Delete(&models.BoxItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("box_id = ?", box.ID).Delete(&models.BoxEvent{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&box).Error
+	})
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete box"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Box deleted successfully"})
+}
+
+// SetBoxContents replaces the serials and accessories packed in a box.
+func (ctrl *BoxController) SetBoxContents(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input BoxContentsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var box models.Box
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&box, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Box not found"}
+		}
+		if box.Status == models.BoxBorrowed {
+			return stockError{http.StatusConflict, "Box is borrowed"}
+		}
+		if box.SealNumber != "" {
+			return stockError{http.StatusConflict, "Box is sealed; unseal it before repacking"}
+		}
+
+		if len(input.Serials) > 0 {
+			var warranties []models.Warranty
+			if err := tx.Where("serial_number IN ?", input.Serials).Find(&warranties).Error; err != nil {
+				return err
+			}
+			found := make(map[string]models.Warranty, len(warranties))
+			for _, w := range warranties {
+				found[w.SerialNumber] = w
+			}
+			for _, serial := range input.Serials {
+				w, ok := found[serial]
+				if !ok {
+					return stockError{http.StatusBadRequest, fmt.Sprintf("Serial number %s not found", serial)}
+				}
+				if w.BoxID != 0 && w.BoxID != box.Number {
+					var other models.Box
+					if tx.Where("number = ?", w.BoxID).First(&other).Error == nil && other.Status == models.BoxBorrowed {
+						return stockError{http.StatusConflict, fmt.Sprintf("Serial number %s is in borrowed box %s", serial, utils.BoxCode(other.Number))}
+					}
+				}
+			}
+		}
+
+		unpack := tx.Model(&models.Warranty{}).Where("box_id = ?", box.Number)
+		if len(input.Serials) > 0 {
+			unpack = unpack.Where("serial_number NOT IN ?", input.Serials)
+		}
+		if err := unpack.Update("box_id", 0).Error; err != nil {
+			return err
+		}
+		if len(input.Serials) > 0 {
+			if err := tx.Model(&models.Warranty{}).Where("serial_number IN ?", input.Serials).Update("box_id", box.Number).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Unscoped().Where("box_id = ?", box.ID).Delete(&models.BoxItem{}).Error; err != nil {
+			return err
+		}
+		quantities := make(map[uint]int)
+		for _, in := range input.Items {
+			quantities[in.ItemID] += in.Quantity
+		}
+		for _, itemID := range sortedItemIDs(quantities) {
+			var item models.Item
+			if err := tx.First(&item, itemID).Error; err != nil {
+				return stockError{http.StatusBadRequest, fmt.Sprintf("Item %d not found", itemID)}
+			}
+			if err := tx.Create(&models.BoxItem{BoxID: box.ID, ItemID: itemID, Quantity: quantities[itemID]}).Error; err != nil {
+				return err
+			}
+		}
+
+		box.Status = models.BoxAvailable
+		return tx.Save(&box).Error
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to update box contents")
+		return
+	}
+
+	ctrl.DB.Preload("Items.Item").First(&box, box.ID)
+	serials, _ := boxSerials(ctrl.DB, box)
+	c.JSON(http.StatusOK, gin.H{"box": box, "serials": serials})
+}
+
+func (ctrl *BoxController) SealBox(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input SealInput
+	if err := c.ShouldBindJSON(&input); err != nil || input.SealNumber == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "seal_number is required"})
+		return
+	}
+
+	var box models.Box
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&box, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Box not found"}
+		}
+		if box.SealNumber != "" {
+			return stockError{http.StatusConflict, "Box is already sealed with " + box.SealNumber}
+		}
+		now := time.Now()
+		box.SealNumber = input.SealNumber
+		box.SealedAt = &now
+		if err := tx.Save(&box).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.BoxEvent{BoxID: box.ID, Type: models.BoxEventSeal, UserID: userID, SealNumber: input.SealNumber, Note: input.Note}).Error
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to seal box")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Box sealed successfully", "box": box})
+}
+
+func (ctrl *BoxController) UnsealBox(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input SealInput
+	c.ShouldBindJSON(&input)
+
+	var box models.Box
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&box, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Box not found"}
+		}
+		if box.SealNumber == "" {
+			return stockError{http.StatusConflict, "Box is not sealed"}
+		}
+		event := models.BoxEvent{BoxID: box.ID, Type: models.BoxEventUnseal, UserID: userID, SealNumber: box.SealNumber, Note: input.Note}
+		box.SealNumber = ""
+		box.SealedAt = nil
+		if err := tx.Save(&box).Error; err != nil {
+			return err
+		}
+		return tx.Create(&event).Error
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to unseal box")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Box unsealed successfully", "box": box})
+}
+
+func (ctrl *BoxController) GetBoxEvents(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var events []models.BoxEvent
+	if err := ctrl.DB.Preload("User", auditActorColumns).Where("box_id = ?", id).Order("id desc").Find(&events).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch box events"})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// BorrowBox borrows everything packed in a box for a project in one
+// transaction, with one borrow transaction per item.
+func (ctrl *BoxController) BorrowBox(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input BoxBorrowInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !projectAllowed(c, input.ProjectID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: API key is restricted to another project"})
+		return
+	}
+
+	var loan models.BoxLoan
+	var itemIDs []uint
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var box models.Box
+		if err := tx.Preload("Items").First(&box, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Box not found"}
+		}
+		var project models.Project
+		if err := tx.First(&project, input.ProjectID).Error; err != nil {
+			return stockError{http.StatusBadRequest, "Invalid project ID provided"}
+		}
+
+		// Only one request can move the box out of the available state
+		result := tx.Model(&models.Box{}).Where("id = ? AND status = ?", box.ID, models.BoxAvailable).Update("status", models.BoxBorrowed)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return stockError{http.StatusConflict, fmt.Sprintf("Box is %s", box.Status)}
+		}
+
+		serials, err := boxSerials(tx, box)
+		if err != nil {
+			return err
+		}
+		quantities := boxQuantities(serials, box.Items)
+		if len(quantities) == 0 {
+			return stockError{http.StatusBadRequest, "Box is empty"}
+		}
+		if err := checkSerialsMaintenance(tx, serials); err != nil {
+			return err
+		}
+
+		loan = models.BoxLoan{BoxID: box.ID, ProjectID: project.ID, UserID: userID, BorrowDate: input.BorrowDate, DueDate: input.DueDate}
+		if err := tx.Create(&loan).Error; err != nil {
+			return err
+		}
+
+		itemIDs = sortedItemIDs(quantities)
+		if err := checkNotCounting(tx, itemIDs...); err != nil {
+			return err
+		}
+		for _, itemID := range itemIDs {
+			quantity := quantities[itemID]
+			var item models.Item
+			if err := tx.Scopes(forUpdate).First(&item, itemID).Error; err != nil {
+				return stockError{http.StatusBadRequest, fmt.Sprintf("Item %d in the box no longer exists", itemID)}
+			}
+			if item.Quantity < quantity {
+				return stockError{http.StatusBadRequest, fmt.Sprintf("Not enough %s in stock. Available: %d", item.Name, item.Quantity)}
+			}
+			if err := pickStock(tx, item, quantity, nil); err != nil {
+				return err
+			}
+			lots, err := allocateLots(tx, item, quantity, nil)
+			if err != nil {
+				return err
+			}
+			item.Quantity -= quantity
+			if err := tx.Save(&item).Error; err != nil {
+				return err
+			}
+			borrow := models.TransactionBorrow{
+				UserID:         userID,
+				ItemID:         itemID,
+				ProjectID:      project.ID,
+				BorrowQuantity: quantity,
+				BorrowDate:     input.BorrowDate,
+				DueDate:        input.DueDate,
+				BoxLoanID:      &loan.ID,
+			}
+			if err := tx.Create(&borrow).Error; err != nil {
+				return err
+			}
+			if err := recordBorrowLots(tx, borrow.ID, lots); err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&models.BoxEvent{BoxID: box.ID, Type: models.BoxEventBorrow, UserID: userID, SealNumber: box.SealNumber}).Error
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to borrow box")
+		return
+	}
+
+	for _, itemID := range itemIDs {
+		evaluateLowStock(ctrl.DB.WithContext(c), itemID)
+	}
+	ctrl.DB.Preload("Box").Preload("Borrows.Item").First(&loan, loan.ID)
+	c.JSON(http.StatusCreated, gin.H{"message": "Box borrowed successfully", "loan": loan})
+}
+
+// CheckInBox compares the scanned contents of a box with what it should
+// hold, without returning anything.
+func (ctrl *BoxController) CheckInBox(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input BoxCheckInInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var box models.Box
+	if err := ctrl.DB.Preload("Items.Item").First(&box, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Box not found"})
+		return
+	}
+	report, err := ctrl.checkIn(ctrl.DB, box, input)
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check box contents"})
+		return
+	}
+
+	event := models.BoxEvent{BoxID: box.ID, Type: models.BoxEventCheckIn, UserID: userID, SealNumber: input.SealNumber, Note: input.Note, Missing: missingJSON(report)}
+	if err := ctrl.DB.WithContext(c).Create(&event).Error; err != nil {
+		utils.LogError("Failed", err)
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// ReturnBox checks a borrowed box in and returns what was found. Missing
+// contents stay outstanding on their borrow transactions and the box is
+// flagged incomplete until it is repacked.
+func (ctrl *BoxController) ReturnBox(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input BoxCheckInInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.ReturnDate == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "return_date is required"})
+		return
+	}
+
+	var loan models.BoxLoan
+	var report boxCheckIn
+	var itemIDs []uint
+	var workOrders []models.WorkOrder
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var box models.Box
+		if err := tx.Preload("Items.Item").First(&box, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Box not found"}
+		}
+		if err := tx.Scopes(forUpdate).Preload("Borrows").Where("box_id = ? AND returned_at IS NULL", box.ID).Last(&loan).Error; err != nil {
+			return stockError{http.StatusConflict, "Box is not borrowed"}
+		}
+		if !projectAllowed(c, loan.ProjectID) {
+			return stockError{http.StatusForbidden, "Forbidden: API key is restricted to another project"}
+		}
+
+		borrowedIDs := make([]uint, 0, len(loan.Borrows))
+		for _, borrow := range loan.Borrows {
+			borrowedIDs = append(borrowedIDs, borrow.ItemID)
+		}
+		if err := checkNotCounting(tx, borrowedIDs...); err != nil {
+			return err
+		}
+
+		var err error
+		if report, err = ctrl.checkIn(tx, box, input); err != nil {
+			return err
+		}
+
+		for _, borrow := range loan.Borrows {
+			// Units returned earlier are not expected back again
+			earlier, err := returnedQuantity(tx, borrow.ID)
+			if err != nil {
+				return err
+			}
+			returned := report.present[borrow.ItemID]
+			if outstanding := borrow.BorrowQuantity - earlier; returned > outstanding {
+				returned = outstanding
+			}
+			if returned < 0 {
+				returned = 0
+			}
+			report.present[borrow.ItemID] -= returned
+			if returned == 0 {
+				continue
+			}
+
+			if err := restoreLots(tx, borrow.ID, returned); err != nil {
+				return err
+			}
+			var item models.Item
+			if err := tx.Scopes(forUpdate).First(&item, borrow.ItemID).Error; err != nil {
+				return err
+			}
+			item.Quantity += returned
+			if err := tx.Save(&item).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&models.TransactionReturn{
+				UserID:         userID,
+				ItemID:         borrow.ItemID,
+				ProjectID:      borrow.ProjectID,
+				ReturnQuantity: returned,
+				ReturnDate:     input.ReturnDate,
+				BorrowID:       borrow.ID,
+			}).Error; err != nil {
+				return err
+			}
+			itemIDs = append(itemIDs, item.ID)
+		}
+
+		borrowed := make([]uint, 0, len(loan.Borrows))
+		for _, borrow := range loan.Borrows {
+			borrowed = append(borrowed, borrow.ItemID)
+		}
+		if workOrders, err = recordUsage(tx, userID, input.Usage, borrowed, models.UsageLog{BoxLoanID: &loan.ID}); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		loan.ReturnDate = input.ReturnDate
+		loan.ReturnedAt = &now
+		if err := tx.Omit(clause.Associations).Save(&loan).Error; err != nil {
+			return err
+		}
+
+		box.Status = models.BoxAvailable
+		if !report.Complete {
+			box.Status = models.BoxIncomplete
+		}
+		if !report.SealIntact {
+			box.SealNumber = ""
+			box.SealedAt = nil
+		}
+		if err := tx.Omit(clause.Associations).Save(&box).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.BoxEvent{BoxID: box.ID, Type: models.BoxEventReturn, UserID: userID, SealNumber: input.SealNumber, Note: input.Note, Missing: missingJSON(report)}).Error
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to return box")
+		return
+	}
+
+	for _, itemID := range itemIDs {
+		evaluateLowStock(ctrl.DB.WithContext(c), itemID)
+	}
+	ctrl.DB.Preload("Box").Preload("Borrows.Item").First(&loan, loan.ID)
+	message := "Box returned successfully"
+	if !report.Complete {
+		message = "Box returned with missing contents"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "loan": loan, "check_in": report, "work_orders": workOrders})
+}
+
+// checkIn compares input with the contents of box. A seal that is still
+// the one the box was sealed with counts every content as present.
+func (ctrl *BoxController) checkIn(tx *gorm.DB, box models.Box, input BoxCheckInInput) (boxCheckIn, error) {
+	report := boxCheckIn{Complete: true, Missing: []boxShortage{}, Unexpected: []string{}, present: make(map[uint]int)}
+	serials, err := boxSerials(tx, box)
+	if err != nil {
+		return report, err
+	}
+	report.SealIntact = box.SealNumber != "" && input.SealNumber == box.SealNumber
+
+	names := make(map[uint]string)
+	var itemIDs []uint
+	for _, w := range serials {
+		itemIDs = append(itemIDs, w.DroneID)
+	}
+	if len(itemIDs) > 0 {
+		var items []models.Item
+		if err := tx.Select("id", "name").Where("id IN ?", itemIDs).Find(&items).Error; err != nil {
+			return report, err
+		}
+		for _, item := range items {
+			names[item.ID] = item.Name
+		}
+	}
+
+	scanned := make(map[string]bool, len(input.Serials))
+	for _, serial := range input.Serials {
+		scanned[serial] = true
+	}
+	packed := make(map[string]bool, len(serials))
+	for _, w := range serials {
+		packed[w.SerialNumber] = true
+		if report.SealIntact || scanned[w.SerialNumber] {
+			report.present[w.DroneID]++
+			continue
+		}
+		report.Missing = append(report.Missing, boxShortage{ItemID: w.DroneID, ItemName: names[w.DroneID], SerialNumber: w.SerialNumber, Missing: 1})
+	}
+	for _, serial := range input.Serials {
+		if !packed[serial] {
+			report.Unexpected = append(report.Unexpected, serial)
+		}
+	}
+
+	counted := make(map[uint]int)
+	for _, in := range input.Items {
+		counted[in.ItemID] += in.Quantity
+	}
+	for _, content := range box.Items {
+		found := counted[content.ItemID]
+		if report.SealIntact || found > content.Quantity {
+			found = content.Quantity
+		}
+		report.present[content.ItemID] += found
+		if found < content.Quantity {
+			report.Missing = append(report.Missing, boxShortage{ItemID: content.ItemID, ItemName: content.Item.Name, Missing: content.Quantity - found})
+		}
+	}
+
+	report.Complete = len(report.Missing) == 0
+	return report, nil
+}
+
+// boxSerials returns the serialized units packed in box.
+func boxSerials(tx *gorm.DB, box models.Box) ([]models.Warranty, error) {
+	var serials []models.Warranty
+	err := tx.Where("box_id = ?", box.Number).Order("serial_number").Find(&serials).Error
+	return serials, err
+}
+
+// boxQuantities adds up the serials and accessories of a box per item.
+func boxQuantities(serials []models.Warranty, items []models.BoxItem) map[uint]int {
+	quantities := make(map[uint]int)
+	for _, w := range serials {
+		quantities[w.DroneID]++
+	}
+	for _, content := range items {
+		quantities[content.ItemID] += content.Quantity
+	}
+	return quantities
+}
+
+// sortedItemIDs orders item rows so concurrent transactions touch them in
+// the same order.
+func sortedItemIDs(quantities map[uint]int) []uint {
+	ids := make([]uint, 0, len(quantities))
+	for id := range quantities {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func missingJSON(report boxCheckIn) string {
+	if len(report.Missing) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(report.Missing)
+	if err != nil {
+		utils.LogError("Failed", err)
+		return ""
+	}
+	return string(data)
+}
+
+// ensureBoxes creates the boxes that warranties refer to but that were
+// never registered, e.g. after a spreadsheet import.
+func ensureBoxes(db *gorm.DB, numbers []uint) error {
+	seen := make(map[uint]bool)
+	for _, number := range numbers {
+		if number == 0 || seen[number] {
+			continue
+		}
+		seen[number] = true
+		box := models.Box{Number: number, Name: utils.BoxCode(number), Status: models.BoxAvailable}
+		if err := db.Where("number = ?", number).FirstOrCreate(&box).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
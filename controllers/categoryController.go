@@ -25,8 +25,16 @@ func (ctrl *CategoryController) CreateCategory(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := validateStockLevels(category.DefaultReorderPoint, category.DefaultTargetLevel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateDepreciation(category); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	if err := ctrl.DB.Create(&category).Error; err != nil {
+	if err := ctrl.DB.WithContext(c).Create(&category).Error; err != nil {
 		utils.LogError("Failed", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
 		return
@@ -71,20 +79,42 @@ func (ctrl *CategoryController) UpdateCategory(c *gin.Context) {
 		return
 	}
 
+	if err := validateStockLevels(input.DefaultReorderPoint, input.DefaultTargetLevel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateDepreciation(input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	category.Name = input.Name
 	category.Description = input.Description
+	category.DefaultReorderPoint = input.DefaultReorderPoint
+	category.DefaultTargetLevel = input.DefaultTargetLevel
+	category.DepreciationMethod = input.DepreciationMethod
+	category.UsefulLifeMonths = input.UsefulLifeMonths
+	category.DepreciationRate = input.DepreciationRate
+	category.SalvageRate = input.SalvageRate
 
-	if err := ctrl.DB.Save(&category).Error; err != nil {
+	if err := ctrl.DB.WithContext(c).Save(&category).Error; err != nil {
 		utils.LogError("Failed", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category"})
 		return
 	}
+
+	// New defaults can move items of this category below or above their reorder point
+	var itemIDs []uint
+	ctrl.DB.Model(&models.Item{}).Where("category_id = ?", category.ID).Pluck("id", &itemIDs)
+	for _, itemID := range itemIDs {
+		evaluateLowStock(ctrl.DB.WithContext(c), itemID)
+	}
 	c.JSON(http.StatusOK, category)
 }
 
 func (ctrl *CategoryController) DeleteCategory(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
-	if err := ctrl.DB.Delete(&models.Category{}, id).Error; err != nil {
+	if err := ctrl.DB.WithContext(c).Delete(&models.Category{}, id).Error; err != nil {
 		utils.LogError("Failed", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
 		return
@@ -33,10 +33,17 @@ func (ctrl *DamageReportController) CreateDamageReport(c *gin.Context) {
 		return
 	}
 
+	if !projectAllowed(c, report.ProjectID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: API key is restricted to another project"})
+		return
+	}
+
 	report.ReporterID = reporterID
 	report.Status = "Pending" 
+	report.WrittenOffAt = nil
+	report.WrittenOffValue = 0
 
-	tx := ctrl.DB.Begin()
+	tx := ctrl.DB.WithContext(c).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback()
@@ -73,7 +80,11 @@ func (ctrl *DamageReportController) CreateDamageReport(c *gin.Context) {
 func (ctrl *DamageReportController) GetDamageReports(c *gin.Context) {
 	var reports []models.DamageReport
 	// New: Preload Item.Category
-	if err := ctrl.DB.Preload("Item.Category").Preload("Reporter").Preload("Project").Find(&reports).Error; err != nil {
+	query := ctrl.DB.Preload("Item.Category").Preload("Reporter").Preload("Project")
+	if projectID, restricted := c.Get("apiKeyProjectID"); restricted {
+		query = query.Where("project_id = ?", projectID)
+	}
+	if err := query.Find(&reports).Error; err != nil {
 		utils.LogError("Failed", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch damage reports"})
 		return
@@ -89,6 +100,10 @@ func (ctrl *DamageReportController) UpdateDamageReportStatus(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Damage report not found"})
 		return
 	}
+	if !projectAllowed(c, report.ProjectID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: API key is restricted to another project"})
+		return
+	}
 
 	var input struct {
 		Description  string  `json:"description"`
@@ -110,7 +125,13 @@ func (ctrl *DamageReportController) UpdateDamageReportStatus(c *gin.Context) {
     report.Broken_Drone = *input.Broken_Drone
 }
 
-	if err := ctrl.DB.Save(&report).Error; err != nil {
+	if err := valueWriteOff(ctrl.DB, &report); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update damage report"})
+		return
+	}
+
+	if err := ctrl.DB.WithContext(c).Save(&report).Error; err != nil {
 		utils.LogError("Failed", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update damage report"})
 		return
@@ -0,0 +1,469 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+type DepreciationController struct {
+	DB *gorm.DB
+}
+
+func NewDepreciationController(db *gorm.DB) *DepreciationController {
+	return &DepreciationController{DB: db}
+}
+
+type DepreciationRunInput struct {
+	Period string `json:"period" binding:"required"` // YYYY-MM, the ended month after the last run
+}
+
+// assetValue is the book value of one serialized asset on a date.
+type assetValue struct {
+	WarrantyID      uint    `json:"warranty_id"`
+	SerialNumber    string  `json:"serial_number"`
+	ItemID          uint    `json:"item_id"`
+	ItemName        string  `json:"item_name"`
+	CategoryID      uint    `json:"category_id"`
+	Method          string  `json:"method"`
+	AcquisitionDate string  `json:"acquisition_date"`
+	Cost            float64 `json:"cost"`
+	Months          int     `json:"months"` // Months depreciated so far
+	Accumulated     float64 `json:"accumulated"`
+	BookValue       float64 `json:"book_value"`
+}
+
+type categoryBookValue struct {
+	CategoryID  uint    `json:"category_id"`
+	Name        string  `json:"name"`
+	Assets      int     `json:"assets"`
+	Cost        float64 `json:"cost"`
+	Accumulated float64 `json:"accumulated"`
+	BookValue   float64 `json:"book_value"`
+}
+
+// depreciableAsset is a serialized unit of an item whose category is
+// depreciated, with the date and cost depreciation starts from.
+type depreciableAsset struct {
+	Warranty models.Warranty
+	Item     models.Item
+	Start    time.Time
+	Cost     float64
+}
+
+// GetBookValue returns the book value of every depreciated asset and the
+// totals per category as of as_of (YYYY-MM-DD, today when not given).
+func (ctrl *DepreciationController) GetBookValue(c *gin.Context) {
+	asOf := today()
+	if value := c.Query("as_of"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "as_of must be YYYY-MM-DD"})
+			return
+		}
+		asOf = parsed
+	}
+
+	assets, undated, err := depreciableAssets(ctrl.DB.WithContext(c), asOf, c.Query("category_id"))
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute book value"})
+		return
+	}
+
+	values := make([]assetValue, 0, len(assets))
+	categories := make([]categoryBookValue, 0)
+	index := make(map[uint]int)
+	var cost, accumulated, bookValue float64
+	unvalued := 0
+	for _, asset := range assets {
+		category := asset.Item.Category
+		months, value := bookValueAt(asset, category, asOf)
+		values = append(values, assetValue{
+			WarrantyID:      asset.Warranty.ID,
+			SerialNumber:    asset.Warranty.SerialNumber,
+			ItemID:          asset.Item.ID,
+			ItemName:        asset.Item.Name,
+			CategoryID:      category.ID,
+			Method:          category.DepreciationMethod,
+			AcquisitionDate: asset.Start.Format("2006-01-02"),
+			Cost:            roundMoney(asset.Cost),
+			Months:          months,
+			Accumulated:     roundMoney(asset.Cost - value),
+			BookValue:       roundMoney(value),
+		})
+
+		i, ok := index[category.ID]
+		if !ok {
+			i = len(categories)
+			index[category.ID] = i
+			categories = append(categories, categoryBookValue{CategoryID: category.ID, Name: category.Name})
+		}
+		categories[i].Assets++
+		categories[i].Cost = roundMoney(categories[i].Cost + asset.Cost)
+		categories[i].Accumulated = roundMoney(categories[i].Accumulated + asset.Cost - value)
+		categories[i].BookValue = roundMoney(categories[i].BookValue + value)
+		cost += asset.Cost
+		accumulated += asset.Cost - value
+		bookValue += value
+		if asset.Cost == 0 {
+			unvalued++
+		}
+	}
+
+	var lastRun models.DepreciationRun
+	postedThrough := ""
+	if err := ctrl.DB.Order("period desc").Limit(1).Find(&lastRun).Error; err == nil {
+		postedThrough = lastRun.Period
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"as_of":          asOf.Format("2006-01-02"),
+		"assets":         values,
+		"categories":     categories,
+		"cost":           roundMoney(cost),
+		"accumulated":    roundMoney(accumulated),
+		"book_value":     roundMoney(bookValue),
+		"unvalued":       unvalued,
+		"undated":        undated,
+		"posted_through": postedThrough,
+	})
+}
+
+// RunDepreciation posts the depreciation of the next month that has ended.
+func (ctrl *DepreciationController) RunDepreciation(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var input DepreciationRunInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	run, err := runDepreciation(ctrl.DB.WithContext(c), input.Period, &userID)
+	if err != nil {
+		respondStockError(c, err, "Failed to run depreciation")
+		return
+	}
+	c.JSON(http.StatusCreated, run)
+}
+
+func (ctrl *DepreciationController) GetDepreciationRuns(c *gin.Context) {
+	var runs []models.DepreciationRun
+	if err := ctrl.DB.Preload("User", auditActorColumns).Order("period desc").Find(&runs).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch depreciation runs"})
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
+
+func (ctrl *DepreciationController) GetDepreciationRunByID(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var run models.DepreciationRun
+	err := ctrl.DB.Preload("User", auditActorColumns).
+		Preload("Entries", func(db *gorm.DB) *gorm.DB { return db.Order("warranty_id") }).
+		Preload("Entries.Warranty").First(&run, id).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Depreciation run not found"})
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+// StartDepreciationRuns posts, at startup and then every interval, each
+// month that has ended without a depreciation run. An interval of zero
+// disables the scheduled run.
+func StartDepreciationRuns(db *gorm.DB, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		postMissingDepreciation(db)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			postMissingDepreciation(db)
+		}
+	}()
+}
+
+// postMissingDepreciation walks forward from the month after the last
+// posted run, or from the month of the earliest acquisition when nothing
+// was posted yet, and runs every month that has ended. It stops at the
+// first failure so months are always posted in order.
+func postMissingDepreciation(db *gorm.DB) {
+	month, ok, err := nextDepreciationPeriod(db)
+	if err != nil {
+		utils.LogError("Failed to check depreciation runs", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	for month.AddDate(0, 1, -1).Before(today()) {
+		run, err := runDepreciation(db, month.Format("2006-01"), nil)
+		var se stockError
+		if errors.As(err, &se) && se.Status == http.StatusConflict {
+			// Posted by hand or by another instance in the meantime
+			next, ok, err := nextDepreciationPeriod(db)
+			if err != nil {
+				utils.LogError("Failed to check depreciation runs", err)
+				return
+			}
+			if !ok || !next.After(month) {
+				utils.LogError("Failed to run depreciation", se)
+				return
+			}
+			month = next
+			continue
+		}
+		if err != nil {
+			utils.LogError("Failed to run depreciation", err)
+			return
+		}
+		utils.LogInfo("Depreciation posted", zap.String("period", run.Period), zap.Int("assets", run.Assets), zap.Float64("amount", run.Amount))
+		month = month.AddDate(0, 1, 0)
+	}
+}
+
+// nextDepreciationPeriod is the first day of the month that has to be posted
+// next: the month after the last posted run, or the month of the earliest
+// acquisition when nothing was posted yet. ok is false when there is neither.
+func nextDepreciationPeriod(db *gorm.DB) (month time.Time, ok bool, err error) {
+	var last models.DepreciationRun
+	result := db.Order("period desc").Limit(1).Find(&last)
+	if result.Error != nil {
+		return time.Time{}, false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		posted, err := time.Parse("2006-01", last.Period)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		return posted.AddDate(0, 1, 0), true, nil
+	}
+
+	assets, _, err := depreciableAssets(db, today(), "")
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	for _, asset := range assets {
+		if month.IsZero() || asset.Start.Before(month) {
+			month = asset.Start
+		}
+	}
+	if month.IsZero() {
+		return time.Time{}, false, nil
+	}
+	return time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC), true, nil
+}
+
+// runDepreciation posts an entry for every asset that lost value in period,
+// the difference between its book value at the end of the month before and
+// at the end of the month. Months are posted in order, so period has to be
+// the one nextDepreciationPeriod expects. userID is nil for the scheduled run.
+func runDepreciation(db *gorm.DB, period string, userID *uint) (*models.DepreciationRun, error) {
+	month, err := time.Parse("2006-01", period)
+	if err != nil {
+		return nil, stockError{http.StatusBadRequest, "period must be YYYY-MM"}
+	}
+	closing := month.AddDate(0, 1, -1)
+	if !closing.Before(today()) {
+		return nil, stockError{http.StatusBadRequest, fmt.Sprintf("%s has not ended yet", period)}
+	}
+	opening := month.AddDate(0, 0, -1)
+
+	run := models.DepreciationRun{Period: period, UserID: userID}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var posted int64
+		if err := tx.Model(&models.DepreciationRun{}).Where("period = ?", period).Count(&posted).Error; err != nil {
+			return err
+		}
+		if posted > 0 {
+			return stockError{http.StatusConflict, fmt.Sprintf("Depreciation for %s has already been posted", period)}
+		}
+		next, ok, err := nextDepreciationPeriod(tx)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return stockError{http.StatusConflict, "There are no assets to depreciate yet"}
+		}
+		if !month.Equal(next) {
+			return stockError{http.StatusConflict, fmt.Sprintf("Depreciation is posted month by month; the next period is %s", next.Format("2006-01"))}
+		}
+
+		assets, _, err := depreciableAssets(tx, closing, "")
+		if err != nil {
+			return err
+		}
+		for _, asset := range assets {
+			category := asset.Item.Category
+			_, before := bookValueAt(asset, category, opening)
+			_, after := bookValueAt(asset, category, closing)
+			amount := roundMoney(before - after)
+			if amount == 0 {
+				continue
+			}
+			run.Entries = append(run.Entries, models.DepreciationEntry{
+				WarrantyID:   asset.Warranty.ID,
+				Period:       period,
+				OpeningValue: roundMoney(before),
+				Amount:       amount,
+				BookValue:    roundMoney(after),
+			})
+			run.Amount = roundMoney(run.Amount + amount)
+		}
+		run.Assets = len(run.Entries)
+		return tx.Create(&run).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// depreciableAssets loads the serialized units acquired by asOf whose
+// category has a depreciation method. Units without an acquisition date or
+// a BuyDate that parses are left out and counted as undated.
+func depreciableAssets(db *gorm.DB, asOf time.Time, categoryID string) ([]depreciableAsset, int, error) {
+	itemQuery := db.Preload("Category").
+		Joins("JOIN categories ON categories.id = items.category_id AND categories.deleted_at IS NULL").
+		Where("categories.depreciation_method <> ''")
+	if categoryID != "" {
+		itemQuery = itemQuery.Where("items.category_id = ?", categoryID)
+	}
+	var items []models.Item
+	if err := itemQuery.Find(&items).Error; err != nil {
+		return nil, 0, err
+	}
+	if len(items) == 0 {
+		return nil, 0, nil
+	}
+	byID := make(map[uint]models.Item, len(items))
+	ids := make([]uint, 0, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+		ids = append(ids, item.ID)
+	}
+
+	var warranties []models.Warranty
+	if err := db.Where("drone_id IN ?", ids).Order("drone_id, serial_number").Find(&warranties).Error; err != nil {
+		return nil, 0, err
+	}
+
+	assets := make([]depreciableAsset, 0, len(warranties))
+	undated := 0
+	for _, warranty := range warranties {
+		item := byID[warranty.DroneID]
+		start, ok := acquisitionDate(warranty)
+		if !ok {
+			undated++
+			continue
+		}
+		if start.After(asOf) {
+			continue
+		}
+		cost := item.UnitCost
+		if warranty.AcquisitionCost != nil {
+			cost = *warranty.AcquisitionCost
+		}
+		assets = append(assets, depreciableAsset{Warranty: warranty, Item: item, Start: start, Cost: cost})
+	}
+	return assets, undated, nil
+}
+
+// acquisitionDate is the day depreciation of a unit starts: its acquisition
+// date, or its BuyDate for units recorded before acquisition dates were.
+func acquisitionDate(warranty models.Warranty) (time.Time, bool) {
+	if warranty.AcquisitionDate != nil {
+		d := warranty.AcquisitionDate.UTC()
+		return time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC), true
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if d, err := time.Parse(layout, warranty.BuyDate); err == nil {
+			return time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// bookValueAt depreciates an asset by the method of its category for every
+// month that has ended by asOf, the month of acquisition included. It
+// returns the months depreciated and the book value, which never goes below
+// the salvage value.
+func bookValueAt(asset depreciableAsset, category models.Category, asOf time.Time) (int, float64) {
+	months := (asOf.Year()-asset.Start.Year())*12 + int(asOf.Month()-asset.Start.Month())
+	if asOf.AddDate(0, 0, 1).Day() == 1 {
+		months++ // asOf is the last day of its month
+	}
+	if months < 0 || asOf.Before(asset.Start) {
+		months = 0
+	}
+
+	salvage := 0.0
+	if category.SalvageRate != nil {
+		salvage = asset.Cost * *category.SalvageRate
+	}
+	life := 0
+	if category.UsefulLifeMonths != nil {
+		life = *category.UsefulLifeMonths
+	}
+	if life > 0 && months >= life {
+		return life, salvage
+	}
+
+	value := asset.Cost
+	switch category.DepreciationMethod {
+	case models.DepreciationStraightLine:
+		if life > 0 {
+			value = asset.Cost - (asset.Cost-salvage)*float64(months)/float64(life)
+		}
+	case models.DepreciationDecliningBalance:
+		if category.DepreciationRate != nil {
+			value = asset.Cost * math.Pow(1-*category.DepreciationRate/12, float64(months))
+		}
+	}
+	return months, math.Max(value, salvage)
+}
+
+// validateDepreciation checks the depreciation settings of a category.
+func validateDepreciation(category models.Category) error {
+	if category.SalvageRate != nil && (*category.SalvageRate < 0 || *category.SalvageRate >= 1) {
+		return fmt.Errorf("SalvageRate must be at least 0 and below 1")
+	}
+	if category.UsefulLifeMonths != nil && *category.UsefulLifeMonths < 1 {
+		return fmt.Errorf("UsefulLifeMonths must be at least 1")
+	}
+	switch category.DepreciationMethod {
+	case "":
+		return nil
+	case models.DepreciationStraightLine:
+		if category.UsefulLifeMonths == nil {
+			return fmt.Errorf("UsefulLifeMonths is required for straight_line depreciation")
+		}
+	case models.DepreciationDecliningBalance:
+		if category.DepreciationRate == nil || *category.DepreciationRate <= 0 || *category.DepreciationRate > 1 {
+			return fmt.Errorf("DepreciationRate must be above 0 and at most 1 for declining_balance depreciation")
+		}
+	default:
+		return fmt.Errorf("DepreciationMethod must be straight_line or declining_balance")
+	}
+	return nil
+}
+
+func today() time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
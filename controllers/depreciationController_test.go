@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"warehouse-store/models"
+)
+
+func TestBookValueAt(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	intPtr := func(v int) *int { return &v }
+	floatPtr := func(v float64) *float64 { return &v }
+
+	straightLine := models.Category{DepreciationMethod: models.DepreciationStraightLine, UsefulLifeMonths: intPtr(24)}
+	withSalvage := models.Category{DepreciationMethod: models.DepreciationStraightLine, UsefulLifeMonths: intPtr(24), SalvageRate: floatPtr(0.1)}
+	declining := models.Category{DepreciationMethod: models.DepreciationDecliningBalance, DepreciationRate: floatPtr(0.24), SalvageRate: floatPtr(0.1)}
+
+	tests := []struct {
+		name     string
+		category models.Category
+		cost     float64
+		asOf     time.Time
+		months   int
+		value    float64
+	}{
+		{"before acquisition", straightLine, 2400, date(2026, 6, 30), 0, 2400},
+		{"acquired mid-month, month not ended", straightLine, 2400, date(2026, 7, 20), 0, 2400},
+		{"month of acquisition ended", straightLine, 2400, date(2026, 7, 31), 1, 2300},
+		{"mid-month after acquisition month", straightLine, 2400, date(2026, 8, 15), 1, 2300},
+		{"month-end as of", straightLine, 2400, date(2026, 9, 30), 3, 2100},
+		{"last month of useful life", straightLine, 2400, date(2028, 5, 31), 23, 100},
+		{"end of useful life", straightLine, 2400, date(2028, 6, 30), 24, 0},
+		{"past useful life", straightLine, 2400, date(2030, 1, 1), 24, 0},
+		{"salvage value kept back", withSalvage, 1500, date(2026, 7, 31), 1, 1443.75},
+		{"end of useful life with salvage", withSalvage, 1500, date(2028, 6, 30), 24, 150},
+		{"declining balance", declining, 1000, date(2026, 8, 31), 2, 960.4},
+		{"declining balance floored at salvage", declining, 1000, date(2036, 7, 31), 121, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asset := depreciableAsset{Start: date(2026, 7, 10), Cost: tt.cost}
+			months, value := bookValueAt(asset, tt.category, tt.asOf)
+			if months != tt.months || math.Abs(value-tt.value) > 0.005 {
+				t.Errorf("bookValueAt(%s) = %d months, %.4f; want %d months, %.2f",
+					tt.asOf.Format("2006-01-02"), months, value, tt.months, tt.value)
+			}
+		})
+	}
+}
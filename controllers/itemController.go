@@ -1,9 +1,11 @@
 package controllers
 
 import (
+	"fmt"
 	"math"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -32,12 +34,30 @@ func (ctrl *ItemController) CreateItem(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Category ID provided"})
 		return
 	}
+	if err := validateStockLevels(item.ReorderPoint, item.TargetLevel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateLotPolicy(item.LotPolicy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateItemType(item.Type); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if item.UnitCost < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "UnitCost cannot be negative"})
+		return
+	}
+	item.LowStockSince = nil
 
-	if err := ctrl.DB.Create(&item).Error; err != nil {
+	if err := ctrl.DB.WithContext(c).Create(&item).Error; err != nil {
 		utils.LogError("Failed to create item", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create item"})
 		return
 	}
+	evaluateLowStock(ctrl.DB.WithContext(c), item.ID)
 	utils.LogInfo("Item created successfully", zap.Any("item", item))
 	c.JSON(http.StatusCreated, item)
 
@@ -72,6 +92,11 @@ func (ctrl *ItemController) UpdateItem(c *gin.Context) {
 		Status      string `json:"Status"`
 		CategoryID  uint   `json:"CategoryID"` 
 		Remark      string `json:"Remark"`
+		ReorderPoint *int  `json:"ReorderPoint"`
+		TargetLevel  *int  `json:"TargetLevel"`
+		LotPolicy    string `json:"LotPolicy"`
+		Type         string `json:"Type"` // Unchanged when empty
+		UnitCost     *float64 `json:"UnitCost"` // Corrects the average cost; unchanged when omitted
 	}
 	if err := c.ShouldBindJSON(&input); err != nil {
 		utils.LogError("Failed", err)
@@ -85,18 +110,72 @@ func (ctrl *ItemController) UpdateItem(c *gin.Context) {
 		return
 	}
 
-	item.Name = input.Name
-	item.Description = input.Description
-	item.Quantity = input.Quantity
-	item.Status = input.Status
-	item.CategoryID = input.CategoryID 
-	item.Remark = input.Remark
+	if err := validateStockLevels(input.ReorderPoint, input.TargetLevel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateLotPolicy(input.LotPolicy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateItemType(input.Type); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	if err := ctrl.DB.Save(&item).Error; err != nil {
-		utils.LogError("Failed", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update item"})
+	if input.UnitCost != nil && *input.UnitCost < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "UnitCost cannot be negative"})
+		return
+	}
+
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Scopes(forUpdate).First(&item, item.ID).Error; err != nil {
+			return stockError{http.StatusNotFound, "Item not found"}
+		}
+		if input.Quantity != item.Quantity {
+			if err := checkNotCounting(tx, item.ID); err != nil {
+				return err
+			}
+		}
+
+		located, err := locatedQuantity(tx, item.ID)
+		if err != nil {
+			return err
+		}
+		if input.Quantity < located {
+			return stockError{http.StatusBadRequest, fmt.Sprintf("Quantity cannot be lower than the %d put away in locations; transfer them out first", located)}
+		}
+
+		lotted, err := lotQuantity(tx, item.ID)
+		if err != nil {
+			return err
+		}
+		if input.Quantity < lotted {
+			return stockError{http.StatusBadRequest, fmt.Sprintf("Quantity cannot be lower than the %d held in lots", lotted)}
+		}
+
+		item.Name = input.Name
+		item.Description = input.Description
+		item.Quantity = input.Quantity
+		item.Status = input.Status
+		item.CategoryID = input.CategoryID
+		item.Remark = input.Remark
+		item.ReorderPoint = input.ReorderPoint
+		item.TargetLevel = input.TargetLevel
+		item.LotPolicy = input.LotPolicy
+		if input.Type != "" {
+			item.Type = input.Type
+		}
+		if input.UnitCost != nil {
+			item.UnitCost = *input.UnitCost
+		}
+		return tx.Save(&item).Error
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to update item")
 		return
 	}
+	evaluateLowStock(ctrl.DB.WithContext(c), item.ID)
 	
 	ctrl.DB.Preload("Category").First(&item, item.ID)
 	c.JSON(http.StatusOK, item)
@@ -104,7 +183,7 @@ func (ctrl *ItemController) UpdateItem(c *gin.Context) {
 
 func (ctrl *ItemController) DeleteItem(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
-	if err := ctrl.DB.Delete(&models.Item{}, id).Error; err != nil {
+	if err := ctrl.DB.WithContext(c).Delete(&models.Item{}, id).Error; err != nil {
 		utils.LogError("Failed", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete item"})
 		return
@@ -117,6 +196,7 @@ func (ctrl *ItemController) GetItems(c *gin.Context) {
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "30"))
 	status := c.Query("status")
 	categoryID := c.Query("category_id")
+	itemType := c.Query("type")
 
 	offset := (page - 1) * limit
 
@@ -130,6 +210,10 @@ func (ctrl *ItemController) GetItems(c *gin.Context) {
 		query = query.Where("category_id = ?", categoryID)
 	}
 
+	if itemType != "" {
+		query = query.Where("type = ?", itemType)
+	}
+
 	var items []models.Item
 	if err := query.Offset(offset).Limit(limit).Find(&items).Error; err != nil {
 		utils.LogError("Failed", err)
@@ -150,3 +234,133 @@ func (ctrl *ItemController) GetItems(c *gin.Context) {
 		},
 	})
 }
+
+// GetLowStockItems lists items at or below their reorder point with the
+// quantity needed to reach the target level and what is already on order.
+func (ctrl *ItemController) GetLowStockItems(c *gin.Context) {
+	query := ctrl.DB.Model(&models.Item{}).Select("items.*").Preload("Category").
+		Joins("LEFT JOIN categories ON categories.id = items.category_id").
+		Where("items.quantity <= COALESCE(items.reorder_point, categories.default_reorder_point)").
+		Order("items.quantity")
+	if categoryID := c.Query("category_id"); categoryID != "" {
+		query = query.Where("items.category_id = ?", categoryID)
+	}
+
+	var items []models.Item
+	if err := query.Find(&items).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch low-stock items"})
+		return
+	}
+
+	itemIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		itemIDs = append(itemIDs, item.ID)
+	}
+	onOrder := onOrderQuantities(ctrl.DB, itemIDs)
+
+	result := make([]gin.H, 0, len(items))
+	for _, item := range items {
+		reorderPoint, targetLevel := stockLevels(item)
+		result = append(result, gin.H{
+			"item":           item,
+			"reorder_point":  reorderPoint,
+			"target_level":   targetLevel,
+			"order_quantity": orderQuantity(item.Quantity, targetLevel),
+			"on_order":       onOrder[item.ID], // Already ordered on open purchase orders
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// stockLevels returns the reorder point and target level of an item, falling
+// back to the defaults of its category. item.Category must be loaded.
+func stockLevels(item models.Item) (reorderPoint, targetLevel *int) {
+	reorderPoint, targetLevel = item.ReorderPoint, item.TargetLevel
+	if reorderPoint == nil {
+		reorderPoint = item.Category.DefaultReorderPoint
+	}
+	if targetLevel == nil {
+		targetLevel = item.Category.DefaultTargetLevel
+	}
+	return reorderPoint, targetLevel
+}
+
+func orderQuantity(quantity int, targetLevel *int) int {
+	if targetLevel == nil || *targetLevel <= quantity {
+		return 0
+	}
+	return *targetLevel - quantity
+}
+
+func validateStockLevels(reorderPoint, targetLevel *int) error {
+	if reorderPoint != nil && *reorderPoint < 0 {
+		return fmt.Errorf("ReorderPoint cannot be negative")
+	}
+	if reorderPoint != nil && targetLevel != nil && *targetLevel <= *reorderPoint {
+		return fmt.Errorf("TargetLevel must be above ReorderPoint")
+	}
+	return nil
+}
+
+func validateItemType(itemType string) error {
+	switch itemType {
+	case "", models.ItemReturnable, models.ItemConsumable, models.ItemSerialized:
+		return nil
+	}
+	return fmt.Errorf("Type must be returnable, consumable or serialized")
+}
+
+// evaluateLowStock compares an item with its reorder point after its stock
+// changed and sends a "low_stock" notification when it drops to the point,
+// or "restocked" once it is above it again. Call it after the change is
+// committed. LowStockSince is claimed with a conditional update, so
+// concurrent changes alert only once.
+func evaluateLowStock(db *gorm.DB, itemID uint) {
+	var item models.Item
+	if err := db.Preload("Category").First(&item, itemID).Error; err != nil {
+		utils.LogError("Failed", err)
+		return
+	}
+	reorderPoint, targetLevel := stockLevels(item)
+	low := reorderPoint != nil && item.Quantity <= *reorderPoint
+
+	if low && item.LowStockSince == nil {
+		result := db.Model(&models.Item{}).Where("id = ? AND low_stock_since IS NULL", item.ID).Update("low_stock_since", time.Now())
+		if result.Error != nil {
+			utils.LogError("Failed", result.Error)
+			return
+		}
+		if result.RowsAffected == 1 {
+			utils.Notify(utils.Notification{
+				Event:   "low_stock",
+				Subject: fmt.Sprintf("Low stock: %s", item.Name),
+				Message: fmt.Sprintf("%s is down to %d (reorder point %d). Order %d to reach the target level.",
+					item.Name, item.Quantity, *reorderPoint, orderQuantity(item.Quantity, targetLevel)),
+				Data: map[string]interface{}{
+					"item_id":        item.ID,
+					"quantity":       item.Quantity,
+					"reorder_point":  *reorderPoint,
+					"target_level":   targetLevel,
+					"order_quantity": orderQuantity(item.Quantity, targetLevel),
+				},
+			})
+		}
+	}
+
+	if !low && item.LowStockSince != nil {
+		result := db.Model(&models.Item{}).Where("id = ? AND low_stock_since IS NOT NULL", item.ID).Update("low_stock_since", nil)
+		if result.Error != nil {
+			utils.LogError("Failed", result.Error)
+			return
+		}
+		if result.RowsAffected == 1 {
+			utils.Notify(utils.Notification{
+				Event:   "restocked",
+				Subject: fmt.Sprintf("Restocked: %s", item.Name),
+				Message: fmt.Sprintf("%s is back to %d.", item.Name, item.Quantity),
+				Data:    map[string]interface{}{"item_id": item.ID, "quantity": item.Quantity},
+			})
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+type KitController struct {
+	DB *gorm.DB
+}
+
+func NewKitController(db *gorm.DB) *KitController {
+	return &KitController{DB: db}
+}
+
+type KitComponentInput struct {
+	ItemID   uint `json:"item_id" binding:"required"`
+	Quantity int  `json:"quantity" binding:"required,min=1"`
+}
+
+type KitInput struct {
+	Name        string              `json:"name" binding:"required"`
+	Description string              `json:"description"`
+	Components  []KitComponentInput `json:"components" binding:"required,min=1,dive"`
+}
+
+type KitBorrowInput struct {
+	ProjectID  uint   `json:"project_id" binding:"required"`
+	Quantity   int    `json:"quantity" binding:"omitempty,min=1"` // Number of kits, defaults to 1
+	BorrowDate string `json:"borrow_date" binding:"required"`
+	DueDate    string `json:"due_date" binding:"required"`
+}
+
+// KitReturnInput returns the components of a kit loan. Components listed as
+// missing stay borrowed and can be returned later on their own.
+type KitReturnInput struct {
+	ReturnDate string              `json:"return_date" binding:"required"`
+	LocationID *uint               `json:"location_id"` // Optional bin to put the components back into
+	Missing    []KitComponentInput `json:"missing" binding:"dive"`
+	Usage      []UsageInput        `json:"usage" binding:"dive"` // Use of the serials while out
+}
+
+// kitStock is the availability of one kit component.
+type kitStock struct {
+	Item      models.Item `json:"item"`
+	PerKit    int         `json:"per_kit"`
+	InStock   int         `json:"in_stock"`
+	KitsWorth int         `json:"kits_worth"` // Complete kits this component alone allows
+}
+
+func (ctrl *KitController) GetKits(c *gin.Context) {
+	var kits []models.Kit
+	if err := ctrl.DB.Preload("Components.Item").Order("name").Find(&kits).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch kits"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(kits))
+	for _, kit := range kits {
+		available, _ := kitAvailability(kit)
+		result = append(result, gin.H{"kit": kit, "available": available})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetKitByID shows a kit with how many can be borrowed right now and which
+// component runs out first.
+func (ctrl *KitController) GetKitByID(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var kit models.Kit
+	if err := ctrl.DB.Preload("Components.Item").First(&kit, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Kit not found"})
+		return
+	}
+
+	available, components := kitAvailability(kit)
+	var limitedBy *models.Item
+	for i := range components {
+		if components[i].KitsWorth == available {
+			limitedBy = &components[i].Item
+			break
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"kit": kit, "available": available, "limited_by": limitedBy, "components": components})
+}
+
+func (ctrl *KitController) CreateKit(c *gin.Context) {
+	var input KitInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	kit := models.Kit{Name: input.Name, Description: input.Description}
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&kit).Error; err != nil {
+			return err
+		}
+		return setKitComponents(tx, kit.ID, input.Components)
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to create kit")
+		return
+	}
+
+	ctrl.DB.Preload("Components.Item").First(&kit, kit.ID)
+	c.JSON(http.StatusCreated, kit)
+}
+
+// UpdateKit renames a kit and replaces its components. Open loans keep the
+// components they were borrowed with.
+func (ctrl *KitController) UpdateKit(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input KitInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var kit models.Kit
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&kit, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Kit not found"}
+		}
+		kit.Name = input.Name
+		kit.Description = input.Description
+		if err := tx.Save(&kit).Error; err != nil {
+			return err
+		}
+		return setKitComponents(tx, kit.ID, input.Components)
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to update kit")
+		return
+	}
+
+	ctrl.DB.Preload("Components.Item").First(&kit, kit.ID)
+	c.JSON(http.StatusOK, kit)
+}
+
+func (ctrl *KitController) DeleteKit(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var open int64
+	ctrl.DB.Model(&models.KitLoan{}).Where("kit_id = ? AND returned_at IS NULL", id).Count(&open)
+	if open > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Kit is borrowed"})
+		return
+	}
+
+	if err := ctrl.DB.WithContext(c).Delete(&models.Kit{}, id).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete kit"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Kit deleted successfully"})
+}
+
+// BorrowKit borrows every component of a kit in one transaction: either all
+// components are in stock and leave the warehouse, or nothing moves.
+// Consumable components are issued, so the kit comes back without them.
+func (ctrl *KitController) BorrowKit(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input KitBorrowInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Quantity == 0 {
+		input.Quantity = 1
+	}
+	if !projectAllowed(c, input.ProjectID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: API key is restricted to another project"})
+		return
+	}
+
+	var loan models.KitLoan
+	var itemIDs []uint
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var kit models.Kit
+		if err := tx.Preload("Components").First(&kit, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Kit not found"}
+		}
+		if len(kit.Components) == 0 {
+			return stockError{http.StatusBadRequest, "Kit has no components"}
+		}
+		var project models.Project
+		if err := tx.First(&project, input.ProjectID).Error; err != nil {
+			return stockError{http.StatusBadRequest, "Invalid project ID provided"}
+		}
+
+		quantities := make(map[uint]int, len(kit.Components))
+		for _, component := range kit.Components {
+			quantities[component.ItemID] = component.Quantity * input.Quantity
+		}
+		itemIDs = sortedItemIDs(quantities)
+		if err := checkNotCounting(tx, itemIDs...); err != nil {
+			return err
+		}
+
+		loan = models.KitLoan{KitID: kit.ID, Quantity: input.Quantity, ProjectID: project.ID, UserID: userID, BorrowDate: input.BorrowDate, DueDate: input.DueDate}
+		if err := tx.Create(&loan).Error; err != nil {
+			return err
+		}
+
+		for _, itemID := range itemIDs {
+			quantity := quantities[itemID]
+			var item models.Item
+			if err := tx.Scopes(forUpdate).First(&item, itemID).Error; err != nil {
+				return stockError{http.StatusBadRequest, fmt.Sprintf("Item %d in the kit no longer exists", itemID)}
+			}
+			if item.Type == models.ItemConsumable {
+				issue := models.TransactionIssue{UserID: userID, ProjectID: project.ID, Quantity: quantity, IssueDate: input.BorrowDate, KitLoanID: &loan.ID}
+				if err := issueStock(tx, item, &issue, nil, nil); err != nil {
+					return err
+				}
+				continue
+			}
+			if item.Quantity < quantity {
+				return stockError{http.StatusBadRequest, fmt.Sprintf("Not enough %s in stock. Available: %d", item.Name, item.Quantity)}
+			}
+			if err := checkMaintenance(tx, item, quantity); err != nil {
+				return err
+			}
+			if err := pickStock(tx, item, quantity, nil); err != nil {
+				return err
+			}
+			lots, err := allocateLots(tx, item, quantity, nil)
+			if err != nil {
+				return err
+			}
+			item.Quantity -= quantity
+			if err := tx.Save(&item).Error; err != nil {
+				return err
+			}
+			borrow := models.TransactionBorrow{
+				UserID:         userID,
+				ItemID:         itemID,
+				ProjectID:      project.ID,
+				BorrowQuantity: quantity,
+				BorrowDate:     input.BorrowDate,
+				DueDate:        input.DueDate,
+				KitLoanID:      &loan.ID,
+			}
+			if err := tx.Create(&borrow).Error; err != nil {
+				return err
+			}
+			if err := recordBorrowLots(tx, borrow.ID, lots); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to borrow kit")
+		return
+	}
+
+	for _, itemID := range itemIDs {
+		evaluateLowStock(ctrl.DB.WithContext(c), itemID)
+	}
+	ctrl.DB.Preload("Kit").Preload("Borrows.Item").Preload("Issues.Item").First(&loan, loan.ID)
+	c.JSON(http.StatusCreated, gin.H{"message": "Kit borrowed successfully", "loan": loan})
+}
+
+func (ctrl *KitController) GetKitLoans(c *gin.Context) {
+	query := ctrl.DB.Preload("Kit").Preload("Project").Preload("User", auditActorColumns).Preload("Borrows.Item").Preload("Issues.Item").Order("id desc")
+	if kitID := c.Query("kit_id"); kitID != "" {
+		query = query.Where("kit_id = ?", kitID)
+	}
+	if projectID := c.Query("project_id"); projectID != "" {
+		query = query.Where("project_id = ?", projectID)
+	}
+	if c.Query("open") == "true" {
+		query = query.Where("returned_at IS NULL")
+	}
+	if projectID, restricted := c.Get("apiKeyProjectID"); restricted {
+		query = query.Where("project_id = ?", projectID)
+	}
+
+	var loans []models.KitLoan
+	if err := query.Find(&loans).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch kit loans"})
+		return
+	}
+	c.JSON(http.StatusOK, loans)
+}
+
+// ReturnKit brings the components of a kit loan back into stock in one
+// transaction, minus any reported missing.
+func (ctrl *KitController) ReturnKit(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input KitReturnInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	missing := make(map[uint]int, len(input.Missing))
+	for _, in := range input.Missing {
+		missing[in.ItemID] += in.Quantity
+	}
+
+	var loan models.KitLoan
+	var returns []models.TransactionReturn
+	var workOrders []models.WorkOrder
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("Borrows", func(db *gorm.DB) *gorm.DB { return db.Order("item_id") }).First(&loan, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Kit loan not found"}
+		}
+		if !projectAllowed(c, loan.ProjectID) {
+			return stockError{http.StatusForbidden, "Forbidden: API key is restricted to another project"}
+		}
+
+		itemIDs := make([]uint, 0, len(loan.Borrows))
+		for _, borrow := range loan.Borrows {
+			itemIDs = append(itemIDs, borrow.ItemID)
+		}
+		if err := checkNotCounting(tx, itemIDs...); err != nil {
+			return err
+		}
+
+		// Only one request can close the loan
+		now := time.Now()
+		result := tx.Model(&models.KitLoan{}).Where("id = ? AND returned_at IS NULL", loan.ID).
+			Updates(map[string]interface{}{"return_date": input.ReturnDate, "returned_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return stockError{http.StatusConflict, "Kit loan is already returned"}
+		}
+
+		for _, borrow := range loan.Borrows {
+			returned, err := returnedQuantity(tx, borrow.ID)
+			if err != nil {
+				return err
+			}
+			back := borrow.BorrowQuantity - returned
+			if lost := missing[borrow.ItemID]; lost > 0 {
+				if lost > back {
+					lost = back
+				}
+				missing[borrow.ItemID] -= lost
+				back -= lost
+			}
+			if back <= 0 {
+				continue
+			}
+
+			if input.LocationID != nil {
+				if err := putAwayStock(tx, borrow.ItemID, *input.LocationID, back); err != nil {
+					return err
+				}
+			}
+			if err := restoreLots(tx, borrow.ID, back); err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Item{}).Where("id = ?", borrow.ItemID).
+				Update("quantity", gorm.Expr("quantity + ?", back)).Error; err != nil {
+				return err
+			}
+			ret := models.TransactionReturn{
+				UserID:         userID,
+				ItemID:         borrow.ItemID,
+				ProjectID:      borrow.ProjectID,
+				ReturnQuantity: back,
+				ReturnDate:     input.ReturnDate,
+				BorrowID:       borrow.ID,
+			}
+			if err := tx.Create(&ret).Error; err != nil {
+				return err
+			}
+			returns = append(returns, ret)
+		}
+
+		for itemID, left := range missing {
+			if left > 0 {
+				return stockError{http.StatusBadRequest, fmt.Sprintf("Item %d is not outstanding on this kit loan", itemID)}
+			}
+		}
+
+		returnedIDs := make([]uint, 0, len(returns))
+		for _, ret := range returns {
+			returnedIDs = append(returnedIDs, ret.ItemID)
+		}
+		var err error
+		workOrders, err = recordUsage(tx, userID, input.Usage, returnedIDs, models.UsageLog{KitLoanID: &loan.ID})
+		return err
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to return kit")
+		return
+	}
+
+	for _, ret := range returns {
+		evaluateLowStock(ctrl.DB.WithContext(c), ret.ItemID)
+	}
+	message := "Kit returned successfully"
+	if len(input.Missing) > 0 {
+		message = "Kit returned with missing components"
+	}
+	ctrl.DB.Preload("Kit").Preload("Borrows.Item").First(&loan, loan.ID)
+	c.JSON(http.StatusOK, gin.H{"message": message, "loan": loan, "returns": returns, "work_orders": workOrders})
+}
+
+// setKitComponents replaces the components of a kit.
+func setKitComponents(tx *gorm.DB, kitID uint, inputs []KitComponentInput) error {
+	quantities := make(map[uint]int, len(inputs))
+	for _, in := range inputs {
+		quantities[in.ItemID] += in.Quantity
+	}
+
+	if err := tx.Unscoped().Where("kit_id = ?", kitID).Delete(&models.KitComponent{}).Error; err != nil {
+		return err
+	}
+	for _, itemID := range sortedItemIDs(quantities) {
+		var item models.Item
+		if err := tx.First(&item, itemID).Error; err != nil {
+			return stockError{http.StatusBadRequest, fmt.Sprintf("Item %d not found", itemID)}
+		}
+		if err := tx.Create(&models.KitComponent{KitID: kitID, ItemID: itemID, Quantity: quantities[itemID]}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kitAvailability is how many complete kits the component stock makes up,
+// with the stock of each component. kit.Components.Item must be loaded.
+func kitAvailability(kit models.Kit) (int, []kitStock) {
+	components := make([]kitStock, 0, len(kit.Components))
+	available := -1
+	for _, component := range kit.Components {
+		worth := component.Item.Quantity / component.Quantity
+		components = append(components, kitStock{Item: component.Item, PerKit: component.Quantity, InStock: component.Item.Quantity, KitsWorth: worth})
+		if available < 0 || worth < available {
+			available = worth
+		}
+	}
+	if available < 0 {
+		available = 0
+	}
+	return available, components
+}
+
+// returnedQuantity is how much of a borrow transaction has been returned.
+func returnedQuantity(tx *gorm.DB, borrowID uint) (int, error) {
+	var returned int
+	err := tx.Model(&models.TransactionReturn{}).Where("borrow_id = ?", borrowID).
+		Select("COALESCE(SUM(return_quantity), 0)").Scan(&returned).Error
+	return returned, err
+}
@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+type LabelController struct {
+	DB *gorm.DB
+}
+
+func NewLabelController(db *gorm.DB) *LabelController {
+	return &LabelController{DB: db}
+}
+
+// LabelSheetInput selects the labels to print on a sheet.
+type LabelSheetInput struct {
+	Symbology string   `json:"symbology"` // "code128" (default) or "qr"
+	ItemIDs   []uint   `json:"item_ids"`
+	Serials   []string `json:"serials"`
+	BoxIDs    []uint   `json:"box_ids"`
+}
+
+// Labels per sheet request, enough for a few pages of stickers.
+const maxSheetLabels = 500
+
+func (ctrl *LabelController) GetItemLabel(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var item models.Item
+	if err := ctrl.DB.First(&item, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
+	}
+	renderLabel(c, utils.ItemCode(item.ID))
+}
+
+func (ctrl *LabelController) GetSerialLabel(c *gin.Context) {
+	var warranty models.Warranty
+	if err := ctrl.DB.Where("serial_number = ?", c.Param("serial")).First(&warranty).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Serial number not found"})
+		return
+	}
+	renderLabel(c, warranty.SerialNumber)
+}
+
+func (ctrl *LabelController) GetBoxLabel(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var box models.Box
+	if err := ctrl.DB.First(&box, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Box not found"})
+		return
+	}
+	renderLabel(c, utils.BoxCode(box.Number))
+}
+
+// renderLabel writes code as a PNG or SVG barcode, chosen with the format,
+// symbology and scale query parameters.
+func renderLabel(c *gin.Context, code string) {
+	symbology := c.DefaultQuery("symbology", utils.SymbologyCode128)
+	scale, err := strconv.Atoi(c.DefaultQuery("scale", "4"))
+	if err != nil || scale < 1 || scale > 20 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scale must be between 1 and 20"})
+		return
+	}
+
+	var buf bytes.Buffer
+	var contentType string
+	switch c.DefaultQuery("format", "png") {
+	case "png":
+		contentType = "image/png"
+		err = utils.WriteBarcodePNG(&buf, code, symbology, scale)
+	case "svg":
+		contentType = "image/svg+xml"
+		err = utils.WriteBarcodeSVG(&buf, code, symbology, scale)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be png or svg"})
+		return
+	}
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("X-Label-Code", code)
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// GetLabelSheet returns a printable PDF with one label per requested item,
+// serial number and box.
+func (ctrl *LabelController) GetLabelSheet(c *gin.Context) {
+	var input LabelSheetInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Symbology == "" {
+		input.Symbology = utils.SymbologyCode128
+	}
+	if input.Symbology != utils.SymbologyCode128 && input.Symbology != utils.SymbologyQR {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "symbology must be code128 or qr"})
+		return
+	}
+	total := len(input.ItemIDs) + len(input.Serials) + len(input.BoxIDs)
+	if total == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Select at least one item, serial number or box"})
+		return
+	}
+	if total > maxSheetLabels {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d labels per sheet", maxSheetLabels)})
+		return
+	}
+
+	labels, err := ctrl.sheetLabels(input)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := utils.WriteLabelSheetPDF(&buf, labels, input.Symbology); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate label sheet"})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="labels.pdf"`)
+	c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
+
+// sheetLabels builds the labels in the order they were requested.
+func (ctrl *LabelController) sheetLabels(input LabelSheetInput) ([]utils.Label, error) {
+	var labels []utils.Label
+
+	if len(input.ItemIDs) > 0 {
+		var items []models.Item
+		ctrl.DB.Preload("Category").Where("id IN ?", input.ItemIDs).Find(&items)
+		byID := make(map[uint]models.Item, len(items))
+		for _, item := range items {
+			byID[item.ID] = item
+		}
+		for _, id := range input.ItemIDs {
+			item, ok := byID[id]
+			if !ok {
+				return nil, fmt.Errorf("Item %d not found", id)
+			}
+			labels = append(labels, utils.Label{Code: utils.ItemCode(item.ID), Title: item.Name, Subtitle: item.Category.Name})
+		}
+	}
+
+	if len(input.Serials) > 0 {
+		var warranties []models.Warranty
+		ctrl.DB.Where("serial_number IN ?", input.Serials).Find(&warranties)
+		bySerial := make(map[string]models.Warranty, len(warranties))
+		for _, w := range warranties {
+			bySerial[w.SerialNumber] = w
+		}
+		names := ctrl.itemNames(warranties)
+		for _, serial := range input.Serials {
+			w, ok := bySerial[serial]
+			if !ok {
+				return nil, fmt.Errorf("Serial number %s not found", serial)
+			}
+			var details []string
+			if w.BoxID != 0 {
+				details = append(details, utils.BoxCode(w.BoxID))
+			}
+			if w.Lot != "" {
+				details = append(details, "Lot "+w.Lot)
+			}
+			labels = append(labels, utils.Label{Code: w.SerialNumber, Title: names[w.DroneID], Subtitle: strings.Join(details, " / ")})
+		}
+	}
+
+	if len(input.BoxIDs) > 0 {
+		var boxes []models.Box
+		ctrl.DB.Where("id IN ?", input.BoxIDs).Find(&boxes)
+		byID := make(map[uint]models.Box, len(boxes))
+		for _, box := range boxes {
+			byID[box.ID] = box
+		}
+		for _, id := range input.BoxIDs {
+			box, ok := byID[id]
+			if !ok {
+				return nil, fmt.Errorf("Box %d not found", id)
+			}
+			labels = append(labels, utils.Label{Code: utils.BoxCode(box.Number), Title: box.Name, Subtitle: box.Description})
+		}
+	}
+	return labels, nil
+}
+
+// itemNames maps the items of warranties to their names.
+func (ctrl *LabelController) itemNames(warranties []models.Warranty) map[uint]string {
+	var ids []uint
+	for _, w := range warranties {
+		ids = append(ids, w.DroneID)
+	}
+	names := make(map[uint]string)
+	if len(ids) == 0 {
+		return names
+	}
+	var items []models.Item
+	ctrl.DB.Select("id", "name").Where("id IN ?", ids).Find(&items)
+	for _, item := range items {
+		names[item.ID] = item.Name
+	}
+	return names
+}
+
+// Scan resolves a scanned label code to the item, serial number or box it
+// was printed for.
+func (ctrl *LabelController) Scan(c *gin.Context) {
+	code := strings.TrimSpace(c.Param("code"))
+
+	if id, ok := utils.ParseItemCode(code); ok {
+		var item models.Item
+		if err := ctrl.DB.Preload("Category").First(&item, id).Error; err == nil {
+			c.JSON(http.StatusOK, gin.H{"type": "item", "code": utils.ItemCode(item.ID), "item": item})
+			return
+		}
+	}
+
+	if number, ok := utils.ParseBoxCode(code); ok {
+		var box models.Box
+		if err := ctrl.DB.Preload("Items.Item").Where("number = ?", number).First(&box).Error; err == nil {
+			serials, err := boxSerials(ctrl.DB, box)
+			if err != nil {
+				utils.LogError("Failed", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up box"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"type": "box", "code": utils.BoxCode(box.Number), "box": box, "serials": serials})
+			return
+		}
+	}
+
+	var warranty models.Warranty
+	if err := ctrl.DB.Where("serial_number = ?", code).First(&warranty).Error; err == nil {
+		response := gin.H{"type": "serial", "code": warranty.SerialNumber, "warranty": warranty}
+		var item models.Item
+		if err := ctrl.DB.Preload("Category").First(&item, warranty.DroneID).Error; err == nil {
+			response["item"] = item
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"error": "No item, serial number or box matches this code"})
+}
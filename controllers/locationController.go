@@ -0,0 +1,453 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+// Parent type each location type must be created under.
+var locationParentTypes = map[string]string{
+	models.LocationWarehouse: "",
+	models.LocationZone:      models.LocationWarehouse,
+	models.LocationBin:       models.LocationZone,
+}
+
+type LocationController struct {
+	DB *gorm.DB
+}
+
+func NewLocationController(db *gorm.DB) *LocationController {
+	return &LocationController{DB: db}
+}
+
+type LocationInput struct {
+	Type        string `json:"type" binding:"required"`
+	Code        string `json:"code" binding:"required"`
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	ParentID    *uint  `json:"parent_id"`
+}
+
+type TransferInput struct {
+	ItemID         uint   `json:"item_id" binding:"required"`
+	FromLocationID *uint  `json:"from_location_id"` // Empty puts away unlocated stock
+	ToLocationID   *uint  `json:"to_location_id"`   // Empty takes stock out of its bin
+	Quantity       int    `json:"quantity" binding:"required,min=1"`
+	Note           string `json:"note"`
+}
+
+func (ctrl *LocationController) GetLocations(c *gin.Context) {
+	query := ctrl.DB.Preload("Parent").Order("code")
+	if locationType := c.Query("type"); locationType != "" {
+		query = query.Where("type = ?", locationType)
+	}
+	if parentID := c.Query("parent_id"); parentID != "" {
+		query = query.Where("parent_id = ?", parentID)
+	}
+
+	var locations []models.Location
+	if err := query.Find(&locations).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch locations"})
+		return
+	}
+	c.JSON(http.StatusOK, locations)
+}
+
+func (ctrl *LocationController) GetLocationByID(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var location models.Location
+	if err := ctrl.DB.Preload("Parent").First(&location, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+	c.JSON(http.StatusOK, location)
+}
+
+func (ctrl *LocationController) CreateLocation(c *gin.Context) {
+	var input LocationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ctrl.validateParent(input.Type, input.ParentID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	location := models.Location{
+		Type:        input.Type,
+		Code:        input.Code,
+		Name:        input.Name,
+		Description: input.Description,
+		ParentID:    input.ParentID,
+	}
+	if err := ctrl.DB.WithContext(c).Create(&location).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create location, the code may already be in use"})
+		return
+	}
+	c.JSON(http.StatusCreated, location)
+}
+
+func (ctrl *LocationController) UpdateLocation(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var location models.Location
+	if err := ctrl.DB.First(&location, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	var input LocationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Type != location.Type {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Location type cannot be changed"})
+		return
+	}
+	if err := ctrl.validateParent(input.Type, input.ParentID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	location.Code = input.Code
+	location.Name = input.Name
+	location.Description = input.Description
+	location.ParentID = input.ParentID
+	location.Parent = nil
+	if err := ctrl.DB.WithContext(c).Save(&location).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update location"})
+		return
+	}
+	c.JSON(http.StatusOK, location)
+}
+
+// DeleteLocation only removes empty locations without children.
+func (ctrl *LocationController) DeleteLocation(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var location models.Location
+	if err := ctrl.DB.First(&location, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	var children int64
+	ctrl.DB.Model(&models.Location{}).Where("parent_id = ?", location.ID).Count(&children)
+	if children > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Location still contains other locations"})
+		return
+	}
+	var stocked int64
+	ctrl.DB.Model(&models.ItemLocation{}).Where("location_id = ? AND quantity > 0", location.ID).Count(&stocked)
+	if stocked > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Location still holds stock, transfer it first"})
+		return
+	}
+
+	if err := ctrl.DB.WithContext(c).Delete(&location).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete location"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Location deleted successfully"})
+}
+
+// GetLocationContents lists the stock in a bin, or in every bin under a zone
+// or warehouse.
+func (ctrl *LocationController) GetLocationContents(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var location models.Location
+	if err := ctrl.DB.First(&location, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	binIDs := ctrl.DB.Model(&models.Location{}).Select("id").Where("id = ?", location.ID)
+	switch location.Type {
+	case models.LocationZone:
+		binIDs = ctrl.DB.Model(&models.Location{}).Select("id").Where("parent_id = ?", location.ID)
+	case models.LocationWarehouse:
+		zoneIDs := ctrl.DB.Model(&models.Location{}).Select("id").Where("parent_id = ?", location.ID)
+		binIDs = ctrl.DB.Model(&models.Location{}).Select("id").Where("parent_id IN (?)", zoneIDs)
+	}
+
+	var contents []models.ItemLocation
+	if err := ctrl.DB.Preload("Item.Category").Preload("Location").
+		Where("location_id IN (?) AND quantity > 0", binIDs).
+		Order("location_id, item_id").Find(&contents).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch location contents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"location": location, "contents": contents})
+}
+
+// GetItemLocations tells where an item is stored, plus the quantity that is
+// in stock but not put away in any bin.
+func (ctrl *LocationController) GetItemLocations(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var item models.Item
+	if err := ctrl.DB.First(&item, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
+	}
+
+	var locations []models.ItemLocation
+	if err := ctrl.DB.Preload("Location.Parent.Parent").
+		Where("item_id = ? AND quantity > 0", item.ID).
+		Order("quantity desc").Find(&locations).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch item locations"})
+		return
+	}
+
+	located := 0
+	for _, l := range locations {
+		located += l.Quantity
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"item_id":   item.ID,
+		"quantity":  item.Quantity,
+		"unlocated": item.Quantity - located,
+		"locations": locations,
+	})
+}
+
+func (ctrl *LocationController) TransferStock(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var input TransferInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.FromLocationID == nil && input.ToLocationID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from_location_id or to_location_id is required"})
+		return
+	}
+	if input.FromLocationID != nil && input.ToLocationID != nil && *input.FromLocationID == *input.ToLocationID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Source and destination are the same location"})
+		return
+	}
+
+	var transfer models.StockTransfer
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var item models.Item
+		if err := tx.Scopes(forUpdate).First(&item, input.ItemID).Error; err != nil {
+			return stockError{http.StatusNotFound, "Item not found"}
+		}
+		if err := checkNotCounting(tx, item.ID); err != nil {
+			return err
+		}
+
+		if input.FromLocationID != nil {
+			if err := takeFromLocation(tx, item.ID, *input.FromLocationID, input.Quantity); err != nil {
+				return err
+			}
+		} else {
+			located, err := locatedQuantity(tx, item.ID)
+			if err != nil {
+				return err
+			}
+			if unlocated := item.Quantity - located; unlocated < input.Quantity {
+				return stockError{http.StatusBadRequest, fmt.Sprintf("Only %d of %s are not put away yet", unlocated, item.Name)}
+			}
+		}
+
+		if input.ToLocationID != nil {
+			if err := putAwayStock(tx, item.ID, *input.ToLocationID, input.Quantity); err != nil {
+				return err
+			}
+		}
+
+		transfer = models.StockTransfer{
+			ItemID:         item.ID,
+			FromLocationID: input.FromLocationID,
+			ToLocationID:   input.ToLocationID,
+			Quantity:       input.Quantity,
+			UserID:         userID,
+			Note:           input.Note,
+		}
+		return tx.Create(&transfer).Error
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to transfer stock")
+		return
+	}
+
+	ctrl.DB.Preload("Item").Preload("FromLocation").Preload("ToLocation").First(&transfer, transfer.ID)
+	c.JSON(http.StatusCreated, gin.H{"message": "Stock transferred successfully", "transfer": transfer})
+}
+
+func (ctrl *LocationController) GetTransfers(c *gin.Context) {
+	query := ctrl.DB.Preload("Item").Preload("FromLocation").Preload("ToLocation").Preload("User", auditActorColumns).Order("id desc")
+	if itemID := c.Query("item_id"); itemID != "" {
+		query = query.Where("item_id = ?", itemID)
+	}
+	if locationID := c.Query("location_id"); locationID != "" {
+		query = query.Where("from_location_id = ? OR to_location_id = ?", locationID, locationID)
+	}
+
+	var transfers []models.StockTransfer
+	if err := query.Find(&transfers).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transfers"})
+		return
+	}
+	c.JSON(http.StatusOK, transfers)
+}
+
+func (ctrl *LocationController) validateParent(locationType string, parentID *uint) error {
+	parentType, ok := locationParentTypes[locationType]
+	if !ok {
+		return errors.New("type must be warehouse, zone or bin")
+	}
+	if parentType == "" {
+		if parentID != nil {
+			return errors.New("a warehouse cannot have a parent location")
+		}
+		return nil
+	}
+
+	if parentID == nil {
+		return fmt.Errorf("a %s needs a parent %s", locationType, parentType)
+	}
+	var parent models.Location
+	if err := ctrl.DB.First(&parent, *parentID).Error; err != nil {
+		return errors.New("Invalid parent location ID provided")
+	}
+	if parent.Type != parentType {
+		return fmt.Errorf("a %s must be placed in a %s, not a %s", locationType, parentType, parent.Type)
+	}
+	return nil
+}
+
+// stockError is returned from inside a stock transaction to roll it back
+// with a specific response.
+type stockError struct {
+	Status  int
+	Message string
+}
+
+func (e stockError) Error() string {
+	return e.Message
+}
+
+func respondStockError(c *gin.Context, err error, fallback string) {
+	var se stockError
+	if errors.As(err, &se) {
+		c.JSON(se.Status, gin.H{"error": se.Message})
+		return
+	}
+	utils.LogError("Failed", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+}
+
+// locatedQuantity is how much of an item is put away in bins.
+func locatedQuantity(tx *gorm.DB, itemID uint) (int, error) {
+	var located int
+	err := tx.Model(&models.ItemLocation{}).Where("item_id = ?", itemID).
+		Select("COALESCE(SUM(quantity), 0)").Scan(&located).Error
+	return located, err
+}
+
+// forUpdate locks the rows a query reads until the transaction ends, so
+// quantities checked in the transaction cannot change before it writes.
+func forUpdate(tx *gorm.DB) *gorm.DB {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"})
+}
+
+// takeFromLocation removes quantity of an item from one bin.
+func takeFromLocation(tx *gorm.DB, itemID, locationID uint, quantity int) error {
+	result := tx.Model(&models.ItemLocation{}).
+		Where("item_id = ? AND location_id = ? AND quantity >= ?", itemID, locationID, quantity).
+		Update("quantity", gorm.Expr("quantity - ?", quantity))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	var stock models.ItemLocation
+	if err := tx.Where("item_id = ? AND location_id = ?", itemID, locationID).First(&stock).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return stockError{http.StatusBadRequest, "Item is not stored in this location"}
+		}
+		return err
+	}
+	return stockError{http.StatusBadRequest, fmt.Sprintf("Only %d in this location", stock.Quantity)}
+}
+
+// putAwayStock adds quantity of an item to a bin.
+func putAwayStock(tx *gorm.DB, itemID, locationID uint, quantity int) error {
+	var location models.Location
+	if err := tx.First(&location, locationID).Error; err != nil {
+		return stockError{http.StatusBadRequest, "Invalid location ID provided"}
+	}
+	if location.Type != models.LocationBin {
+		return stockError{http.StatusBadRequest, "Stock can only be stored in a bin"}
+	}
+
+	result := tx.Model(&models.ItemLocation{}).
+		Where("item_id = ? AND location_id = ?", itemID, locationID).
+		Update("quantity", gorm.Expr("quantity + ?", quantity))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+	// A concurrent first put-away into the same bin fails on the unique index
+	return tx.Create(&models.ItemLocation{ItemID: itemID, LocationID: locationID, Quantity: quantity}).Error
+}
+
+// pickStock keeps bin quantities within Item.Quantity when stock leaves the
+// warehouse, before Item.Quantity is lowered by quantity. With a location
+// the stock comes from that bin; otherwise unlocated stock is used first,
+// then the fullest bins. item must have been read with forUpdate.
+func pickStock(tx *gorm.DB, item models.Item, quantity int, locationID *uint) error {
+	if locationID != nil {
+		return takeFromLocation(tx, item.ID, *locationID, quantity)
+	}
+
+	var stocks []models.ItemLocation
+	if err := tx.Scopes(forUpdate).Where("item_id = ? AND quantity > 0", item.ID).Order("quantity desc").Find(&stocks).Error; err != nil {
+		return err
+	}
+	located := 0
+	for _, stock := range stocks {
+		located += stock.Quantity
+	}
+	remaining := quantity - (item.Quantity - located)
+
+	for _, stock := range stocks {
+		if remaining <= 0 {
+			break
+		}
+		take := stock.Quantity
+		if take > remaining {
+			take = remaining
+		}
+		remaining -= take
+		if err := tx.Model(&stock).Update("quantity", gorm.Expr("quantity - ?", take)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,534 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+type LotController struct {
+	DB *gorm.DB
+}
+
+func NewLotController(db *gorm.DB) *LotController {
+	return &LotController{DB: db}
+}
+
+// LotInput holds dates as "2006-01-02".
+type LotInput struct {
+	Code            string `json:"code" binding:"required"`
+	ReceivedDate    string `json:"received_date"` // Defaults to today
+	ManufactureDate string `json:"manufacture_date"`
+	ExpiryDate      string `json:"expiry_date"`
+	Supplier        string `json:"supplier"`
+	Quantity        int    `json:"quantity" binding:"min=0"`
+	AddToStock      bool   `json:"add_to_stock"` // Raise the item quantity; otherwise the lot takes over stock without a lot
+}
+
+// lotRecipient is one borrow that received units of a lot.
+type lotRecipient struct {
+	BorrowID    uint   `json:"borrow_id,omitempty"`
+	BorrowDate  string `json:"borrow_date,omitempty"`
+	IssueID     uint   `json:"issue_id,omitempty"` // Issued consumables are not expected back
+	IssueDate   string `json:"issue_date,omitempty"`
+	ProjectID   uint   `json:"project_id"`
+	ProjectName string `json:"project_name"`
+	UserID      uint   `json:"user_id"`
+	Username    string `json:"username"`
+	Quantity    int    `json:"quantity"`
+	Returned    int    `json:"returned"`
+	Outstanding int    `json:"outstanding"`
+}
+
+func (ctrl *LotController) GetLots(c *gin.Context) {
+	query := ctrl.DB.Preload("Item").Order("item_id, received_date")
+	if itemID := c.Query("item_id"); itemID != "" {
+		query = query.Where("item_id = ?", itemID)
+	}
+	if c.Query("in_stock") == "true" {
+		query = query.Where("quantity > 0")
+	}
+	if before := c.Query("expiring_before"); before != "" {
+		date, err := time.Parse("2006-01-02", before)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expiring_before must be a date like 2006-01-02"})
+			return
+		}
+		query = query.Where("expiry_date < ?", date)
+	}
+
+	var lots []models.Lot
+	if err := query.Find(&lots).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch lots"})
+		return
+	}
+	c.JSON(http.StatusOK, lots)
+}
+
+func (ctrl *LotController) GetLotByID(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var lot models.Lot
+	if err := ctrl.DB.Preload("Item").First(&lot, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lot not found"})
+		return
+	}
+	c.JSON(http.StatusOK, lot)
+}
+
+func (ctrl *LotController) GetItemLots(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var item models.Item
+	if err := ctrl.DB.First(&item, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
+	}
+
+	var lots []models.Lot
+	if err := lotOrder(ctrl.DB.Where("item_id = ?", item.ID), item).Find(&lots).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch lots"})
+		return
+	}
+
+	lotted := 0
+	for _, lot := range lots {
+		lotted += lot.Quantity
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"item_id":  item.ID,
+		"policy":   lotPolicy(item),
+		"quantity": item.Quantity,
+		"unlotted": item.Quantity - lotted,
+		"lots":     lots,
+	})
+}
+
+func (ctrl *LotController) CreateLot(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input LotInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	lot := models.Lot{Code: input.Code, Supplier: input.Supplier, ReceivedQuantity: input.Quantity, Quantity: input.Quantity}
+	if err := applyLotDates(&lot, input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var item models.Item
+		if err := tx.Scopes(forUpdate).First(&item, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Item not found"}
+		}
+		var count int64
+		tx.Model(&models.Lot{}).Where("item_id = ? AND code = ?", item.ID, input.Code).Count(&count)
+		if count > 0 {
+			return stockError{http.StatusConflict, "This item already has a lot with this code"}
+		}
+
+		if input.AddToStock {
+			if err := checkNotCounting(tx, item.ID); err != nil {
+				return err
+			}
+			item.Quantity += input.Quantity
+			if err := tx.Save(&item).Error; err != nil {
+				return err
+			}
+		} else {
+			lotted, err := lotQuantity(tx, item.ID)
+			if err != nil {
+				return err
+			}
+			if unlotted := item.Quantity - lotted; unlotted < input.Quantity {
+				return stockError{http.StatusBadRequest, fmt.Sprintf("Only %d of %s are not in a lot; use add_to_stock for newly received units", unlotted, item.Name)}
+			}
+		}
+
+		lot.ItemID = item.ID
+		return tx.Create(&lot).Error
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to create lot")
+		return
+	}
+
+	evaluateLowStock(ctrl.DB.WithContext(c), lot.ItemID)
+	c.JSON(http.StatusCreated, lot)
+}
+
+// UpdateLot changes the details of a lot. Quantities only change through
+// stock movements.
+func (ctrl *LotController) UpdateLot(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var lot models.Lot
+	if err := ctrl.DB.First(&lot, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lot not found"})
+		return
+	}
+
+	var input LotInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Code != lot.Code {
+		var count int64
+		ctrl.DB.Model(&models.Lot{}).Where("item_id = ? AND code = ? AND id <> ?", lot.ItemID, input.Code, lot.ID).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "This item already has a lot with this code"})
+			return
+		}
+	}
+
+	if input.ReceivedDate == "" {
+		input.ReceivedDate = lot.ReceivedDate.Format("2006-01-02")
+	}
+	lot.Code = input.Code
+	lot.Supplier = input.Supplier
+	if err := applyLotDates(&lot, input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ctrl.DB.WithContext(c).Save(&lot).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update lot"})
+		return
+	}
+	c.JSON(http.StatusOK, lot)
+}
+
+// DeleteLot removes a lot registered by mistake. Lots that were issued are
+// kept so recalls can still find who received them.
+func (ctrl *LotController) DeleteLot(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var lot models.Lot
+	if err := ctrl.DB.First(&lot, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lot not found"})
+		return
+	}
+
+	var borrowed, issued int64
+	ctrl.DB.Model(&models.BorrowLot{}).Where("lot_id = ?", lot.ID).Count(&borrowed)
+	ctrl.DB.Model(&models.IssueLot{}).Where("lot_id = ?", lot.ID).Count(&issued)
+	if borrowed+issued > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Lot has been issued and is kept for recalls"})
+		return
+	}
+
+	// The units stay in stock without a lot
+	if err := ctrl.DB.WithContext(c).Unscoped().Delete(&lot).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete lot"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Lot deleted successfully"})
+}
+
+// GetLotRecall lists every project and user that received units of a lot,
+// and how many of them are still out.
+func (ctrl *LotController) GetLotRecall(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var lot models.Lot
+	if err := ctrl.DB.Preload("Item").First(&lot, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lot not found"})
+		return
+	}
+
+	borrowQuery := ctrl.DB.Preload("Borrow.Project").Preload("Borrow.User", auditActorColumns).
+		Where("borrow_lots.lot_id = ?", lot.ID).Order("borrow_lots.id")
+	issueQuery := ctrl.DB.Preload("Issue.Project").Preload("Issue.User", auditActorColumns).
+		Where("issue_lots.lot_id = ?", lot.ID).Order("issue_lots.id")
+	if projectID, restricted := c.Get("apiKeyProjectID"); restricted {
+		borrowQuery = borrowQuery.Joins("JOIN transaction_borrows ON transaction_borrows.id = borrow_lots.borrow_id").
+			Where("transaction_borrows.project_id = ?", projectID)
+		issueQuery = issueQuery.Joins("JOIN transaction_issues ON transaction_issues.id = issue_lots.issue_id").
+			Where("transaction_issues.project_id = ?", projectID)
+	}
+
+	var borrows []models.BorrowLot
+	if err := borrowQuery.Find(&borrows).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch lot recipients"})
+		return
+	}
+	var issues []models.IssueLot
+	if err := issueQuery.Find(&issues).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch lot recipients"})
+		return
+	}
+
+	recipients := make([]lotRecipient, 0, len(borrows)+len(issues))
+	for _, borrow := range borrows {
+		recipients = append(recipients, lotRecipient{
+			BorrowID:    borrow.BorrowID,
+			BorrowDate:  borrow.Borrow.BorrowDate,
+			ProjectID:   borrow.Borrow.ProjectID,
+			ProjectName: borrow.Borrow.Project.Name,
+			UserID:      borrow.Borrow.UserID,
+			Username:    borrow.Borrow.User.Username,
+			Quantity:    borrow.Quantity,
+			Returned:    borrow.ReturnedQuantity,
+			Outstanding: borrow.Quantity - borrow.ReturnedQuantity,
+		})
+	}
+	for _, issue := range issues {
+		recipients = append(recipients, lotRecipient{
+			IssueID:     issue.IssueID,
+			IssueDate:   issue.Issue.IssueDate,
+			ProjectID:   issue.Issue.ProjectID,
+			ProjectName: issue.Issue.Project.Name,
+			UserID:      issue.Issue.UserID,
+			Username:    issue.Issue.User.Username,
+			Quantity:    issue.Quantity,
+		})
+	}
+
+	projects := make(map[uint]gin.H)
+	users := make(map[uint]gin.H)
+	outstanding := 0
+	for _, r := range recipients {
+		outstanding += r.Outstanding
+
+		if _, ok := projects[r.ProjectID]; !ok {
+			projects[r.ProjectID] = gin.H{"project_id": r.ProjectID, "name": r.ProjectName, "quantity": 0, "outstanding": 0}
+		}
+		projects[r.ProjectID]["quantity"] = projects[r.ProjectID]["quantity"].(int) + r.Quantity
+		projects[r.ProjectID]["outstanding"] = projects[r.ProjectID]["outstanding"].(int) + r.Outstanding
+		if _, ok := users[r.UserID]; !ok {
+			users[r.UserID] = gin.H{"user_id": r.UserID, "username": r.Username, "quantity": 0, "outstanding": 0}
+		}
+		users[r.UserID]["quantity"] = users[r.UserID]["quantity"].(int) + r.Quantity
+		users[r.UserID]["outstanding"] = users[r.UserID]["outstanding"].(int) + r.Outstanding
+	}
+
+	projectList := make([]gin.H, 0, len(projects))
+	for _, p := range projects {
+		projectList = append(projectList, p)
+	}
+	sort.Slice(projectList, func(i, j int) bool {
+		return projectList[i]["project_id"].(uint) < projectList[j]["project_id"].(uint)
+	})
+	userList := make([]gin.H, 0, len(users))
+	for _, u := range users {
+		userList = append(userList, u)
+	}
+	sort.Slice(userList, func(i, j int) bool {
+		return userList[i]["user_id"].(uint) < userList[j]["user_id"].(uint)
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"lot":         lot,
+		"in_stock":    lot.Quantity,
+		"outstanding": outstanding,
+		"projects":    projectList,
+		"users":       userList,
+		"recipients":  recipients,
+	})
+}
+
+func applyLotDates(lot *models.Lot, input LotInput) error {
+	lot.ReceivedDate = time.Now().UTC().Truncate(24 * time.Hour)
+	if input.ReceivedDate != "" {
+		date, err := time.Parse("2006-01-02", input.ReceivedDate)
+		if err != nil {
+			return errors.New("received_date must be a date like 2006-01-02")
+		}
+		lot.ReceivedDate = date
+	}
+
+	var err error
+	if lot.ManufactureDate, err = optionalDate(input.ManufactureDate, "manufacture_date"); err != nil {
+		return err
+	}
+	if lot.ExpiryDate, err = optionalDate(input.ExpiryDate, "expiry_date"); err != nil {
+		return err
+	}
+	if lot.ManufactureDate != nil && lot.ExpiryDate != nil && lot.ExpiryDate.Before(*lot.ManufactureDate) {
+		return errors.New("expiry_date cannot be before manufacture_date")
+	}
+	return nil
+}
+
+func optionalDate(value, field string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a date like 2006-01-02", field)
+	}
+	return &date, nil
+}
+
+func lotPolicy(item models.Item) string {
+	if item.LotPolicy == models.LotPolicyFIFO {
+		return models.LotPolicyFIFO
+	}
+	return models.LotPolicyFEFO
+}
+
+func validateLotPolicy(policy string) error {
+	if policy != "" && policy != models.LotPolicyFEFO && policy != models.LotPolicyFIFO {
+		return errors.New("LotPolicy must be fefo or fifo")
+	}
+	return nil
+}
+
+// lotOrder sorts an item's lots in the order stock is taken from them.
+func lotOrder(query *gorm.DB, item models.Item) *gorm.DB {
+	if lotPolicy(item) == models.LotPolicyFIFO {
+		return query.Order("received_date, id")
+	}
+	return query.Order("expiry_date IS NULL, expiry_date, received_date, id")
+}
+
+// lotQuantity is how much of an item is held in lots.
+func lotQuantity(tx *gorm.DB, itemID uint) (int, error) {
+	var lotted int
+	err := tx.Model(&models.Lot{}).Where("item_id = ?", itemID).
+		Select("COALESCE(SUM(quantity), 0)").Scan(&lotted).Error
+	return lotted, err
+}
+
+// allocateLots takes quantity of an item out of its lots, before
+// Item.Quantity is lowered, and returns what came from which lot. With a lot
+// the stock comes from that lot; otherwise lots are used in policy order,
+// then stock without a lot. Expired lots are never issued.
+func allocateLots(tx *gorm.DB, item models.Item, quantity int, lotID *uint) ([]models.BorrowLot, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	if lotID != nil {
+		var lot models.Lot
+		if err := tx.Scopes(forUpdate).Where("id = ? AND item_id = ?", *lotID, item.ID).First(&lot).Error; err != nil {
+			return nil, stockError{http.StatusBadRequest, "Invalid lot ID provided"}
+		}
+		if lot.ExpiryDate != nil && lot.ExpiryDate.Before(today) {
+			return nil, stockError{http.StatusBadRequest, fmt.Sprintf("Lot %s expired on %s", lot.Code, lot.ExpiryDate.Format("2006-01-02"))}
+		}
+		if lot.Quantity < quantity {
+			return nil, stockError{http.StatusBadRequest, fmt.Sprintf("Only %d left in lot %s", lot.Quantity, lot.Code)}
+		}
+		if err := tx.Model(&lot).Update("quantity", gorm.Expr("quantity - ?", quantity)).Error; err != nil {
+			return nil, err
+		}
+		return []models.BorrowLot{{LotID: lot.ID, Quantity: quantity}}, nil
+	}
+
+	var lots []models.Lot
+	if err := lotOrder(tx.Scopes(forUpdate).Where("item_id = ? AND quantity > 0", item.ID), item).Find(&lots).Error; err != nil {
+		return nil, err
+	}
+
+	var allocations []models.BorrowLot
+	remaining, lotted, expired := quantity, 0, 0
+	for _, lot := range lots {
+		lotted += lot.Quantity
+		if lot.ExpiryDate != nil && lot.ExpiryDate.Before(today) {
+			expired += lot.Quantity
+			continue
+		}
+		if remaining == 0 {
+			continue
+		}
+		take := lot.Quantity
+		if take > remaining {
+			take = remaining
+		}
+		remaining -= take
+		if err := tx.Model(&lot).Update("quantity", gorm.Expr("quantity - ?", take)).Error; err != nil {
+			return nil, err
+		}
+		allocations = append(allocations, models.BorrowLot{LotID: lot.ID, Quantity: take})
+	}
+
+	if unlotted := item.Quantity - lotted; remaining > unlotted {
+		return nil, stockError{http.StatusBadRequest, fmt.Sprintf("Not enough %s that can be issued: %d are in expired lots", item.Name, expired)}
+	}
+	return allocations, nil
+}
+
+// recordBorrowLots links the lot allocations to their borrow transaction.
+func recordBorrowLots(tx *gorm.DB, borrowID uint, allocations []models.BorrowLot) error {
+	for i := range allocations {
+		allocations[i].BorrowID = borrowID
+	}
+	if len(allocations) == 0 {
+		return nil
+	}
+	return tx.Create(&allocations).Error
+}
+
+// restoreLots puts returned units back into the lots they were borrowed
+// from. Units beyond what came from lots are returned without a lot.
+func restoreLots(tx *gorm.DB, borrowID uint, quantity int) error {
+	var issues []models.BorrowLot
+	if err := tx.Where("borrow_id = ? AND returned_quantity < quantity", borrowID).Order("id").Find(&issues).Error; err != nil {
+		return err
+	}
+	for _, issue := range issues {
+		if quantity <= 0 {
+			break
+		}
+		back := issue.Quantity - issue.ReturnedQuantity
+		if back > quantity {
+			back = quantity
+		}
+		quantity -= back
+		issue.ReturnedQuantity += back
+		if err := tx.Save(&issue).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Lot{}).Where("id = ?", issue.LotID).
+			Update("quantity", gorm.Expr("quantity + ?", back)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trimLots takes stock out of an item's lots, newest first, until they hold
+// no more than quantity. Call it after Item.Quantity dropped without the
+// stock being issued, e.g. when a loss is written off.
+func trimLots(tx *gorm.DB, itemID uint, quantity int) error {
+	lotted, err := lotQuantity(tx, itemID)
+	if err != nil {
+		return err
+	}
+	excess := lotted - quantity
+	if excess <= 0 {
+		return nil
+	}
+
+	var lots []models.Lot
+	if err := tx.Scopes(forUpdate).Where("item_id = ? AND quantity > 0", itemID).Order("received_date desc, id desc").Find(&lots).Error; err != nil {
+		return err
+	}
+	for _, lot := range lots {
+		if excess <= 0 {
+			break
+		}
+		take := lot.Quantity
+		if take > excess {
+			take = excess
+		}
+		excess -= take
+		if err := tx.Model(&lot).Update("quantity", gorm.Expr("quantity - ?", take)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"warehouse-store/models"
+)
+
+// newTestDB opens an empty in-memory database with the given models migrated.
+func newTestDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	// Every connection to :memory: is a database of its own
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("migrating test database: %v", err)
+	}
+	return db
+}
+
+func TestAllocateLots(t *testing.T) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	yesterday := today.AddDate(0, 0, -1)
+	nextWeek := today.AddDate(0, 0, 7)
+	nextMonth := today.AddDate(0, 1, 0)
+	uintPtr := func(v uint) *uint { return &v }
+
+	// Lot IDs follow the order they are created in
+	const (
+		expired = iota + 1
+		expiresToday
+		expiresNextMonth
+		expiresNextWeek
+		noExpiry
+	)
+
+	tests := []struct {
+		name        string
+		policy      string
+		quantity    int
+		lotID       *uint
+		allocations []models.BorrowLot
+		err         string
+	}{
+		{name: "earliest expiry first, expired lot skipped", quantity: 3,
+			allocations: []models.BorrowLot{{LotID: expiresToday, Quantity: 1}, {LotID: expiresNextWeek, Quantity: 2}}},
+		{name: "lot without expiry last", quantity: 10,
+			allocations: []models.BorrowLot{{LotID: expiresToday, Quantity: 1}, {LotID: expiresNextWeek, Quantity: 2}, {LotID: expiresNextMonth, Quantity: 4}, {LotID: noExpiry, Quantity: 3}}},
+		{name: "stock without a lot after the lots", quantity: 12,
+			allocations: []models.BorrowLot{{LotID: expiresToday, Quantity: 1}, {LotID: expiresNextWeek, Quantity: 2}, {LotID: expiresNextMonth, Quantity: 4}, {LotID: noExpiry, Quantity: 3}}},
+		{name: "expired stock cannot make up the quantity", quantity: 13,
+			err: "Not enough tape that can be issued: 5 are in expired lots"},
+		{name: "fifo still skips the expired lot", policy: models.LotPolicyFIFO, quantity: 2,
+			allocations: []models.BorrowLot{{LotID: expiresToday, Quantity: 1}, {LotID: expiresNextMonth, Quantity: 1}}},
+		{name: "named lot", quantity: 2, lotID: uintPtr(expiresNextMonth),
+			allocations: []models.BorrowLot{{LotID: expiresNextMonth, Quantity: 2}}},
+		{name: "named lot that expires today", quantity: 1, lotID: uintPtr(expiresToday),
+			allocations: []models.BorrowLot{{LotID: expiresToday, Quantity: 1}}},
+		{name: "named lot that has expired", quantity: 1, lotID: uintPtr(expired),
+			err: "Lot E1 expired on " + yesterday.Format("2006-01-02")},
+		{name: "named lot without enough", quantity: 5, lotID: uintPtr(expiresNextMonth),
+			err: "Only 4 left in lot M1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, &models.Item{}, &models.Lot{})
+			item := models.Item{Name: "tape", Quantity: 17, Type: "consumable", LotPolicy: tt.policy}
+			if err := db.Create(&item).Error; err != nil {
+				t.Fatal(err)
+			}
+			lots := []models.Lot{
+				{Code: "E1", Quantity: 5, ExpiryDate: &yesterday},
+				{Code: "T1", Quantity: 1, ExpiryDate: &today},
+				{Code: "M1", Quantity: 4, ExpiryDate: &nextMonth},
+				{Code: "W1", Quantity: 2, ExpiryDate: &nextWeek},
+				{Code: "N1", Quantity: 3},
+			}
+			for i := range lots {
+				lots[i].ItemID = item.ID
+				lots[i].ReceivedDate = today.AddDate(0, 0, i-10)
+				lots[i].ReceivedQuantity = lots[i].Quantity
+			}
+			if err := db.Create(&lots).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			var allocations []models.BorrowLot
+			err := db.Transaction(func(tx *gorm.DB) error {
+				var err error
+				allocations, err = allocateLots(tx, item, tt.quantity, tt.lotID)
+				return err
+			})
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("allocateLots error = %v; want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("allocateLots: %v", err)
+			}
+			if !reflect.DeepEqual(allocations, tt.allocations) {
+				t.Errorf("allocateLots = %+v; want %+v", allocations, tt.allocations)
+			}
+
+			taken := make(map[uint]int)
+			for _, allocation := range allocations {
+				taken[allocation.LotID] += allocation.Quantity
+			}
+			var after []models.Lot
+			if err := db.Order("id").Find(&after).Error; err != nil {
+				t.Fatal(err)
+			}
+			for i, lot := range after {
+				if want := lots[i].Quantity - taken[lot.ID]; lot.Quantity != want {
+					t.Errorf("lot %s has %d left; want %d", lot.Code, lot.Quantity, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,666 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+type MaintenanceController struct {
+	DB *gorm.DB
+}
+
+func NewMaintenanceController(db *gorm.DB) *MaintenanceController {
+	return &MaintenanceController{DB: db}
+}
+
+// UsageInput is how much a serialized unit was used while it was out.
+type UsageInput struct {
+	SerialNumber  string `json:"serial_number" binding:"required"`
+	Flights       int    `json:"flights" binding:"min=0"`
+	FlightMinutes int    `json:"flight_minutes" binding:"min=0"`
+	BatteryCycles int    `json:"battery_cycles" binding:"min=0"`
+	Note          string `json:"note"`
+}
+
+type MaintenanceRuleInput struct {
+	Name               string `json:"name" binding:"required"`
+	Description        string `json:"description"`
+	ItemID             *uint  `json:"item_id"` // Either item_id or category_id
+	CategoryID         *uint  `json:"category_id"`
+	EveryFlights       *int   `json:"every_flights"`
+	EveryFlightHours   *int   `json:"every_flight_hours"`
+	EveryBatteryCycles *int   `json:"every_battery_cycles"`
+}
+
+type WorkOrderInput struct {
+	SerialNumber string `json:"serial_number" binding:"required"`
+	Reason       string `json:"reason" binding:"required"`
+}
+
+type WorkOrderCompletionInput struct {
+	WorkDone string   `json:"work_done" binding:"required"`
+	Cost     *float64 `json:"cost" binding:"omitempty,min=0"`
+}
+
+// usageTotals is the usage of a unit over a period.
+type usageTotals struct {
+	Flights       int `json:"flights"`
+	FlightMinutes int `json:"flight_minutes"`
+	BatteryCycles int `json:"battery_cycles"`
+}
+
+// ruleProgress is the usage of a unit towards the next service of a rule.
+type ruleProgress struct {
+	Rule        models.MaintenanceRule `json:"rule"`
+	LastService *time.Time             `json:"last_service"`
+	Usage       usageTotals            `json:"usage"`
+	Due         bool                   `json:"due"`
+}
+
+func (ctrl *MaintenanceController) GetMaintenanceRules(c *gin.Context) {
+	query := ctrl.DB.Preload("Item").Preload("Category").Order("id")
+	if itemID := c.Query("item_id"); itemID != "" {
+		query = query.Where("item_id = ?", itemID)
+	}
+	if categoryID := c.Query("category_id"); categoryID != "" {
+		query = query.Where("category_id = ?", categoryID)
+	}
+
+	var rules []models.MaintenanceRule
+	if err := query.Find(&rules).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch maintenance rules"})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// CreateMaintenanceRule adds a rule and opens work orders for the units
+// that are already due by it.
+func (ctrl *MaintenanceController) CreateMaintenanceRule(c *gin.Context) {
+	var input MaintenanceRuleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rule models.MaintenanceRule
+	var opened []models.WorkOrder
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := setMaintenanceRule(tx, &rule, input); err != nil {
+			return err
+		}
+		if err := tx.Create(&rule).Error; err != nil {
+			return err
+		}
+		var err error
+		opened, err = evaluateRuleUnits(tx, rule)
+		return err
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to create maintenance rule")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"rule": rule, "opened_work_orders": opened})
+}
+
+// UpdateMaintenanceRule changes a rule. Work orders it already opened stay
+// open.
+func (ctrl *MaintenanceController) UpdateMaintenanceRule(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input MaintenanceRuleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rule models.MaintenanceRule
+	var opened []models.WorkOrder
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&rule, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Maintenance rule not found"}
+		}
+		if err := setMaintenanceRule(tx, &rule, input); err != nil {
+			return err
+		}
+		if err := tx.Save(&rule).Error; err != nil {
+			return err
+		}
+		var err error
+		opened, err = evaluateRuleUnits(tx, rule)
+		return err
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to update maintenance rule")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rule": rule, "opened_work_orders": opened})
+}
+
+// DeleteMaintenanceRule removes a rule. Work orders it opened stay open
+// until they are completed or cancelled.
+func (ctrl *MaintenanceController) DeleteMaintenanceRule(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := ctrl.DB.WithContext(c).Delete(&models.MaintenanceRule{}, id).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete maintenance rule"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Maintenance rule deleted successfully"})
+}
+
+// GetAssetMaintenance returns the usage of one serialized unit, its
+// progress towards the next service of every rule and its work orders.
+func (ctrl *MaintenanceController) GetAssetMaintenance(c *gin.Context) {
+	var warranty models.Warranty
+	if err := ctrl.DB.Where("serial_number = ?", c.Param("serial")).First(&warranty).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Serial number not found"})
+		return
+	}
+
+	lifetime, err := usageSince(ctrl.DB, warranty.ID, nil)
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+		return
+	}
+	rules, err := maintenanceRulesFor(ctrl.DB, warranty.DroneID)
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch maintenance rules"})
+		return
+	}
+	progress := make([]ruleProgress, 0, len(rules))
+	for _, rule := range rules {
+		lastService, err := lastServiceAt(ctrl.DB, warranty.ID, &rule.ID)
+		if err != nil {
+			utils.LogError("Failed", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+			return
+		}
+		usage, err := usageSince(ctrl.DB, warranty.ID, lastService)
+		if err != nil {
+			utils.LogError("Failed", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+			return
+		}
+		_, due := maintenanceDue(rule, usage)
+		progress = append(progress, ruleProgress{Rule: rule, LastService: lastService, Usage: usage, Due: due})
+	}
+
+	var workOrders []models.WorkOrder
+	if err := ctrl.DB.Preload("Rule").Where("warranty_id = ?", warranty.ID).Order("id desc").Find(&workOrders).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch work orders"})
+		return
+	}
+	var logs []models.UsageLog
+	if err := ctrl.DB.Preload("User", auditActorColumns).Where("warranty_id = ?", warranty.ID).Order("id desc").Find(&logs).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+		return
+	}
+
+	due := false
+	for _, order := range workOrders {
+		due = due || order.Status == models.WorkOrderOpen
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"warranty":        warranty,
+		"maintenance_due": due,
+		"usage":           lifetime,
+		"rules":           progress,
+		"work_orders":     workOrders,
+		"usage_logs":      logs,
+	})
+}
+
+// GetMaintenanceDue lists the units due for maintenance: those with an open
+// work order.
+func (ctrl *MaintenanceController) GetMaintenanceDue(c *gin.Context) {
+	query := ctrl.DB.Preload("Warranty").Preload("Rule").
+		Joins("JOIN warranties ON warranties.id = work_orders.warranty_id").
+		Where("work_orders.status = ?", models.WorkOrderOpen).Order("work_orders.id")
+	if itemID := c.Query("item_id"); itemID != "" {
+		query = query.Where("warranties.drone_id = ?", itemID)
+	}
+
+	var workOrders []models.WorkOrder
+	if err := query.Find(&workOrders).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch units due for maintenance"})
+		return
+	}
+	c.JSON(http.StatusOK, workOrders)
+}
+
+func (ctrl *MaintenanceController) GetWorkOrders(c *gin.Context) {
+	query := ctrl.DB.Preload("Warranty").Preload("Rule").
+		Preload("CreatedBy", auditActorColumns).Preload("CompletedBy", auditActorColumns).
+		Order("work_orders.id desc")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("work_orders.status = ?", status)
+	}
+	if serial := c.Query("serial_number"); serial != "" {
+		query = query.Joins("JOIN warranties ON warranties.id = work_orders.warranty_id").
+			Where("warranties.serial_number = ?", serial)
+	}
+	if ruleID := c.Query("rule_id"); ruleID != "" {
+		query = query.Where("work_orders.rule_id = ?", ruleID)
+	}
+
+	var workOrders []models.WorkOrder
+	if err := query.Find(&workOrders).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch work orders"})
+		return
+	}
+	c.JSON(http.StatusOK, workOrders)
+}
+
+func (ctrl *MaintenanceController) GetWorkOrderByID(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var workOrder models.WorkOrder
+	err := ctrl.DB.Preload("Warranty").Preload("Rule").
+		Preload("CreatedBy", auditActorColumns).Preload("CompletedBy", auditActorColumns).
+		First(&workOrder, id).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Work order not found"})
+		return
+	}
+	c.JSON(http.StatusOK, workOrder)
+}
+
+// CreateWorkOrder opens a work order by hand, e.g. after a hard landing. The
+// unit cannot be borrowed until it is completed or cancelled.
+func (ctrl *MaintenanceController) CreateWorkOrder(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var input WorkOrderInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var workOrder models.WorkOrder
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var warranty models.Warranty
+		if err := tx.Where("serial_number = ?", strings.TrimSpace(input.SerialNumber)).First(&warranty).Error; err != nil {
+			return stockError{http.StatusNotFound, "Serial number not found"}
+		}
+		lastService, err := lastServiceAt(tx, warranty.ID, nil)
+		if err != nil {
+			return err
+		}
+		usage, err := usageSince(tx, warranty.ID, lastService)
+		if err != nil {
+			return err
+		}
+		workOrder = models.WorkOrder{
+			WarrantyID:    warranty.ID,
+			Status:        models.WorkOrderOpen,
+			Reason:        input.Reason,
+			Flights:       usage.Flights,
+			FlightMinutes: usage.FlightMinutes,
+			BatteryCycles: usage.BatteryCycles,
+			CreatedByID:   &userID,
+		}
+		return tx.Create(&workOrder).Error
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to create work order")
+		return
+	}
+	c.JSON(http.StatusCreated, workOrder)
+}
+
+// CompleteWorkOrder records the work done and clears the unit for
+// borrowing. Usage towards the rule that opened it starts again from zero.
+func (ctrl *MaintenanceController) CompleteWorkOrder(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input WorkOrderCompletionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	ctrl.closeWorkOrder(c, id, map[string]interface{}{
+		"status":          models.WorkOrderCompleted,
+		"completed_by_id": userID,
+		"completed_at":    now,
+		"work_done":       input.WorkDone,
+		"cost":            input.Cost,
+	})
+}
+
+// CancelWorkOrder closes a work order without servicing the unit. A rule
+// that is still due opens a new one at the next return.
+func (ctrl *MaintenanceController) CancelWorkOrder(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	ctrl.closeWorkOrder(c, id, map[string]interface{}{"status": models.WorkOrderCancelled})
+}
+
+func (ctrl *MaintenanceController) closeWorkOrder(c *gin.Context, id int, updates map[string]interface{}) {
+	var workOrder models.WorkOrder
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&workOrder, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Work order not found"}
+		}
+		// Only one request can close the work order
+		result := tx.Model(&models.WorkOrder{}).Where("id = ? AND status = ?", workOrder.ID, models.WorkOrderOpen).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return stockError{http.StatusConflict, fmt.Sprintf("Work order is %s", workOrder.Status)}
+		}
+		return tx.Preload("Warranty").Preload("Rule").Preload("CompletedBy", auditActorColumns).First(&workOrder, workOrder.ID).Error
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to update work order")
+		return
+	}
+	c.JSON(http.StatusOK, workOrder)
+}
+
+// setMaintenanceRule validates input and copies it into rule.
+func setMaintenanceRule(tx *gorm.DB, rule *models.MaintenanceRule, input MaintenanceRuleInput) error {
+	if (input.ItemID == nil) == (input.CategoryID == nil) {
+		return stockError{http.StatusBadRequest, "Give either item_id or category_id"}
+	}
+	if input.ItemID != nil {
+		if err := tx.First(&models.Item{}, *input.ItemID).Error; err != nil {
+			return stockError{http.StatusBadRequest, "Invalid item ID provided"}
+		}
+	}
+	if input.CategoryID != nil {
+		if err := tx.First(&models.Category{}, *input.CategoryID).Error; err != nil {
+			return stockError{http.StatusBadRequest, "Invalid category ID provided"}
+		}
+	}
+	thresholds := 0
+	for _, every := range []*int{input.EveryFlights, input.EveryFlightHours, input.EveryBatteryCycles} {
+		if every == nil {
+			continue
+		}
+		if *every < 1 {
+			return stockError{http.StatusBadRequest, "Thresholds must be at least 1"}
+		}
+		thresholds++
+	}
+	if thresholds == 0 {
+		return stockError{http.StatusBadRequest, "Give at least one of every_flights, every_flight_hours and every_battery_cycles"}
+	}
+
+	rule.Name = strings.TrimSpace(input.Name)
+	rule.Description = input.Description
+	rule.ItemID = input.ItemID
+	rule.CategoryID = input.CategoryID
+	rule.EveryFlights = input.EveryFlights
+	rule.EveryFlightHours = input.EveryFlightHours
+	rule.EveryBatteryCycles = input.EveryBatteryCycles
+	return nil
+}
+
+// recordUsage logs the usage of units that came back with items itemIDs and
+// opens work orders for those now due. origin carries the ReturnID,
+// BoxLoanID or KitLoanID of the return the usage is logged on. Call it
+// inside a transaction.
+func recordUsage(tx *gorm.DB, userID uint, usage []UsageInput, itemIDs []uint, origin models.UsageLog) ([]models.WorkOrder, error) {
+	if len(usage) == 0 {
+		return nil, nil
+	}
+	serials := make([]string, 0, len(usage))
+	seen := make(map[string]bool, len(usage))
+	for _, u := range usage {
+		serial := strings.TrimSpace(u.SerialNumber)
+		if seen[serial] {
+			return nil, stockError{http.StatusBadRequest, fmt.Sprintf("Usage of serial number %s is given twice", serial)}
+		}
+		seen[serial] = true
+		serials = append(serials, serial)
+	}
+
+	var warranties []models.Warranty
+	if err := tx.Where("serial_number IN ?", serials).Find(&warranties).Error; err != nil {
+		return nil, err
+	}
+	bySerial := make(map[string]models.Warranty, len(warranties))
+	for _, w := range warranties {
+		bySerial[w.SerialNumber] = w
+	}
+	returned := make(map[uint]bool, len(itemIDs))
+	for _, itemID := range itemIDs {
+		returned[itemID] = true
+	}
+
+	logs := make([]models.UsageLog, 0, len(usage))
+	warrantyIDs := make([]uint, 0, len(usage))
+	for i, u := range usage {
+		w, ok := bySerial[serials[i]]
+		if !ok {
+			return nil, stockError{http.StatusBadRequest, fmt.Sprintf("Serial number %s not found", serials[i])}
+		}
+		if !returned[w.DroneID] {
+			return nil, stockError{http.StatusBadRequest, fmt.Sprintf("Serial number %s is not a unit of what is being returned", serials[i])}
+		}
+		logs = append(logs, models.UsageLog{
+			WarrantyID:    w.ID,
+			ReturnID:      origin.ReturnID,
+			BoxLoanID:     origin.BoxLoanID,
+			KitLoanID:     origin.KitLoanID,
+			UserID:        userID,
+			Flights:       u.Flights,
+			FlightMinutes: u.FlightMinutes,
+			BatteryCycles: u.BatteryCycles,
+			Note:          u.Note,
+		})
+		warrantyIDs = append(warrantyIDs, w.ID)
+	}
+	if err := tx.Create(&logs).Error; err != nil {
+		return nil, err
+	}
+	return evaluateMaintenance(tx, warrantyIDs)
+}
+
+// evaluateRuleUnits opens work orders for the units of the items a rule
+// covers that are due by it.
+func evaluateRuleUnits(tx *gorm.DB, rule models.MaintenanceRule) ([]models.WorkOrder, error) {
+	units := tx.Model(&models.Warranty{})
+	if rule.ItemID != nil {
+		units = units.Where("drone_id = ?", *rule.ItemID)
+	} else {
+		units = units.Where("drone_id IN (?)", tx.Model(&models.Item{}).Select("id").Where("category_id = ?", *rule.CategoryID))
+	}
+	var warrantyIDs []uint
+	if err := units.Pluck("id", &warrantyIDs).Error; err != nil {
+		return nil, err
+	}
+	return evaluateMaintenance(tx, warrantyIDs)
+}
+
+// evaluateMaintenance opens a work order for every rule a unit is due by
+// that has no open work order for the unit yet.
+func evaluateMaintenance(tx *gorm.DB, warrantyIDs []uint) ([]models.WorkOrder, error) {
+	opened := []models.WorkOrder{}
+	if len(warrantyIDs) == 0 {
+		return opened, nil
+	}
+	var warranties []models.Warranty
+	if err := tx.Where("id IN ?", warrantyIDs).Order("id").Find(&warranties).Error; err != nil {
+		return nil, err
+	}
+
+	rulesByItem := make(map[uint][]models.MaintenanceRule)
+	for _, warranty := range warranties {
+		if _, ok := rulesByItem[warranty.DroneID]; ok {
+			continue
+		}
+		rules, err := maintenanceRulesFor(tx, warranty.DroneID)
+		if err != nil {
+			return nil, err
+		}
+		rulesByItem[warranty.DroneID] = rules
+	}
+
+	for _, warranty := range warranties {
+		for _, rule := range rulesByItem[warranty.DroneID] {
+			var open int64
+			if err := tx.Model(&models.WorkOrder{}).
+				Where("warranty_id = ? AND rule_id = ? AND status = ?", warranty.ID, rule.ID, models.WorkOrderOpen).
+				Count(&open).Error; err != nil {
+				return nil, err
+			}
+			if open > 0 {
+				continue
+			}
+			lastService, err := lastServiceAt(tx, warranty.ID, &rule.ID)
+			if err != nil {
+				return nil, err
+			}
+			usage, err := usageSince(tx, warranty.ID, lastService)
+			if err != nil {
+				return nil, err
+			}
+			reason, due := maintenanceDue(rule, usage)
+			if !due {
+				continue
+			}
+			ruleID := rule.ID
+			workOrder := models.WorkOrder{
+				WarrantyID:    warranty.ID,
+				RuleID:        &ruleID,
+				Status:        models.WorkOrderOpen,
+				Reason:        fmt.Sprintf("%s: %s", rule.Name, reason),
+				Flights:       usage.Flights,
+				FlightMinutes: usage.FlightMinutes,
+				BatteryCycles: usage.BatteryCycles,
+			}
+			if err := tx.Create(&workOrder).Error; err != nil {
+				return nil, err
+			}
+			workOrder.Warranty = warranty
+			opened = append(opened, workOrder)
+		}
+	}
+	return opened, nil
+}
+
+// maintenanceDue reports whether usage reaches any threshold of rule, and
+// which.
+func maintenanceDue(rule models.MaintenanceRule, usage usageTotals) (string, bool) {
+	var reasons []string
+	if rule.EveryFlights != nil && usage.Flights >= *rule.EveryFlights {
+		reasons = append(reasons, fmt.Sprintf("%d flights since last service (every %d)", usage.Flights, *rule.EveryFlights))
+	}
+	if rule.EveryFlightHours != nil && usage.FlightMinutes >= *rule.EveryFlightHours*60 {
+		reasons = append(reasons, fmt.Sprintf("%.1f flight hours since last service (every %d)", float64(usage.FlightMinutes)/60, *rule.EveryFlightHours))
+	}
+	if rule.EveryBatteryCycles != nil && usage.BatteryCycles >= *rule.EveryBatteryCycles {
+		reasons = append(reasons, fmt.Sprintf("%d battery cycles since last service (every %d)", usage.BatteryCycles, *rule.EveryBatteryCycles))
+	}
+	return strings.Join(reasons, ", "), len(reasons) > 0
+}
+
+// maintenanceRulesFor returns the rules for an item and for its category.
+func maintenanceRulesFor(db *gorm.DB, itemID uint) ([]models.MaintenanceRule, error) {
+	var item models.Item
+	if err := db.Select("id", "category_id").First(&item, itemID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var rules []models.MaintenanceRule
+	err := db.Where("item_id = ? OR category_id = ?", item.ID, item.CategoryID).Order("id").Find(&rules).Error
+	return rules, err
+}
+
+// lastServiceAt is when the last work order for a unit was completed; for
+// rule ruleID only when it is given. Nil when the unit was never serviced.
+func lastServiceAt(db *gorm.DB, warrantyID uint, ruleID *uint) (*time.Time, error) {
+	query := db.Where("warranty_id = ? AND status = ?", warrantyID, models.WorkOrderCompleted)
+	if ruleID != nil {
+		query = query.Where("rule_id = ?", *ruleID)
+	}
+	var workOrders []models.WorkOrder
+	if err := query.Order("completed_at desc").Limit(1).Find(&workOrders).Error; err != nil {
+		return nil, err
+	}
+	if len(workOrders) == 0 {
+		return nil, nil
+	}
+	return workOrders[0].CompletedAt, nil
+}
+
+// usageSince totals the usage logged for a unit after since, or ever when
+// since is nil.
+func usageSince(db *gorm.DB, warrantyID uint, since *time.Time) (usageTotals, error) {
+	query := db.Model(&models.UsageLog{}).
+		Select("COALESCE(SUM(flights), 0) AS flights, COALESCE(SUM(flight_minutes), 0) AS flight_minutes, COALESCE(SUM(battery_cycles), 0) AS battery_cycles").
+		Where("warranty_id = ?", warrantyID)
+	if since != nil {
+		query = query.Where("created_at > ?", *since)
+	}
+	var totals usageTotals
+	err := query.Scan(&totals).Error
+	return totals, err
+}
+
+// checkMaintenance fails when borrowing quantity of an item would need
+// units that are due for maintenance. Due units are taken to be in the
+// warehouse.
+func checkMaintenance(tx *gorm.DB, item models.Item, quantity int) error {
+	var due int64
+	err := tx.Model(&models.WorkOrder{}).
+		Joins("JOIN warranties ON warranties.id = work_orders.warranty_id AND warranties.deleted_at IS NULL").
+		Where("warranties.drone_id = ? AND work_orders.status = ?", item.ID, models.WorkOrderOpen).
+		Distinct("work_orders.warranty_id").Count(&due).Error
+	if err != nil {
+		return err
+	}
+	if available := item.Quantity - int(due); due > 0 && available < quantity {
+		if available < 0 {
+			available = 0
+		}
+		return stockError{http.StatusConflict, fmt.Sprintf("%d %s due for maintenance. Available: %d", due, item.Name, available)}
+	}
+	return nil
+}
+
+// checkSerialsMaintenance fails when any of the units is due for
+// maintenance.
+func checkSerialsMaintenance(tx *gorm.DB, warranties []models.Warranty) error {
+	if len(warranties) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(warranties))
+	for _, w := range warranties {
+		ids = append(ids, w.ID)
+	}
+	var due []string
+	err := tx.Model(&models.Warranty{}).
+		Where("id IN (?)", tx.Model(&models.WorkOrder{}).Select("warranty_id").Where("warranty_id IN ? AND status = ?", ids, models.WorkOrderOpen)).
+		Order("serial_number").Pluck("serial_number", &due).Error
+	if err != nil {
+		return err
+	}
+	if len(due) > 0 {
+		return stockError{http.StatusConflict, fmt.Sprintf("Due for maintenance: %s", strings.Join(due, ", "))}
+	}
+	return nil
+}
@@ -0,0 +1,423 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+type OIDCController struct {
+	DB          *gorm.DB
+	Provider    *utils.OIDCProvider // nil when single sign-on is not configured
+	GroupsClaim string
+	RoleMapping map[string]string // IdP group -> warehouse role
+	DefaultRole string
+	FrontendURL string
+}
+
+// NewOIDCController parses roleMapping of the form "group=role,group=role".
+func NewOIDCController(db *gorm.DB, provider *utils.OIDCProvider, groupsClaim, roleMapping, defaultRole, frontendURL string) *OIDCController {
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(roleMapping, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+			mapping[parts[0]] = parts[1]
+		}
+	}
+	return &OIDCController{
+		DB:          db,
+		Provider:    provider,
+		GroupsClaim: groupsClaim,
+		RoleMapping: mapping,
+		DefaultRole: defaultRole,
+		FrontendURL: frontendURL,
+	}
+}
+
+// Login redirects the browser to the identity provider.
+func (ctrl *OIDCController) Login(c *gin.Context) {
+	authURL, ok := ctrl.startFlow(c, 0)
+	if !ok {
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Link starts single sign-on for the current user so the identity they sign
+// in with is linked to their account. Accounts are never linked by email.
+func (ctrl *OIDCController) Link(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	authURL, ok := ctrl.startFlow(c, userID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+}
+
+// startFlow saves the login state and returns the provider's authorization
+// URL. It writes the error response itself and reports false on failure.
+func (ctrl *OIDCController) startFlow(c *gin.Context, linkUserID uint) (string, bool) {
+	if ctrl.Provider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return "", false
+	}
+
+	state, errState := utils.GenerateRandomToken(16)
+	nonce, errNonce := utils.GenerateRandomToken(16)
+	verifier, challenge, errPKCE := utils.NewPKCEVerifier()
+	if errState != nil || errNonce != nil || errPKCE != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start single sign-on"})
+		return "", false
+	}
+
+	ctx := c.Request.Context()
+	loginState := utils.OIDCLoginState{Nonce: nonce, CodeVerifier: verifier, LinkUserID: linkUserID}
+	if err := utils.SaveOIDCState(ctx, state, loginState); err != nil {
+		utils.LogError("Failed to save OIDC state", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start single sign-on"})
+		return "", false
+	}
+
+	authURL, err := ctrl.Provider.AuthCodeURL(ctx, state, nonce, challenge)
+	if err != nil {
+		utils.LogError("Failed to build OIDC authorization URL", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
+		return "", false
+	}
+	return authURL, true
+}
+
+// Callback completes the flow: it verifies the ID token, then either links
+// the identity to the user who started a link, or provisions or updates the
+// local user and issues warehouse tokens. Users with two-factor
+// authentication get an MFA challenge to finish through LoginMFA.
+func (ctrl *OIDCController) Callback(c *gin.Context) {
+	if ctrl.Provider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	if idpError := c.Query("error"); idpError != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed: " + idpError})
+		return
+	}
+
+	ctx := c.Request.Context()
+	state, err := utils.TakeOIDCState(ctx, c.Query("state"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login state"})
+		return
+	}
+
+	rawIDToken, err := ctrl.Provider.Exchange(ctx, c.Query("code"), state.CodeVerifier)
+	if err != nil {
+		utils.LogError("OIDC code exchange failed", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed"})
+		return
+	}
+
+	claims, err := ctrl.Provider.VerifyIDToken(ctx, rawIDToken, state.Nonce)
+	if err != nil {
+		utils.LogError("OIDC ID token rejected", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed"})
+		return
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed"})
+		return
+	}
+
+	if state.LinkUserID != 0 {
+		ctrl.linkUser(c, state.LinkUserID, subject)
+		return
+	}
+
+	role := ctrl.mapRole(stringList(claims[ctrl.GroupsClaim]))
+	if role == "" {
+		auditLog := models.AuditLog{
+			Action:    "login_sso_denied",
+			TableName: "users",
+			NewValue:  "No warehouse role mapped for subject: " + subject,
+			IPAddress: c.ClientIP(),
+		}
+		ctrl.DB.Create(&auditLog)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your account has no access to the warehouse"})
+		return
+	}
+
+	user, err := ctrl.provisionUser(c, subject, claims, role)
+	if err == errSSOEmailTaken {
+		c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists, sign in to it and link single sign-on from there"})
+		return
+	}
+	if err != nil {
+		utils.LogError("Failed to provision SSO user", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+
+	// The IdP's own second factor does not replace the one set up here
+	if user.TOTPEnabled {
+		challenge, err := utils.GenerateMFAChallengeToken(user.ID, user.TokenVersion)
+		if err != nil {
+			utils.LogError("Failed to generate MFA challenge token", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		if ctrl.FrontendURL != "" {
+			fragment := url.Values{}
+			fragment.Set("mfa_required", "true")
+			fragment.Set("mfa_token", challenge)
+			c.Redirect(http.StatusFound, ctrl.FrontendURL+"#"+fragment.Encode())
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    challenge,
+		})
+		return
+	}
+
+	tokens, err := generateLoginTokens(user, amrIncludesMFA(claims["amr"]))
+	if err != nil {
+		utils.LogError("Failed to generate token", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	auditLog := models.AuditLog{
+		UserID:    &user.ID,
+		Action:    "login_sso",
+		TableName: "users",
+		RecordID:  user.ID,
+		NewValue:  "User logged in with single sign-on",
+		IPAddress: c.ClientIP(),
+	}
+	ctrl.DB.Create(&auditLog)
+
+	// Browsers get the tokens in the fragment, which is never sent to a server
+	if ctrl.FrontendURL != "" {
+		fragment := url.Values{}
+		fragment.Set("token", tokens["token"].(string))
+		fragment.Set("refresh_token", tokens["refresh_token"].(string))
+		c.Redirect(http.StatusFound, ctrl.FrontendURL+"#"+fragment.Encode())
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// SetLocalLogin lets an admin allow or forbid password login for a user.
+func (ctrl *OIDCController) SetLocalLogin(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var user models.User
+	if err := ctrl.DB.First(&user, id).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var input struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user.LocalLoginDisabled = !*input.Enabled
+	if err := ctrl.DB.WithContext(c).Save(&user).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+var errSSOEmailTaken = errors.New("email belongs to an account not linked to single sign-on")
+
+// linkUser links subject to the signed-in user who started the flow with
+// Link. The account keeps its role and two-factor settings.
+func (ctrl *OIDCController) linkUser(c *gin.Context, userID uint, subject string) {
+	var existing int64
+	ctrl.DB.Unscoped().Model(&models.User{}).Where("oidc_subject = ?", subject).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "This single sign-on identity is already linked to an account"})
+		return
+	}
+
+	result := ctrl.DB.WithContext(c).Model(&models.User{}).
+		Where("id = ? AND oidc_subject IS NULL", userID).
+		Update("oidc_subject", subject)
+	if result.Error != nil {
+		utils.LogError("Failed to link SSO identity", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link single sign-on"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Your account is already linked to single sign-on"})
+		return
+	}
+
+	auditLog := models.AuditLog{
+		UserID:    &userID,
+		Action:    "sso_linked",
+		TableName: "users",
+		RecordID:  userID,
+		NewValue:  "Single sign-on identity linked: " + subject,
+		IPAddress: c.ClientIP(),
+	}
+	ctrl.DB.Create(&auditLog)
+
+	if ctrl.FrontendURL != "" {
+		c.Redirect(http.StatusFound, ctrl.FrontendURL+"#sso_linked=true")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Single sign-on linked"})
+}
+
+// provisionUser finds the user linked to subject or creates a new SSO-only
+// account. Existing accounts are never linked by email: that takes Link
+// from a signed-in session. The role of accounts created here is refreshed
+// from the IdP groups on every login; linked accounts keep their own.
+func (ctrl *OIDCController) provisionUser(c *gin.Context, subject string, claims jwt.MapClaims, role string) (models.User, error) {
+	var user models.User
+	email, _ := claims["email"].(string)
+	emailVerified, _ := claims["email_verified"].(bool)
+
+	err := ctrl.DB.Where("oidc_subject = ?", subject).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		if emailVerified && email != "" {
+			var taken int64
+			ctrl.DB.Unscoped().Model(&models.User{}).Where("LOWER(email) = ?", strings.ToLower(email)).Count(&taken)
+			if taken > 0 {
+				return user, errSSOEmailTaken
+			}
+		}
+
+		password, err := utils.GenerateRandomToken(32)
+		if err != nil {
+			return user, err
+		}
+		hashedPassword, err := utils.HashPassword(password)
+		if err != nil {
+			return user, err
+		}
+		user = models.User{
+			Username:           ctrl.uniqueUsername(claims, subject),
+			Password:           hashedPassword,
+			Role:               role,
+			OIDCSubject:        &subject,
+			LocalLoginDisabled: true,
+			OIDCProvisioned:    true,
+		}
+		if emailVerified {
+			user.Email = normalizeEmail(&email)
+		}
+		if err := ctrl.DB.WithContext(c).Create(&user).Error; err != nil {
+			return user, err
+		}
+		utils.LogInfo("SSO user provisioned", zap.Uint("user_id", user.ID), zap.String("role", role))
+		return user, nil
+	}
+	if err != nil {
+		return user, err
+	}
+
+	if !user.OIDCProvisioned || user.Role == role {
+		return user, nil
+	}
+	user.Role = role
+	if err := ctrl.DB.WithContext(c).Save(&user).Error; err != nil {
+		return user, err
+	}
+	if err := utils.RevokeUserTokens(c.Request.Context(), ctrl.DB.WithContext(c), user.ID); err != nil {
+		return user, err
+	}
+	if err := ctrl.DB.First(&user, user.ID).Error; err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+// mapRole picks the role for the user's groups; admin wins over other roles.
+func (ctrl *OIDCController) mapRole(groups []string) string {
+	role := ""
+	for _, group := range groups {
+		mapped, ok := ctrl.RoleMapping[group]
+		if !ok {
+			continue
+		}
+		if mapped == "admin" {
+			return mapped
+		}
+		if role == "" {
+			role = mapped
+		}
+	}
+	if role == "" {
+		return ctrl.DefaultRole
+	}
+	return role
+}
+
+func (ctrl *OIDCController) uniqueUsername(claims jwt.MapClaims, subject string) string {
+	base, _ := claims["preferred_username"].(string)
+	if base == "" {
+		base, _ = claims["email"].(string)
+	}
+	if base == "" {
+		base = "sso-" + subject
+	}
+
+	username := base
+	for i := 2; ; i++ {
+		var count int64
+		ctrl.DB.Unscoped().Model(&models.User{}).Where("username = ?", username).Count(&count)
+		if count == 0 {
+			return username
+		}
+		username = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// stringList accepts a claim given either as a JSON array or a single string.
+func stringList(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// amrIncludesMFA treats the IdP login as two-factor when the authentication
+// methods reference says so.
+func amrIncludesMFA(amr interface{}) bool {
+	for _, method := range stringList(amr) {
+		switch method {
+		case "mfa", "otp", "hwk", "swk", "sms":
+			return true
+		}
+	}
+	return false
+}
@@ -1,21 +1,34 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 	"gorm.io/gorm"
 	"warehouse-store/models"
 	"warehouse-store/utils"
 )
 
+const (
+	resetTokenTTL = 1 * time.Hour
+	// resetCodeMaxFailures wrong two-factor codes invalidate a reset token
+	resetCodeMaxFailures = 5
+)
+
 type PasswordResetController struct {
-	DB *gorm.DB
+	DB          *gorm.DB
+	ResetURL    string              // Frontend page that receives the token as ?token=
+	UserLimiter *utils.LoginLimiter // Shared with login, so codes guessed here count towards its lockout
+	IPLimiter   *utils.LoginLimiter
 }
 
-func NewPasswordResetController(db *gorm.DB) *PasswordResetController {
-	return &PasswordResetController{DB: db}
+func NewPasswordResetController(db *gorm.DB, resetURL string, userLimiter, ipLimiter *utils.LoginLimiter) *PasswordResetController {
+	return &PasswordResetController{DB: db, ResetURL: resetURL, UserLimiter: userLimiter, IPLimiter: ipLimiter}
 }
 
 func (ctrl *PasswordResetController) RequestReset(c *gin.Context) {
@@ -28,34 +41,56 @@ func (ctrl *PasswordResetController) RequestReset(c *gin.Context) {
 		return
 	}
 
+	// Same response whether or not the address exists, so accounts cannot be enumerated
+	response := gin.H{"message": "If the email exists, a reset link has been sent"}
+
 	var user models.User
-	if err := ctrl.DB.Where("username = ?", input.Email).First(&user).Error; err != nil {
-		c.JSON(http.StatusOK, gin.H{"message": "If the email exists, a reset link has been sent"})
+	if err := ctrl.DB.Where("LOWER(email) = ?", strings.ToLower(input.Email)).First(&user).Error; err != nil {
+		c.JSON(http.StatusOK, response)
 		return
 	}
 
-	token, err := utils.GenerateToken(user.ID, user.Username, user.Role)
+	// Accounts that sign in through single sign-on have no usable password
+	if user.LocalLoginDisabled {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	token, err := utils.GenerateRandomToken(32)
 	if err != nil {
 		utils.LogError("Failed", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate reset token"})
 		return
 	}
 
-	user.ResetToken = token
-	user.ResetTokenExpiry = time.Now().Add(1 * time.Hour)
-	if err := ctrl.DB.Save(&user).Error; err != nil {
+	user.ResetToken = utils.HashToken(token)
+	user.ResetTokenExpiry = time.Now().Add(resetTokenTTL)
+	user.ResetFailures = 0
+	if err := ctrl.DB.WithContext(c).Save(&user).Error; err != nil {
 		utils.LogError("Failed", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save reset token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "If the email exists, a reset link has been sent", "token": token})
+	err = utils.SendTemplateMail(*user.Email, "password_reset", gin.H{
+		"Username":  user.Username,
+		"ResetURL":  ctrl.ResetURL + "?token=" + url.QueryEscape(token),
+		"ExpiresIn": "1 hour",
+	})
+	if err != nil {
+		utils.LogError("Failed to send password reset email", err)
+	} else {
+		utils.LogInfo("Password reset email sent", zap.Uint("user_id", user.ID))
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 func (ctrl *PasswordResetController) ResetPassword(c *gin.Context) {
 	var input struct {
 		Token       string `json:"token" binding:"required"`
 		NewPassword string `json:"new_password" binding:"required,min=8"`
+		Code        string `json:"code"` // TOTP or recovery code, required when two-factor is enabled
 	}
 	if err := c.ShouldBindJSON(&input); err != nil {
 		utils.LogError("Failed", err)
@@ -63,22 +98,31 @@ func (ctrl *PasswordResetController) ResetPassword(c *gin.Context) {
 		return
 	}
 
-	claims, err := utils.ParseToken(input.Token)
-	if err != nil {
+	var user models.User
+	if err := ctrl.DB.Where("reset_token = ?", utils.HashToken(input.Token)).First(&user).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
 		return
 	}
 
-	var user models.User
-	if err := ctrl.DB.First(&user, claims.UserID).Error; err != nil {
-		utils.LogError("Failed", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User not found"})
+	if time.Now().After(user.ResetTokenExpiry) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
 		return
 	}
 
-	if user.ResetToken != input.Token || time.Now().After(user.ResetTokenExpiry) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired token"})
-		return
+	// A mailed link alone must not bypass two-factor authentication
+	ctx := c.Request.Context()
+	userKey := "user:" + strings.ToLower(user.Username)
+	ipKey := "ip:" + c.ClientIP()
+	if user.TOTPEnabled {
+		if wait := maxDuration(ctrl.UserLimiter.RetryAfter(ctx, userKey), ctrl.IPLimiter.RetryAfter(ctx, ipKey)); wait > 0 {
+			respondTooManyAttempts(c, wait)
+			return
+		}
+		if !verifyTOTPCode(ctrl.DB.WithContext(c), &user, input.Code) && !useRecoveryCode(ctrl.DB.WithContext(c), user.ID, input.Code) {
+			ctrl.registerCodeFailure(c, user, userKey, ipKey)
+			return
+		}
+		ctrl.UserLimiter.Reset(ctx, userKey)
 	}
 
 	hashedPassword, err := utils.HashPassword(input.NewPassword)
@@ -87,13 +131,50 @@ func (ctrl *PasswordResetController) ResetPassword(c *gin.Context) {
 		return
 	}
 
+	// Clearing the token makes it single-use
 	user.Password = hashedPassword
 	user.ResetToken = ""
-	if err := ctrl.DB.Save(&user).Error; err != nil {
+	user.ResetFailures = 0
+	if err := ctrl.DB.WithContext(c).Save(&user).Error; err != nil {
 		utils.LogError("Failed", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
 		return
 	}
 
+	if err := utils.RevokeUserTokens(c.Request.Context(), ctrl.DB.WithContext(c), user.ID); err != nil {
+		utils.LogError("Failed to revoke tokens after password reset", err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
 }
+
+// registerCodeFailure throttles a wrong two-factor code given with a reset
+// token like a failed login, and invalidates the token once
+// resetCodeMaxFailures wrong codes were given with it.
+func (ctrl *PasswordResetController) registerCodeFailure(c *gin.Context, user models.User, userKey, ipKey string) {
+	db := ctrl.DB.WithContext(c)
+	db.Create(&models.AuditLog{
+		Action:    "password_reset_mfa_failed",
+		TableName: "users",
+		RecordID:  user.ID,
+		NewValue:  fmt.Sprintf("Failed second factor on password reset for user ID: %d", user.ID),
+		IPAddress: c.ClientIP(),
+	})
+
+	if err := db.Model(&models.User{}).Where("id = ?", user.ID).
+		UpdateColumn("reset_failures", gorm.Expr("reset_failures + 1")).Error; err != nil {
+		utils.LogError("Failed", err)
+	}
+	if err := db.Model(&models.User{}).Where("id = ? AND reset_failures >= ?", user.ID, resetCodeMaxFailures).
+		UpdateColumn("reset_token", "").Error; err != nil {
+		utils.LogError("Failed", err)
+	}
+
+	ctx := c.Request.Context()
+	wait := maxDuration(ctrl.UserLimiter.RegisterFailure(ctx, userKey), ctrl.IPLimiter.RegisterFailure(ctx, ipKey))
+	if wait > 0 {
+		respondTooManyAttempts(c, wait)
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "A valid two-factor code is required", "mfa_required": true})
+}
@@ -26,7 +26,7 @@ func (ctrl *ProjectController) CreateProject(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := ctrl.DB.Create(&project).Error; err != nil {
+	if err := ctrl.DB.WithContext(c).Create(&project).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create project"})
 		return
 	}
@@ -34,8 +34,13 @@ func (ctrl *ProjectController) CreateProject(c *gin.Context) {
 }
 
 func (ctrl *ProjectController) GetProjects(c *gin.Context) {
+	query := ctrl.DB
+	if projectID, restricted := c.Get("apiKeyProjectID"); restricted {
+		query = query.Where("id = ?", projectID)
+	}
+
 	var projects []models.Project
-	if err := ctrl.DB.Find(&projects).Error; err != nil {
+	if err := query.Find(&projects).Error; err != nil {
 		utils.LogError("Failed", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch projects"})
 		return
@@ -45,6 +50,10 @@ func (ctrl *ProjectController) GetProjects(c *gin.Context) {
 
 func (ctrl *ProjectController) GetProjectByID(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
+	if !projectAllowed(c, uint(id)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: API key is restricted to another project"})
+		return
+	}
 	var project models.Project
 	if err := ctrl.DB.First(&project, id).Error; err != nil {
 		utils.LogError("Failed", err)
@@ -77,7 +86,7 @@ func (ctrl *ProjectController) UpdateProject(c *gin.Context) {
 	project.Number_of_Drone = input.Number_of_Drone
 	project.Location = input.Location
 
-	if err := ctrl.DB.Save(&project).Error; err != nil {
+	if err := ctrl.DB.WithContext(c).Save(&project).Error; err != nil {
 		utils.LogError("Failed", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update project"})
 		return
@@ -87,7 +96,7 @@ func (ctrl *ProjectController) UpdateProject(c *gin.Context) {
 
 func (ctrl *ProjectController) DeleteProject(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
-	if err := ctrl.DB.Delete(&models.Project{}, id).Error; err != nil {
+	if err := ctrl.DB.WithContext(c).Delete(&models.Project{}, id).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete project"})
 		return
 	}
@@ -98,9 +107,13 @@ func (ctrl *ProjectController) GetProjectsByMonth(c *gin.Context) {
 	month := c.Param("month") 
 	year := c.Param("year")   
 
+	query := ctrl.DB.Where("SUBSTRING(start_date, 4, 2) = ? AND SUBSTRING(start_date, 7, 4) = ?", month, year)
+	if projectID, restricted := c.Get("apiKeyProjectID"); restricted {
+		query = query.Where("id = ?", projectID)
+	}
+
 	var projects []models.Project
-	if err := ctrl.DB.Where("SUBSTRING(start_date, 4, 2) = ? AND SUBSTRING(start_date, 7, 4) = ?", month, year).
-		Find(&projects).Error; err != nil {
+	if err := query.Find(&projects).Error; err != nil {
 		utils.LogError("Failed to fetch projects by month", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch projects"})
 		return
@@ -0,0 +1,473 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+// Purchase orders that still expect deliveries.
+var openPurchaseOrderStates = []string{models.PurchaseOrderSent, models.PurchaseOrderPartiallyReceived}
+
+type PurchaseOrderController struct {
+	DB *gorm.DB
+}
+
+func NewPurchaseOrderController(db *gorm.DB) *PurchaseOrderController {
+	return &PurchaseOrderController{DB: db}
+}
+
+type PurchaseOrderLineInput struct {
+	ItemID    uint    `json:"item_id" binding:"required"`
+	Quantity  int     `json:"quantity" binding:"required,min=1"`
+	Unit      string  `json:"unit"`                       // Optional unit of quantity, e.g. "box"; lines are kept in the base unit
+	UnitPrice float64 `json:"unit_price" binding:"min=0"` // Price per unit given
+}
+
+type PurchaseOrderInput struct {
+	SupplierID   uint                     `json:"supplier_id" binding:"required"`
+	Reference    string                   `json:"reference"`
+	ExpectedDate string                   `json:"expected_date"`
+	Notes        string                   `json:"notes"`
+	Lines        []PurchaseOrderLineInput `json:"lines" binding:"required,min=1,dive"`
+}
+
+type GoodsReceiptLineInput struct {
+	LineID          uint     `json:"line_id" binding:"required"`
+	Quantity        int      `json:"quantity" binding:"required,min=1"`
+	Unit            string   `json:"unit"`          // Optional unit of quantity, e.g. "box"
+	Serials         []string `json:"serials"`       // One per base unit for serialized items; creates warranty records
+	TimeWarranty    string   `json:"time_warranty"` // Defaults to "12 months"
+	LotCode         string   `json:"lot_code"`
+	ManufactureDate string   `json:"manufacture_date"`
+	ExpiryDate      string   `json:"expiry_date"`
+	LocationID      *uint    `json:"location_id"` // Bin to put the units away in
+}
+
+type GoodsReceiptInput struct {
+	ReceivedDate string                  `json:"received_date" binding:"required"`
+	Note         string                  `json:"note"`
+	Lines        []GoodsReceiptLineInput `json:"lines" binding:"required,min=1,dive"`
+}
+
+func (ctrl *PurchaseOrderController) GetPurchaseOrders(c *gin.Context) {
+	query := ctrl.DB.Preload("Supplier").Preload("Lines.Item").Order("id desc")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if supplierID := c.Query("supplier_id"); supplierID != "" {
+		query = query.Where("supplier_id = ?", supplierID)
+	}
+	if itemID := c.Query("item_id"); itemID != "" {
+		query = query.Where("id IN (?)", ctrl.DB.Model(&models.PurchaseOrderLine{}).Select("purchase_order_id").Where("item_id = ?", itemID))
+	}
+
+	var orders []models.PurchaseOrder
+	if err := query.Find(&orders).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch purchase orders"})
+		return
+	}
+	c.JSON(http.StatusOK, orders)
+}
+
+func (ctrl *PurchaseOrderController) GetPurchaseOrderByID(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var order models.PurchaseOrder
+	if err := ctrl.DB.Preload("Supplier").Preload("Lines.Item").
+		Preload("Receipts.Lines").Preload("Receipts.User", auditActorColumns).
+		First(&order, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase order not found"})
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+func (ctrl *PurchaseOrderController) CreatePurchaseOrder(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var input PurchaseOrderInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	order := models.PurchaseOrder{
+		SupplierID:   input.SupplierID,
+		Status:       models.PurchaseOrderDraft,
+		Reference:    input.Reference,
+		ExpectedDate: input.ExpectedDate,
+		Notes:        input.Notes,
+		CreatedByID:  userID,
+	}
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := validatePurchaseOrder(tx, input); err != nil {
+			return err
+		}
+		if err := tx.Omit(clause.Associations).Create(&order).Error; err != nil {
+			return err
+		}
+		return createPurchaseOrderLines(tx, order.ID, input.Lines)
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to create purchase order")
+		return
+	}
+
+	ctrl.DB.Preload("Supplier").Preload("Lines.Item").First(&order, order.ID)
+	c.JSON(http.StatusCreated, order)
+}
+
+// UpdatePurchaseOrder replaces the details and lines of a draft order.
+func (ctrl *PurchaseOrderController) UpdatePurchaseOrder(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input PurchaseOrderInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order models.PurchaseOrder
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&order, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Purchase order not found"}
+		}
+		if order.Status != models.PurchaseOrderDraft {
+			return stockError{http.StatusConflict, "Only draft purchase orders can be changed"}
+		}
+		if err := validatePurchaseOrder(tx, input); err != nil {
+			return err
+		}
+
+		order.SupplierID = input.SupplierID
+		order.Reference = input.Reference
+		order.ExpectedDate = input.ExpectedDate
+		order.Notes = input.Notes
+		if err := tx.Omit(clause.Associations).Save(&order).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("purchase_order_id = ?", order.ID).Delete(&models.PurchaseOrderLine{}).Error; err != nil {
+			return err
+		}
+		return createPurchaseOrderLines(tx, order.ID, input.Lines)
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to update purchase order")
+		return
+	}
+
+	ctrl.DB.Preload("Supplier").Preload("Lines.Item").First(&order, order.ID)
+	c.JSON(http.StatusOK, order)
+}
+
+func (ctrl *PurchaseOrderController) DeletePurchaseOrder(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var order models.PurchaseOrder
+		if err := tx.First(&order, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Purchase order not found"}
+		}
+		if order.Status != models.PurchaseOrderDraft {
+			return stockError{http.StatusConflict, "Only draft purchase orders can be deleted; cancel it instead"}
+		}
+		if err := tx.Where("purchase_order_id = ?", order.ID).Delete(&models.PurchaseOrderLine{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&order).Error
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to delete purchase order")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Purchase order deleted successfully"})
+}
+
+// SendPurchaseOrder marks a draft as sent to the supplier; from then on
+// deliveries can be received against it.
+func (ctrl *PurchaseOrderController) SendPurchaseOrder(c *gin.Context) {
+	ctrl.changeState(c, []string{models.PurchaseOrderDraft}, models.PurchaseOrderSent, "Purchase order sent")
+}
+
+// CancelPurchaseOrder stops further deliveries. What was already received
+// stays in stock.
+func (ctrl *PurchaseOrderController) CancelPurchaseOrder(c *gin.Context) {
+	ctrl.changeState(c, []string{models.PurchaseOrderDraft, models.PurchaseOrderSent, models.PurchaseOrderPartiallyReceived}, models.PurchaseOrderCancelled, "Purchase order cancelled")
+}
+
+func (ctrl *PurchaseOrderController) changeState(c *gin.Context, from []string, to, message string) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var order models.PurchaseOrder
+	if err := ctrl.DB.First(&order, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase order not found"})
+		return
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"status": to}
+	if to == models.PurchaseOrderSent {
+		updates["sent_at"] = now
+	} else {
+		updates["closed_at"] = now
+	}
+	result := ctrl.DB.WithContext(c).Model(&order).Where("status IN ?", from).Updates(updates)
+	if result.Error != nil {
+		utils.LogError("Failed", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update purchase order"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Purchase order is %s", order.Status)})
+		return
+	}
+
+	ctrl.DB.Preload("Supplier").Preload("Lines.Item").First(&order, order.ID)
+	c.JSON(http.StatusOK, gin.H{"message": message, "purchase_order": order})
+}
+
+// ReceiveGoods books a delivery against a sent purchase order: stock goes
+// up, optionally into a lot and a bin, and received serial numbers get
+// warranty records.
+func (ctrl *PurchaseOrderController) ReceiveGoods(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input GoodsReceiptInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	receivedDate, err := time.Parse("2006-01-02", input.ReceivedDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "received_date must be a date like 2006-01-02"})
+		return
+	}
+
+	var receipt models.GoodsReceipt
+	var itemIDs []uint
+	err = ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var order models.PurchaseOrder
+		if err := tx.Scopes(forUpdate).Preload("Supplier").Preload("Lines.Item").First(&order, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Purchase order not found"}
+		}
+		if order.Status != models.PurchaseOrderSent && order.Status != models.PurchaseOrderPartiallyReceived {
+			return stockError{http.StatusConflict, fmt.Sprintf("Cannot receive goods on a %s purchase order", order.Status)}
+		}
+
+		lines := make(map[uint]*models.PurchaseOrderLine, len(order.Lines))
+		for i := range order.Lines {
+			lines[order.Lines[i].ID] = &order.Lines[i]
+		}
+
+		receipt = models.GoodsReceipt{PurchaseOrderID: &order.ID, UserID: userID, ReceivedDate: input.ReceivedDate, Note: input.Note}
+		if err := tx.Create(&receipt).Error; err != nil {
+			return err
+		}
+
+		for _, in := range input.Lines {
+			line, ok := lines[in.LineID]
+			if !ok {
+				return stockError{http.StatusBadRequest, fmt.Sprintf("Line %d is not on this purchase order", in.LineID)}
+			}
+			quantity, err := toBaseQuantity(tx, line.Item, in.Quantity, in.Unit)
+			if err != nil {
+				return err
+			}
+			if quantity > line.Outstanding() {
+				return stockError{http.StatusBadRequest, fmt.Sprintf("Only %d %s of %s are still outstanding", line.Outstanding(), line.Item.BaseUnit, line.Item.Name)}
+			}
+
+			manufactured, err := optionalDate(in.ManufactureDate, "manufacture_date")
+			if err != nil {
+				return stockError{http.StatusBadRequest, err.Error()}
+			}
+			expires, err := optionalDate(in.ExpiryDate, "expiry_date")
+			if err != nil {
+				return stockError{http.StatusBadRequest, err.Error()}
+			}
+			var unitCost *float64
+			if line.UnitPrice > 0 {
+				unitCost = &line.UnitPrice
+			}
+			lotID, warranties, err := receiveStock(tx, stockReceipt{
+				Item:            line.Item,
+				Quantity:        quantity,
+				Serials:         in.Serials,
+				BuyDate:         input.ReceivedDate,
+				TimeWarranty:    in.TimeWarranty,
+				LotCode:         in.LotCode,
+				ManufactureDate: manufactured,
+				ExpiryDate:      expires,
+				Supplier:        order.Supplier.Name,
+				ReceivedDate:    receivedDate,
+				LocationID:      in.LocationID,
+				UnitCost:        unitCost,
+			})
+			if err != nil {
+				return err
+			}
+
+			line.ReceivedQuantity += quantity
+			result := tx.Model(&models.PurchaseOrderLine{}).Where("id = ? AND received_quantity + ? <= quantity", line.ID, quantity).
+				Update("received_quantity", gorm.Expr("received_quantity + ?", quantity))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return stockError{http.StatusConflict, fmt.Sprintf("%s was received at the same time, try again", line.Item.Name)}
+			}
+			if err := tx.Create(&models.GoodsReceiptLine{
+				GoodsReceiptID:      receipt.ID,
+				PurchaseOrderLineID: &line.ID,
+				ItemID:              line.ItemID,
+				Quantity:            quantity,
+				LotID:               lotID,
+				LocationID:          in.LocationID,
+				SerialCount:         len(warranties),
+				UnitCost:            unitCost,
+			}).Error; err != nil {
+				return err
+			}
+			itemIDs = append(itemIDs, line.ItemID)
+		}
+
+		status := models.PurchaseOrderReceived
+		for _, line := range order.Lines {
+			if line.Outstanding() > 0 {
+				status = models.PurchaseOrderPartiallyReceived
+			}
+		}
+		updates := map[string]interface{}{"status": status}
+		if status == models.PurchaseOrderReceived {
+			updates["closed_at"] = time.Now()
+		}
+		return tx.Model(&models.PurchaseOrder{}).Where("id = ?", order.ID).Updates(updates).Error
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to receive goods")
+		return
+	}
+
+	for _, itemID := range itemIDs {
+		evaluateLowStock(ctrl.DB.WithContext(c), itemID)
+	}
+	ctrl.DB.Preload("Lines.Item").Preload("Lines.Lot").First(&receipt, receipt.ID)
+	c.JSON(http.StatusCreated, gin.H{"message": "Goods received successfully", "receipt": receipt})
+}
+
+// GetItemPurchaseOrders lists the open purchase order lines of an item.
+func (ctrl *PurchaseOrderController) GetItemPurchaseOrders(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var item models.Item
+	if err := ctrl.DB.First(&item, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
+	}
+
+	var lines []models.PurchaseOrderLine
+	if err := ctrl.DB.Joins("JOIN purchase_orders ON purchase_orders.id = purchase_order_lines.purchase_order_id AND purchase_orders.deleted_at IS NULL").
+		Where("purchase_order_lines.item_id = ? AND purchase_orders.status IN ? AND purchase_order_lines.received_quantity < purchase_order_lines.quantity", item.ID, openPurchaseOrderStates).
+		Order("purchase_order_lines.id").Find(&lines).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch purchase orders"})
+		return
+	}
+
+	var orderIDs []uint
+	for _, line := range lines {
+		orderIDs = append(orderIDs, line.PurchaseOrderID)
+	}
+	orders := make(map[uint]models.PurchaseOrder)
+	if len(orderIDs) > 0 {
+		var found []models.PurchaseOrder
+		ctrl.DB.Preload("Supplier").Where("id IN ?", orderIDs).Find(&found)
+		for _, order := range found {
+			orders[order.ID] = order
+		}
+	}
+
+	onOrder := 0
+	result := make([]gin.H, 0, len(lines))
+	for _, line := range lines {
+		order := orders[line.PurchaseOrderID]
+		onOrder += line.Outstanding()
+		result = append(result, gin.H{
+			"purchase_order_id": order.ID,
+			"supplier":          order.Supplier.Name,
+			"status":            order.Status,
+			"expected_date":     order.ExpectedDate,
+			"line_id":           line.ID,
+			"quantity":          line.Quantity,
+			"received":          line.ReceivedQuantity,
+			"outstanding":       line.Outstanding(),
+			"unit_price":        line.UnitPrice,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"item_id": item.ID, "on_order": onOrder, "lines": result})
+}
+
+func validatePurchaseOrder(tx *gorm.DB, input PurchaseOrderInput) error {
+	var supplier models.Supplier
+	if err := tx.First(&supplier, input.SupplierID).Error; err != nil {
+		return stockError{http.StatusBadRequest, "Invalid supplier ID provided"}
+	}
+	for _, line := range input.Lines {
+		var item models.Item
+		if err := tx.First(&item, line.ItemID).Error; err != nil {
+			return stockError{http.StatusBadRequest, fmt.Sprintf("Item %d not found", line.ItemID)}
+		}
+	}
+	return nil
+}
+
+// createPurchaseOrderLines stores the lines of an order in the base unit of
+// each item. Call it after validatePurchaseOrder.
+func createPurchaseOrderLines(tx *gorm.DB, orderID uint, inputs []PurchaseOrderLineInput) error {
+	lines := make([]models.PurchaseOrderLine, 0, len(inputs))
+	for _, in := range inputs {
+		var item models.Item
+		if err := tx.First(&item, in.ItemID).Error; err != nil {
+			return err
+		}
+		quantity, err := toBaseQuantity(tx, item, in.Quantity, in.Unit)
+		if err != nil {
+			return err
+		}
+		unitPrice := in.UnitPrice * float64(in.Quantity) / float64(quantity)
+		lines = append(lines, models.PurchaseOrderLine{PurchaseOrderID: orderID, ItemID: in.ItemID, Quantity: quantity, UnitPrice: unitPrice})
+	}
+	return tx.Create(&lines).Error
+}
+
+// onOrderQuantities returns how much of each item is still expected on open
+// purchase orders.
+func onOrderQuantities(db *gorm.DB, itemIDs []uint) map[uint]int {
+	type onOrder struct {
+		ItemID   uint
+		Quantity int
+	}
+	var rows []onOrder
+	result := make(map[uint]int)
+	if len(itemIDs) == 0 {
+		return result
+	}
+	if err := db.Model(&models.PurchaseOrderLine{}).
+		Select("purchase_order_lines.item_id, SUM(purchase_order_lines.quantity - purchase_order_lines.received_quantity) AS quantity").
+		Joins("JOIN purchase_orders ON purchase_orders.id = purchase_order_lines.purchase_order_id AND purchase_orders.deleted_at IS NULL").
+		Where("purchase_order_lines.item_id IN ? AND purchase_orders.status IN ?", itemIDs, openPurchaseOrderStates).
+		Group("purchase_order_lines.item_id").Scan(&rows).Error; err != nil {
+		utils.LogError("Failed", err)
+		return result
+	}
+	for _, row := range rows {
+		result[row.ItemID] = row.Quantity
+	}
+	return result
+}
@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+// Key of the Postgres advisory lock that serializes new serial numbers.
+const serialRegistrationLockKey = 0x73657269
+
+type ReceivingController struct {
+	DB *gorm.DB
+}
+
+func NewReceivingController(db *gorm.DB) *ReceivingController {
+	return &ReceivingController{DB: db}
+}
+
+// ReceivingInput is a delivery received without a purchase order, e.g. new
+// drones scanned in at the dock.
+type ReceivingInput struct {
+	ItemID          uint     `json:"item_id" binding:"required"`
+	Quantity        int      `json:"quantity" binding:"required,min=1"`
+	Unit            string   `json:"unit"`    // Optional unit of quantity, e.g. "box"; stock is kept in the base unit
+	Serials         []string `json:"serials"` // Scanned serial numbers, one per base unit
+	Lot             string   `json:"lot"`
+	BuyDate         string   `json:"buy_date" binding:"required"`
+	TimeWarranty    string   `json:"time_warranty"` // Warranty duration, defaults to "12 months"
+	ManufactureDate string   `json:"manufacture_date"`
+	ExpiryDate      string   `json:"expiry_date"`
+	LocationID      *uint    `json:"location_id"` // Bin to put the units away in
+	Supplier        string   `json:"supplier"`
+	UnitCost        *float64 `json:"unit_cost" binding:"omitempty,min=0"` // Cost per unit given, for the average cost
+	Note            string   `json:"note"`
+}
+
+// ReceiveStock books a delivery in one transaction: stock goes up and every
+// scanned serial gets its warranty record. Nothing is stored when a serial
+// is repeated or already known.
+func (ctrl *ReceivingController) ReceiveStock(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var input ReceivingInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	manufactured, err := optionalDate(input.ManufactureDate, "manufacture_date")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	expires, err := optionalDate(input.ExpiryDate, "expiry_date")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	var receipt models.GoodsReceipt
+	var warranties []models.Warranty
+	err = ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var item models.Item
+		if err := tx.Scopes(forUpdate).First(&item, input.ItemID).Error; err != nil {
+			return stockError{http.StatusNotFound, "Item not found"}
+		}
+		quantity, err := toBaseQuantity(tx, item, input.Quantity, input.Unit)
+		if err != nil {
+			return err
+		}
+		var unitCost *float64
+		if input.UnitCost != nil {
+			cost := *input.UnitCost * float64(input.Quantity) / float64(quantity)
+			unitCost = &cost
+		}
+
+		lotID, created, err := receiveStock(tx, stockReceipt{
+			Item:            item,
+			Quantity:        quantity,
+			Serials:         input.Serials,
+			BuyDate:         input.BuyDate,
+			TimeWarranty:    input.TimeWarranty,
+			LotCode:         input.Lot,
+			ManufactureDate: manufactured,
+			ExpiryDate:      expires,
+			Supplier:        input.Supplier,
+			ReceivedDate:    now.UTC().Truncate(24 * time.Hour),
+			LocationID:      input.LocationID,
+			UnitCost:        unitCost,
+		})
+		if err != nil {
+			return err
+		}
+		warranties = created
+
+		receipt = models.GoodsReceipt{
+			UserID:       userID,
+			ReceivedDate: now.Format("2006-01-02"),
+			Note:         input.Note,
+			Lines: []models.GoodsReceiptLine{{
+				ItemID:      item.ID,
+				Quantity:    quantity,
+				LotID:       lotID,
+				LocationID:  input.LocationID,
+				SerialCount: len(created),
+				UnitCost:    unitCost,
+			}},
+		}
+		return tx.Create(&receipt).Error
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to receive stock")
+		return
+	}
+
+	evaluateLowStock(ctrl.DB.WithContext(c), input.ItemID)
+	c.JSON(http.StatusCreated, gin.H{"message": "Stock received successfully", "receipt": receipt, "warranties": warranties})
+}
+
+func (ctrl *ReceivingController) GetReceipts(c *gin.Context) {
+	query := ctrl.DB.Preload("Lines.Item").Preload("Lines.Lot").Preload("User", auditActorColumns).Order("id desc")
+	if orderID := c.Query("purchase_order_id"); orderID != "" {
+		query = query.Where("purchase_order_id = ?", orderID)
+	}
+	if itemID := c.Query("item_id"); itemID != "" {
+		query = query.Where("id IN (?)", ctrl.DB.Model(&models.GoodsReceiptLine{}).Select("goods_receipt_id").Where("item_id = ?", itemID))
+	}
+
+	var receipts []models.GoodsReceipt
+	if err := query.Find(&receipts).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch receipts"})
+		return
+	}
+	c.JSON(http.StatusOK, receipts)
+}
+
+func (ctrl *ReceivingController) GetReceiptByID(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var receipt models.GoodsReceipt
+	if err := ctrl.DB.Preload("Lines.Item").Preload("Lines.Lot").Preload("User", auditActorColumns).First(&receipt, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receipt not found"})
+		return
+	}
+	c.JSON(http.StatusOK, receipt)
+}
+
+// stockReceipt is stock arriving in the warehouse.
+type stockReceipt struct {
+	Item            models.Item
+	Quantity        int
+	Serials         []string // Empty for items without serial numbers
+	BuyDate         string
+	TimeWarranty    string
+	LotCode         string // Books the units into this lot, created if needed
+	ManufactureDate *time.Time
+	ExpiryDate      *time.Time
+	Supplier        string
+	ReceivedDate    time.Time
+	LocationID      *uint
+	UnitCost        *float64 // Per base unit; nil leaves the average cost as it is
+}
+
+// receiveStock raises the stock of an item, folds the receipt into its
+// average cost and books the units into their lot and bin. Serial numbers
+// get warranty records and must not exist yet.
+func receiveStock(tx *gorm.DB, r stockReceipt) (*uint, []models.Warranty, error) {
+	if err := checkNotCounting(tx, r.Item.ID); err != nil {
+		return nil, nil, err
+	}
+	if err := checkNewSerials(tx, r); err != nil {
+		return nil, nil, err
+	}
+
+	updates := map[string]interface{}{"quantity": gorm.Expr("quantity + ?", r.Quantity)}
+	if r.UnitCost != nil {
+		updates["unit_cost"] = averageCostExpr(r.Quantity, *r.UnitCost)
+	}
+	if err := tx.Model(&models.Item{}).Where("id = ?", r.Item.ID).Updates(updates).Error; err != nil {
+		return nil, nil, err
+	}
+	if r.LocationID != nil {
+		if err := putAwayStock(tx, r.Item.ID, *r.LocationID, r.Quantity); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var lotID *uint
+	if r.LotCode != "" {
+		var lot models.Lot
+		err := tx.Scopes(forUpdate).Where("item_id = ? AND code = ?", r.Item.ID, r.LotCode).First(&lot).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, err
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			lot = models.Lot{
+				ItemID:          r.Item.ID,
+				Code:            r.LotCode,
+				ReceivedDate:    r.ReceivedDate,
+				ManufactureDate: r.ManufactureDate,
+				ExpiryDate:      r.ExpiryDate,
+				Supplier:        r.Supplier,
+			}
+		}
+		lot.ReceivedQuantity += r.Quantity
+		lot.Quantity += r.Quantity
+		if err := tx.Save(&lot).Error; err != nil {
+			return nil, nil, err
+		}
+		lotID = &lot.ID
+	}
+
+	if len(r.Serials) == 0 {
+		return lotID, nil, nil
+	}
+	timeWarranty := r.TimeWarranty
+	if timeWarranty == "" {
+		timeWarranty = "12 months"
+	}
+	warranties := make([]models.Warranty, 0, len(r.Serials))
+	for _, serial := range r.Serials {
+		warranties = append(warranties, models.Warranty{
+			DroneID:      r.Item.ID,
+			SerialNumber: strings.TrimSpace(serial),
+			BuyDate:      r.BuyDate,
+			TimeWarranty: timeWarranty,
+			Status:       "active",
+			Lot:          r.LotCode,
+			// Serialized units are capital assets depreciated from receipt
+			AcquisitionDate: &r.ReceivedDate,
+			AcquisitionCost: r.UnitCost,
+		})
+	}
+	if err := tx.Create(&warranties).Error; err != nil {
+		return nil, nil, err
+	}
+	return lotID, warranties, nil
+}
+
+// checkNewSerials rejects a receipt whose serial numbers do not match its
+// quantity, repeat or already have a warranty, or a serialized item
+// received without them. On Postgres the check holds
+// a transaction-scoped lock, so two receipts cannot both register a serial.
+func checkNewSerials(tx *gorm.DB, r stockReceipt) error {
+	if len(r.Serials) == 0 {
+		if r.Item.Type == models.ItemSerialized {
+			return stockError{http.StatusBadRequest, fmt.Sprintf("%s is serialized; scan a serial number for every unit", r.Item.Name)}
+		}
+		return nil
+	}
+	if len(r.Serials) != r.Quantity {
+		return stockError{http.StatusBadRequest, fmt.Sprintf("%d serial numbers given for %d units of %s", len(r.Serials), r.Quantity, r.Item.Name)}
+	}
+
+	serials := make([]string, 0, len(r.Serials))
+	seen := make(map[string]bool, len(r.Serials))
+	for _, serial := range r.Serials {
+		serial = strings.TrimSpace(serial)
+		if serial == "" {
+			return stockError{http.StatusBadRequest, "Serial numbers cannot be empty"}
+		}
+		if seen[serial] {
+			return stockError{http.StatusBadRequest, fmt.Sprintf("Serial number %s is listed twice", serial)}
+		}
+		seen[serial] = true
+		serials = append(serials, serial)
+	}
+
+	if tx.Dialector.Name() == "postgres" {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", serialRegistrationLockKey).Error; err != nil {
+			return err
+		}
+	}
+	var existing []string
+	if err := tx.Model(&models.Warranty{}).Where("serial_number IN ?", serials).Pluck("serial_number", &existing).Error; err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return stockError{http.StatusConflict, fmt.Sprintf("Warranty already exists for serial numbers %s", strings.Join(existing, ", "))}
+	}
+	return nil
+}
@@ -0,0 +1,564 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+type StocktakeController struct {
+	DB *gorm.DB
+}
+
+func NewStocktakeController(db *gorm.DB) *StocktakeController {
+	return &StocktakeController{DB: db}
+}
+
+type StocktakeInput struct {
+	Name       string `json:"name" binding:"required"`
+	Scope      string `json:"scope" binding:"required"` // "full", "category" or "location"
+	CategoryID *uint  `json:"category_id"`
+	LocationID *uint  `json:"location_id"`
+	Note       string `json:"note"`
+}
+
+type StocktakeCountInput struct {
+	ItemID     uint   `json:"item_id" binding:"required"`
+	LocationID *uint  `json:"location_id"` // Bin counted, for location stocktakes
+	Quantity   *int   `json:"quantity" binding:"required,min=0"`
+	Note       string `json:"note"`
+}
+
+type StocktakeCountsInput struct {
+	Counts []StocktakeCountInput `json:"counts" binding:"required,min=1,dive"`
+}
+
+type StocktakeReasonInput struct {
+	LineID uint   `json:"line_id" binding:"required"`
+	Reason string `json:"reason" binding:"required"`
+}
+
+type StocktakeApprovalInput struct {
+	Reason string                 `json:"reason"` // For variances without their own reason
+	Lines  []StocktakeReasonInput `json:"lines" binding:"dive"`
+}
+
+// StocktakeVariance compares the count of a line with the system quantity.
+// Counted and Variance are nil until someone counted the line.
+type StocktakeVariance struct {
+	LineID         uint             `json:"line_id"`
+	Item           models.Item      `json:"item"`
+	Location       *models.Location `json:"location,omitempty"`
+	SystemQuantity int              `json:"system_quantity"`
+	Counted        *int             `json:"counted"`
+	Variance       *int             `json:"variance"`
+	CountedBy      []string         `json:"counted_by"`
+}
+
+func (ctrl *StocktakeController) GetStocktakes(c *gin.Context) {
+	query := ctrl.DB.Preload("Category").Preload("Location").Preload("CreatedBy", auditActorColumns).Order("id desc")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var stocktakes []models.Stocktake
+	if err := query.Find(&stocktakes).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stocktakes"})
+		return
+	}
+	c.JSON(http.StatusOK, stocktakes)
+}
+
+func (ctrl *StocktakeController) GetStocktakeByID(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var stocktake models.Stocktake
+	err := ctrl.DB.Preload("Category").Preload("Location").
+		Preload("CreatedBy", auditActorColumns).Preload("ApprovedBy", auditActorColumns).
+		Preload("Lines.Item").Preload("Lines.Location").Preload("Lines.Counts.User", auditActorColumns).
+		First(&stocktake, id).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stocktake not found"})
+		return
+	}
+	c.JSON(http.StatusOK, stocktake)
+}
+
+// CreateStocktake starts a count. The items in scope are listed with their
+// current quantity and cannot be borrowed until the stocktake is approved
+// or cancelled.
+func (ctrl *StocktakeController) CreateStocktake(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var input StocktakeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stocktake := models.Stocktake{
+		Name:        input.Name,
+		Scope:       input.Scope,
+		Status:      models.StocktakeCounting,
+		Note:        input.Note,
+		CreatedByID: userID,
+	}
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		lines, err := stocktakeLines(tx, input)
+		if err != nil {
+			return err
+		}
+		if len(lines) == 0 {
+			return stockError{http.StatusBadRequest, "Nothing to count in this scope"}
+		}
+
+		itemIDs := make([]uint, 0, len(lines))
+		for _, line := range lines {
+			itemIDs = append(itemIDs, line.ItemID)
+		}
+		if err := checkNotCounting(tx, itemIDs...); err != nil {
+			return err
+		}
+
+		switch input.Scope {
+		case models.StocktakeCategory:
+			stocktake.CategoryID = input.CategoryID
+		case models.StocktakeLocation:
+			stocktake.LocationID = input.LocationID
+		}
+		if err := tx.Create(&stocktake).Error; err != nil {
+			return err
+		}
+		for i := range lines {
+			lines[i].StocktakeID = stocktake.ID
+		}
+		return tx.CreateInBatches(&lines, 200).Error
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to create stocktake")
+		return
+	}
+
+	ctrl.DB.Preload("Lines.Item").Preload("Lines.Location").First(&stocktake, stocktake.ID)
+	c.JSON(http.StatusCreated, stocktake)
+}
+
+// RecordCounts stores what the current user counted. Counting a line again
+// replaces the user's earlier count; counts of other users are kept and
+// added up.
+func (ctrl *StocktakeController) RecordCounts(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input StocktakeCountsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var counts []models.StocktakeCount
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var stocktake models.Stocktake
+		if err := tx.First(&stocktake, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Stocktake not found"}
+		}
+		if stocktake.Status != models.StocktakeCounting {
+			return stockError{http.StatusConflict, fmt.Sprintf("Stocktake is %s", stocktake.Status)}
+		}
+
+		for _, in := range input.Counts {
+			query := tx.Where("stocktake_id = ? AND item_id = ?", stocktake.ID, in.ItemID)
+			if in.LocationID != nil {
+				query = query.Where("location_id = ?", *in.LocationID)
+			} else {
+				query = query.Where("location_id IS NULL")
+			}
+			var line models.StocktakeLine
+			if err := query.First(&line).Error; err != nil {
+				return stockError{http.StatusBadRequest, fmt.Sprintf("Item %d is not part of this stocktake%s", in.ItemID, locationSuffix(in.LocationID))}
+			}
+
+			var count models.StocktakeCount
+			if err := tx.Where("line_id = ? AND user_id = ?", line.ID, userID).FirstOrInit(&count).Error; err != nil {
+				return err
+			}
+			count.LineID = line.ID
+			count.UserID = userID
+			count.Quantity = *in.Quantity
+			count.Note = in.Note
+			if err := tx.Save(&count).Error; err != nil {
+				return err
+			}
+			counts = append(counts, count)
+		}
+		return nil
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to record counts")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Counts recorded successfully", "counts": counts})
+}
+
+// GetVariance reports counted against system quantity for every line.
+func (ctrl *StocktakeController) GetVariance(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var stocktake models.Stocktake
+	if err := ctrl.DB.First(&stocktake, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stocktake not found"})
+		return
+	}
+
+	variances, err := stocktakeVariances(ctrl.DB, stocktake.ID)
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build variance report"})
+		return
+	}
+	if c.Query("only_differences") == "true" {
+		differences := make([]StocktakeVariance, 0)
+		for _, v := range variances {
+			if v.Variance != nil && *v.Variance != 0 {
+				differences = append(differences, v)
+			}
+		}
+		variances = differences
+	}
+
+	counted, surplus, shortage := 0, 0, 0
+	for _, v := range variances {
+		if v.Variance == nil {
+			continue
+		}
+		counted++
+		if *v.Variance > 0 {
+			surplus += *v.Variance
+		} else {
+			shortage -= *v.Variance
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"stocktake":     stocktake,
+		"lines":         variances,
+		"counted_lines": counted,
+		"surplus":       surplus,
+		"shortage":      shortage,
+	})
+}
+
+// ApproveStocktake posts the variance of every counted line as a stock
+// adjustment and releases the items for borrowing. Every variance needs a
+// reason. Lines nobody counted are left as they are.
+func (ctrl *StocktakeController) ApproveStocktake(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input StocktakeApprovalInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	reasons := make(map[uint]string, len(input.Lines))
+	for _, line := range input.Lines {
+		reasons[line.LineID] = strings.TrimSpace(line.Reason)
+	}
+
+	var adjustments []models.StockAdjustment
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		// Only one request can close the count
+		now := time.Now()
+		result := tx.Model(&models.Stocktake{}).Where("id = ? AND status = ?", id, models.StocktakeCounting).
+			Updates(map[string]interface{}{"status": models.StocktakeApproved, "approved_by_id": userID, "approved_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return stockError{http.StatusConflict, "Stocktake is not being counted"}
+		}
+
+		variances, err := stocktakeVariances(tx, uint(id))
+		if err != nil {
+			return err
+		}
+		stocktakeID := uint(id)
+		for _, v := range variances {
+			if v.Counted == nil {
+				continue
+			}
+			var locationID *uint
+			if v.Location != nil {
+				locationID = &v.Location.ID
+			}
+			// Stock is frozen while counting, but adjust from what is there now
+			// so nothing moved since the count started is booked twice
+			current, err := countedStock(tx, v.Item.ID, locationID)
+			if err != nil {
+				return err
+			}
+			variance := *v.Counted - current
+			if variance == 0 {
+				continue
+			}
+			reason := reasons[v.LineID]
+			if reason == "" {
+				reason = strings.TrimSpace(input.Reason)
+			}
+			if reason == "" {
+				return stockError{http.StatusBadRequest, fmt.Sprintf("A reason is required for the variance of %d on %s", variance, v.Item.Name)}
+			}
+
+			adjustment := models.StockAdjustment{
+				ItemID:      v.Item.ID,
+				LocationID:  locationID,
+				Quantity:    variance,
+				Reason:      reason,
+				StocktakeID: &stocktakeID,
+				UserID:      userID,
+			}
+			if err := adjustStock(tx, &adjustment); err != nil {
+				return err
+			}
+			adjustments = append(adjustments, adjustment)
+		}
+		return nil
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to approve stocktake")
+		return
+	}
+
+	for _, adjustment := range adjustments {
+		evaluateLowStock(ctrl.DB.WithContext(c), adjustment.ItemID)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Stocktake approved successfully", "adjustments": adjustments})
+}
+
+// CancelStocktake drops a count without changing stock.
+func (ctrl *StocktakeController) CancelStocktake(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	result := ctrl.DB.WithContext(c).Model(&models.Stocktake{}).
+		Where("id = ? AND status = ?", id, models.StocktakeCounting).Update("status", models.StocktakeCancelled)
+	if result.Error != nil {
+		utils.LogError("Failed", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel stocktake"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Stocktake is not being counted"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Stocktake cancelled successfully"})
+}
+
+func (ctrl *StocktakeController) GetStockAdjustments(c *gin.Context) {
+	query := ctrl.DB.Preload("Item").Preload("Location").Preload("User", auditActorColumns).Order("id desc")
+	if itemID := c.Query("item_id"); itemID != "" {
+		query = query.Where("item_id = ?", itemID)
+	}
+	if stocktakeID := c.Query("stocktake_id"); stocktakeID != "" {
+		query = query.Where("stocktake_id = ?", stocktakeID)
+	}
+
+	var adjustments []models.StockAdjustment
+	if err := query.Find(&adjustments).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock adjustments"})
+		return
+	}
+	c.JSON(http.StatusOK, adjustments)
+}
+
+// stocktakeLines lists what a new stocktake counts, with current quantities.
+func stocktakeLines(tx *gorm.DB, input StocktakeInput) ([]models.StocktakeLine, error) {
+	var lines []models.StocktakeLine
+	switch input.Scope {
+	case models.StocktakeFull, models.StocktakeCategory:
+		query := tx.Model(&models.Item{}).Order("id")
+		if input.Scope == models.StocktakeCategory {
+			if input.CategoryID == nil {
+				return nil, stockError{http.StatusBadRequest, "category_id is required for a category stocktake"}
+			}
+			var category models.Category
+			if err := tx.First(&category, *input.CategoryID).Error; err != nil {
+				return nil, stockError{http.StatusBadRequest, "Invalid Category ID provided"}
+			}
+			query = query.Where("category_id = ?", category.ID)
+		}
+		var items []models.Item
+		if err := query.Find(&items).Error; err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			lines = append(lines, models.StocktakeLine{ItemID: item.ID, SystemQuantity: item.Quantity})
+		}
+
+	case models.StocktakeLocation:
+		if input.LocationID == nil {
+			return nil, stockError{http.StatusBadRequest, "location_id is required for a location stocktake"}
+		}
+		bins, err := binsUnder(tx, *input.LocationID)
+		if err != nil {
+			return nil, err
+		}
+		var stocks []models.ItemLocation
+		if err := tx.Where("location_id IN ? AND quantity > 0", bins).Order("location_id, item_id").Find(&stocks).Error; err != nil {
+			return nil, err
+		}
+		for _, stock := range stocks {
+			locationID := stock.LocationID
+			lines = append(lines, models.StocktakeLine{ItemID: stock.ItemID, LocationID: &locationID, SystemQuantity: stock.Quantity})
+		}
+
+	default:
+		return nil, stockError{http.StatusBadRequest, "Scope must be full, category or location"}
+	}
+	return lines, nil
+}
+
+// binsUnder returns the bins in a location: the location itself when it is
+// a bin, otherwise the bins of its zones.
+func binsUnder(tx *gorm.DB, locationID uint) ([]uint, error) {
+	var location models.Location
+	if err := tx.First(&location, locationID).Error; err != nil {
+		return nil, stockError{http.StatusBadRequest, "Invalid location ID provided"}
+	}
+
+	ids := []uint{location.ID}
+	for location.Type != models.LocationBin {
+		var children []uint
+		if err := tx.Model(&models.Location{}).Where("parent_id IN ?", ids).Pluck("id", &children).Error; err != nil {
+			return nil, err
+		}
+		if len(children) == 0 {
+			return children, nil
+		}
+		ids = children
+		if location.Type == models.LocationWarehouse {
+			location.Type = models.LocationZone
+		} else {
+			location.Type = models.LocationBin
+		}
+	}
+	return ids, nil
+}
+
+func stocktakeVariances(db *gorm.DB, stocktakeID uint) ([]StocktakeVariance, error) {
+	var lines []models.StocktakeLine
+	err := db.Preload("Item").Preload("Location").Preload("Counts.User", auditActorColumns).
+		Where("stocktake_id = ?", stocktakeID).Order("id").Find(&lines).Error
+	if err != nil {
+		return nil, err
+	}
+
+	variances := make([]StocktakeVariance, 0, len(lines))
+	for _, line := range lines {
+		v := StocktakeVariance{
+			LineID:         line.ID,
+			Item:           line.Item,
+			Location:       line.Location,
+			SystemQuantity: line.SystemQuantity,
+			CountedBy:      []string{},
+		}
+		if len(line.Counts) > 0 {
+			counted := 0
+			for _, count := range line.Counts {
+				counted += count.Quantity
+				v.CountedBy = append(v.CountedBy, count.User.Username)
+			}
+			variance := counted - line.SystemQuantity
+			v.Counted, v.Variance = &counted, &variance
+		}
+		variances = append(variances, v)
+	}
+	return variances, nil
+}
+
+// adjustStock applies a stock adjustment and records it. Found stock is
+// added to the bin of the adjustment, if any. Losses come out of that bin,
+// or else unlocated stock first, and out of the newest lots.
+func adjustStock(tx *gorm.DB, adjustment *models.StockAdjustment) error {
+	var item models.Item
+	if err := tx.Scopes(forUpdate).First(&item, adjustment.ItemID).Error; err != nil {
+		return stockError{http.StatusBadRequest, fmt.Sprintf("Item %d no longer exists", adjustment.ItemID)}
+	}
+
+	if adjustment.Quantity > 0 {
+		if adjustment.LocationID != nil {
+			if err := putAwayStock(tx, item.ID, *adjustment.LocationID, adjustment.Quantity); err != nil {
+				return err
+			}
+		}
+	} else {
+		loss := -adjustment.Quantity
+		if item.Quantity < loss {
+			return stockError{http.StatusConflict, fmt.Sprintf("Cannot write off %d %s, only %d in stock", loss, item.Name, item.Quantity)}
+		}
+		if err := pickStock(tx, item, loss, adjustment.LocationID); err != nil {
+			return err
+		}
+		if err := trimLots(tx, item.ID, item.Quantity-loss); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Model(&models.Item{}).Where("id = ?", item.ID).
+		Update("quantity", gorm.Expr("quantity + ?", adjustment.Quantity)).Error; err != nil {
+		return err
+	}
+	return tx.Create(adjustment).Error
+}
+
+// countedStock is the current quantity a stocktake line was counted
+// against: the bin quantity for a location stocktake, otherwise the item's.
+func countedStock(tx *gorm.DB, itemID uint, locationID *uint) (int, error) {
+	// The item is locked before its bins, in the same order as stock movements
+	var item models.Item
+	if err := tx.Scopes(forUpdate).First(&item, itemID).Error; err != nil {
+		return 0, stockError{http.StatusBadRequest, fmt.Sprintf("Item %d no longer exists", itemID)}
+	}
+	if locationID == nil {
+		return item.Quantity, nil
+	}
+
+	var stock models.ItemLocation
+	err := tx.Scopes(forUpdate).Where("item_id = ? AND location_id = ?", itemID, *locationID).First(&stock).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return stock.Quantity, err
+}
+
+// checkNotCounting fails while any of the items is in a stocktake that is
+// being counted.
+func checkNotCounting(tx *gorm.DB, itemIDs ...uint) error {
+	if len(itemIDs) == 0 {
+		return nil
+	}
+	var line models.StocktakeLine
+	err := tx.Preload("Item").
+		Joins("JOIN stocktakes ON stocktakes.id = stocktake_lines.stocktake_id AND stocktakes.deleted_at IS NULL").
+		Where("stocktakes.status = ? AND stocktake_lines.item_id IN ?", models.StocktakeCounting, itemIDs).
+		First(&line).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return stockError{http.StatusConflict, fmt.Sprintf("%s is frozen by stocktake %d until it is approved", line.Item.Name, line.StocktakeID)}
+}
+
+func locationSuffix(locationID *uint) string {
+	if locationID == nil {
+		return ""
+	}
+	return fmt.Sprintf(" in location %d", *locationID)
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+type SupplierController struct {
+	DB *gorm.DB
+}
+
+func NewSupplierController(db *gorm.DB) *SupplierController {
+	return &SupplierController{DB: db}
+}
+
+func (ctrl *SupplierController) CreateSupplier(c *gin.Context) {
+	var supplier models.Supplier
+	if err := c.ShouldBindJSON(&supplier); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if supplier.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+
+	if err := ctrl.DB.WithContext(c).Create(&supplier).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create supplier"})
+		return
+	}
+	c.JSON(http.StatusCreated, supplier)
+}
+
+func (ctrl *SupplierController) GetSuppliers(c *gin.Context) {
+	query := ctrl.DB.Order("name")
+	if search := c.Query("search"); search != "" {
+		query = query.Where("LOWER(name) LIKE ?", "%"+strings.ToLower(search)+"%")
+	}
+
+	var suppliers []models.Supplier
+	if err := query.Find(&suppliers).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suppliers"})
+		return
+	}
+	c.JSON(http.StatusOK, suppliers)
+}
+
+func (ctrl *SupplierController) GetSupplierByID(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var supplier models.Supplier
+	if err := ctrl.DB.First(&supplier, id).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Supplier not found"})
+		return
+	}
+	c.JSON(http.StatusOK, supplier)
+}
+
+func (ctrl *SupplierController) UpdateSupplier(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var supplier models.Supplier
+	if err := ctrl.DB.First(&supplier, id).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Supplier not found"})
+		return
+	}
+
+	var input models.Supplier
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+
+	supplier.Name = input.Name
+	supplier.ContactName = input.ContactName
+	supplier.Email = input.Email
+	supplier.Phone = input.Phone
+	supplier.Address = input.Address
+	supplier.Notes = input.Notes
+
+	if err := ctrl.DB.WithContext(c).Save(&supplier).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update supplier"})
+		return
+	}
+	c.JSON(http.StatusOK, supplier)
+}
+
+func (ctrl *SupplierController) DeleteSupplier(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var open int64
+	ctrl.DB.Model(&models.PurchaseOrder{}).
+		Where("supplier_id = ? AND status IN ?", id, openPurchaseOrderStates).Count(&open)
+	if open > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Supplier has open purchase orders"})
+		return
+	}
+
+	if err := ctrl.DB.WithContext(c).Delete(&models.Supplier{}, id).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete supplier"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Supplier deleted successfully"})
+}
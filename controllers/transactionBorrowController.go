@@ -25,11 +25,16 @@ type BorrowInput struct {
 	BorrowQuantityStr string    `json:"borrow_quantity" binding:"required,min=1"`
 	BorrowDate     string `json:"borrow_date" binding:"required"`
 	DueDate        string `json:"due_date" binding:"required"`
+	LocationIDStr  string `json:"location_id"` // Optional bin to pick from
+	LotIDStr       string `json:"lot_id"`      // Optional lot to issue from
+	Unit           string `json:"unit"`        // Optional unit of borrow_quantity, e.g. "box"; defaults to the base unit
 
 	// These will be populated after validation
 	ItemID    uint `json:"-"`
 	ProjectID uint `json:"-"`
 	BorrowQuantity int `json:"-"`
+	LocationID     *uint `json:"-"`
+	LotID          *uint `json:"-"`
 }
 
 func (ctrl *TransactionBorrowController) BorrowItem(c *gin.Context) {
@@ -57,6 +62,11 @@ func (ctrl *TransactionBorrowController) BorrowItem(c *gin.Context) {
 	}
 	input.ProjectID = uint(projectID)
 
+	if !projectAllowed(c, input.ProjectID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: API key is restricted to another project"})
+		return
+	}
+
 	borrowQuantity, err := strconv.ParseUint(input.BorrowQuantityStr, 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
@@ -64,7 +74,27 @@ func (ctrl *TransactionBorrowController) BorrowItem(c *gin.Context) {
 	}
 	input.BorrowQuantity = int(borrowQuantity)
 
-	tx := ctrl.DB.Begin()
+	if input.LocationIDStr != "" {
+		locationID, err := strconv.ParseUint(input.LocationIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid location ID"})
+			return
+		}
+		id := uint(locationID)
+		input.LocationID = &id
+	}
+
+	if input.LotIDStr != "" {
+		lotID, err := strconv.ParseUint(input.LotIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lot ID"})
+			return
+		}
+		id := uint(lotID)
+		input.LotID = &id
+	}
+
+	tx := ctrl.DB.WithContext(c).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback()
@@ -72,19 +102,56 @@ func (ctrl *TransactionBorrowController) BorrowItem(c *gin.Context) {
 	}()
 
 	var item models.Item
-	if err := tx.Preload("Category").First(&item, input.ItemID).Error; err != nil {
+	if err := tx.Scopes(forUpdate).Preload("Category").First(&item, input.ItemID).Error; err != nil {
 		utils.LogError("Failed", err)
 		tx.Rollback()
 		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
 		return
 	}
 
+	if item.Type == models.ItemConsumable {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is a consumable; issue it instead", item.Name)})
+		return
+	}
+
+	if err := checkNotCounting(tx, item.ID); err != nil {
+		tx.Rollback()
+		respondStockError(c, err, "Failed to borrow item")
+		return
+	}
+
+	if input.BorrowQuantity, err = toBaseQuantity(tx, item, input.BorrowQuantity, input.Unit); err != nil {
+		tx.Rollback()
+		respondStockError(c, err, "Failed to borrow item")
+		return
+	}
+
 	if item.Quantity < input.BorrowQuantity {
 		tx.Rollback()
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Not enough %s in stock. Available: %d", item.Name, item.Quantity)})
 		return
 	}
 
+	if err := checkMaintenance(tx, item, input.BorrowQuantity); err != nil {
+		tx.Rollback()
+		respondStockError(c, err, "Failed to borrow item")
+		return
+	}
+
+	if err := pickStock(tx, item, input.BorrowQuantity, input.LocationID); err != nil {
+		tx.Rollback()
+		respondStockError(c, err, "Failed to update location stock")
+		return
+	}
+
+	lots, err := allocateLots(tx, item, input.BorrowQuantity, input.LotID)
+	if err != nil {
+		tx.Rollback()
+		respondStockError(c, err, "Failed to update lot stock")
+		return
+	}
+
 	item.Quantity -= input.BorrowQuantity
 	if err := tx.Save(&item).Error; err != nil {
 		utils.LogError("Failed", err)
@@ -107,14 +174,27 @@ func (ctrl *TransactionBorrowController) BorrowItem(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record borrow transaction"})
 		return
 	}
+	if err := recordBorrowLots(tx, transaction.ID, lots); err != nil {
+		utils.LogError("Failed", err)
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record borrowed lots"})
+		return
+	}
 
 	tx.Commit()
+	evaluateLowStock(ctrl.DB.WithContext(c), item.ID)
+	transaction.Lots = lots
 	c.JSON(http.StatusCreated, gin.H{"message": "Item borrowed successfully", "transaction": transaction})
 }
 
 func (ctrl *TransactionBorrowController) GetAllBorrowTransactions(c *gin.Context) {
+	query := ctrl.DB.Preload("User").Preload("Item.Category").Preload("Project")
+	if projectID, restricted := c.Get("apiKeyProjectID"); restricted {
+		query = query.Where("project_id = ?", projectID)
+	}
+
 	var transactions []models.TransactionBorrow
-	if err := ctrl.DB.Preload("User").Preload("Item.Category").Preload("Project").Find(&transactions).Error; err != nil {
+	if err := query.Find(&transactions).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch borrow transactions"})
 		return
 	}
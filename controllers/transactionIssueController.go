@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+type TransactionIssueController struct {
+	DB *gorm.DB
+}
+
+func NewTransactionIssueController(db *gorm.DB) *TransactionIssueController {
+	return &TransactionIssueController{DB: db}
+}
+
+type IssueInput struct {
+	ItemID     uint   `json:"item_id" binding:"required"`
+	ProjectID  uint   `json:"project_id" binding:"required"`
+	Quantity   int    `json:"quantity" binding:"required,min=1"`
+	IssueDate  string `json:"issue_date" binding:"required"` // YYYY-MM-DD
+	LocationID *uint  `json:"location_id"`                   // Optional bin to pick from
+	LotID      *uint  `json:"lot_id"`                        // Optional lot to issue from
+	Unit       string `json:"unit"`                          // Optional unit of quantity, e.g. "box"
+	Note       string `json:"note"`
+}
+
+// ConsumptionLine is how much of an item a project used up.
+type ConsumptionLine struct {
+	ProjectID   uint   `json:"project_id"`
+	ProjectName string `json:"project_name"`
+	ItemID      uint   `json:"item_id"`
+	ItemName    string `json:"item_name"`
+	Quantity    int    `json:"quantity"`
+	Issues      int    `json:"issues"`
+	FirstIssue  string `json:"first_issue"`
+	LastIssue   string `json:"last_issue"`
+}
+
+// IssueItem hands consumables to a project. Stock goes down for good; there
+// is no return.
+func (ctrl *TransactionIssueController) IssueItem(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var input IssueInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := time.Parse("2006-01-02", input.IssueDate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "issue_date must be YYYY-MM-DD"})
+		return
+	}
+	if !projectAllowed(c, input.ProjectID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: API key is restricted to another project"})
+		return
+	}
+
+	var issue models.TransactionIssue
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var item models.Item
+		if err := tx.Scopes(forUpdate).First(&item, input.ItemID).Error; err != nil {
+			return stockError{http.StatusNotFound, "Item not found"}
+		}
+		if item.Type != models.ItemConsumable {
+			return stockError{http.StatusBadRequest, fmt.Sprintf("%s is not a consumable; borrow it instead", item.Name)}
+		}
+		var project models.Project
+		if err := tx.First(&project, input.ProjectID).Error; err != nil {
+			return stockError{http.StatusBadRequest, "Invalid project ID provided"}
+		}
+		quantity, err := toBaseQuantity(tx, item, input.Quantity, input.Unit)
+		if err != nil {
+			return err
+		}
+
+		issue = models.TransactionIssue{
+			UserID:    userID,
+			ProjectID: project.ID,
+			Quantity:  quantity,
+			IssueDate: input.IssueDate,
+			Note:      input.Note,
+		}
+		return issueStock(tx, item, &issue, input.LocationID, input.LotID)
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to issue item")
+		return
+	}
+
+	evaluateLowStock(ctrl.DB.WithContext(c), input.ItemID)
+	c.JSON(http.StatusCreated, gin.H{"message": "Item issued successfully", "transaction": issue})
+}
+
+func (ctrl *TransactionIssueController) GetIssueTransactions(c *gin.Context) {
+	query := ctrl.DB.Preload("User", auditActorColumns).Preload("Item.Category").Preload("Project").Order("id desc")
+	if projectID := c.Query("project_id"); projectID != "" {
+		query = query.Where("project_id = ?", projectID)
+	}
+	if itemID := c.Query("item_id"); itemID != "" {
+		query = query.Where("item_id = ?", itemID)
+	}
+	if projectID, restricted := c.Get("apiKeyProjectID"); restricted {
+		query = query.Where("project_id = ?", projectID)
+	}
+
+	var transactions []models.TransactionIssue
+	if err := query.Find(&transactions).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch issue transactions"})
+		return
+	}
+	c.JSON(http.StatusOK, transactions)
+}
+
+// GetConsumption totals the consumables issued per project and item,
+// optionally within start_date and end_date (YYYY-MM-DD, inclusive).
+func (ctrl *TransactionIssueController) GetConsumption(c *gin.Context) {
+	query := ctrl.DB.Model(&models.TransactionIssue{}).
+		Select("transaction_issues.project_id, projects.name AS project_name, transaction_issues.item_id, items.name AS item_name, " +
+			"SUM(transaction_issues.quantity) AS quantity, COUNT(*) AS issues, " +
+			"MIN(transaction_issues.issue_date) AS first_issue, MAX(transaction_issues.issue_date) AS last_issue").
+		Joins("JOIN projects ON projects.id = transaction_issues.project_id").
+		Joins("JOIN items ON items.id = transaction_issues.item_id").
+		Group("transaction_issues.project_id, projects.name, transaction_issues.item_id, items.name").
+		Order("transaction_issues.project_id, items.name")
+
+	if projectID := c.Query("project_id"); projectID != "" {
+		query = query.Where("transaction_issues.project_id = ?", projectID)
+	}
+	if projectID, restricted := c.Get("apiKeyProjectID"); restricted {
+		query = query.Where("transaction_issues.project_id = ?", projectID)
+	}
+	if categoryID := c.Query("category_id"); categoryID != "" {
+		query = query.Where("items.category_id = ?", categoryID)
+	}
+	startDate, endDate := c.Query("start_date"), c.Query("end_date")
+	if startDate != "" {
+		if _, err := time.Parse("2006-01-02", startDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start_date must be YYYY-MM-DD"})
+			return
+		}
+		query = query.Where("transaction_issues.issue_date >= ?", startDate)
+	}
+	if endDate != "" {
+		if _, err := time.Parse("2006-01-02", endDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "end_date must be YYYY-MM-DD"})
+			return
+		}
+		query = query.Where("transaction_issues.issue_date <= ?", endDate)
+	}
+
+	var lines []ConsumptionLine
+	if err := query.Scan(&lines).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build consumption report"})
+		return
+	}
+
+	total := 0
+	for _, line := range lines {
+		total += line.Quantity
+	}
+	c.JSON(http.StatusOK, gin.H{"data": lines, "total_quantity": total})
+}
+
+// issueStock takes issue.Quantity of a consumable out of stock and records
+// the issue. Call it inside a transaction.
+func issueStock(tx *gorm.DB, item models.Item, issue *models.TransactionIssue, locationID, lotID *uint) error {
+	if err := checkNotCounting(tx, item.ID); err != nil {
+		return err
+	}
+	if item.Quantity < issue.Quantity {
+		return stockError{http.StatusBadRequest, fmt.Sprintf("Not enough %s in stock. Available: %d", item.Name, item.Quantity)}
+	}
+	if err := pickStock(tx, item, issue.Quantity, locationID); err != nil {
+		return err
+	}
+	allocations, err := allocateLots(tx, item, issue.Quantity, lotID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Item{}).Where("id = ?", item.ID).
+		Update("quantity", gorm.Expr("quantity - ?", issue.Quantity)).Error; err != nil {
+		return err
+	}
+
+	issue.ItemID = item.ID
+	if err := tx.Create(issue).Error; err != nil {
+		return err
+	}
+	if len(allocations) == 0 {
+		return nil
+	}
+	issue.Lots = make([]models.IssueLot, 0, len(allocations))
+	for _, allocation := range allocations {
+		issue.Lots = append(issue.Lots, models.IssueLot{IssueID: issue.ID, LotID: allocation.LotID, Quantity: allocation.Quantity})
+	}
+	return tx.Create(&issue.Lots).Error
+}
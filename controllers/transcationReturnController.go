@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -23,8 +24,12 @@ type ReturnInput struct {
 	BorrowIDStr   string   `json:"borrow_id" binding:"required"`
 	QuantityStr   string    `json:"quantity" binding:"required,min=1"`
 	ReturnDate string `json:"return_date" binding:"required"`
+	LocationIDStr string `json:"location_id"` // Optional bin to put the items back into
+	Unit          string `json:"unit"`        // Optional unit of quantity; defaults to the base unit
+	Usage         []UsageInput `json:"usage" binding:"dive"` // Optional use of the returned serials while out
 	BorrowID   uint   `json:"-"`
 	Quantity   int    `json:"-"`
+	LocationID *uint  `json:"-"`
 
 }
 
@@ -52,7 +57,17 @@ func (ctrl *TransactionReturnController) ReturnItem(c *gin.Context) {
 	}
 	input.Quantity = int(quantity)
 
-	tx := ctrl.DB.Begin()
+	if input.LocationIDStr != "" {
+		locationID, err := strconv.ParseUint(input.LocationIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid location ID"})
+			return
+		}
+		id := uint(locationID)
+		input.LocationID = &id
+	}
+
+	tx := ctrl.DB.WithContext(c).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback()
@@ -60,20 +75,72 @@ func (ctrl *TransactionReturnController) ReturnItem(c *gin.Context) {
 	}()
 
 	var borrow models.TransactionBorrow
-	if err := tx.Preload("Item").First(&borrow, input.BorrowID).Error; err != nil {
+	if err := tx.Scopes(forUpdate).Preload("Item").First(&borrow, input.BorrowID).Error; err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusNotFound, gin.H{"error": "Original borrow transaction not found"})
 		return
 	}
 
-	if input.Quantity > borrow.BorrowQuantity {
+	if !projectAllowed(c, borrow.ProjectID) {
+		tx.Rollback()
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: API key is restricted to another project"})
+		return
+	}
+
+	if input.Quantity, err = toBaseQuantity(tx, borrow.Item, input.Quantity, input.Unit); err != nil {
+		tx.Rollback()
+		respondStockError(c, err, "Failed to return item")
+		return
+	}
+
+	if borrow.BoxLoanID != nil || borrow.KitLoanID != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "This item was borrowed in a box or kit; return it with the box or kit"})
+		return
+	}
+
+	if err := checkNotCounting(tx, borrow.ItemID); err != nil {
+		tx.Rollback()
+		respondStockError(c, err, "Failed to return item")
+		return
+	}
+
+	returned, err := returnedQuantity(tx, borrow.ID)
+	if err != nil {
+		utils.LogError("Failed", err)
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to return item"})
+		return
+	}
+	if input.Quantity > borrow.BorrowQuantity-returned {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Return quantity cannot exceed the %d still borrowed", borrow.BorrowQuantity-returned)})
+		return
+	}
+
+	if len(input.Usage) > input.Quantity {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Usage is given for more serials than are returned"})
+		return
+	}
+
+	if input.LocationID != nil {
+		if err := putAwayStock(tx, borrow.ItemID, *input.LocationID, input.Quantity); err != nil {
+			tx.Rollback()
+			respondStockError(c, err, "Failed to update location stock")
+			return
+		}
+	}
+
+	if err := restoreLots(tx, borrow.ID, input.Quantity); err != nil {
+		utils.LogError("Failed", err)
 		tx.Rollback()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Return quantity cannot exceed borrowed quantity"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update lot stock"})
 		return
 	}
 
-	borrow.Item.Quantity += input.Quantity
-	if err := tx.Save(&borrow.Item).Error; err != nil {
+	if err := tx.Model(&models.Item{}).Where("id = ?", borrow.ItemID).
+		Update("quantity", gorm.Expr("quantity + ?", input.Quantity)).Error; err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update item quantity"})
 		return
@@ -93,13 +160,26 @@ func (ctrl *TransactionReturnController) ReturnItem(c *gin.Context) {
 		return
 	}
 
+	workOrders, err := recordUsage(tx, userID, input.Usage, []uint{borrow.ItemID}, models.UsageLog{ReturnID: &transaction.ID})
+	if err != nil {
+		tx.Rollback()
+		respondStockError(c, err, "Failed to record usage")
+		return
+	}
+
 	tx.Commit()
-	c.JSON(http.StatusCreated, gin.H{"message": "Item returned successfully", "transaction": transaction})
+	evaluateLowStock(ctrl.DB.WithContext(c), borrow.ItemID)
+	c.JSON(http.StatusCreated, gin.H{"message": "Item returned successfully", "transaction": transaction, "work_orders": workOrders})
 }
 
 func (ctrl *TransactionReturnController) GetAllReturnTransactions(c *gin.Context) {
+	query := ctrl.DB.Preload("User").Preload("Item.Category").Preload("Project").Preload("Borrow")
+	if projectID, restricted := c.Get("apiKeyProjectID"); restricted {
+		query = query.Where("project_id = ?", projectID)
+	}
+
 	var transactions []models.TransactionReturn
-	if err := ctrl.DB.Preload("User").Preload("Item.Category").Preload("Project").Preload("Borrow").Find(&transactions).Error; err != nil {
+	if err := query.Find(&transactions).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch return transactions"})
 		return
 	}
@@ -0,0 +1,334 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+const recoveryCodeCount = 10
+
+type TwoFactorController struct {
+	DB     *gorm.DB
+	Issuer string // Shown as the account issuer in authenticator apps
+}
+
+func NewTwoFactorController(db *gorm.DB, issuer string) *TwoFactorController {
+	return &TwoFactorController{DB: db, Issuer: issuer}
+}
+
+// Enroll creates a new TOTP secret for the current user. It is not active
+// until confirmed with a code through Verify.
+func (ctrl *TwoFactorController) Enroll(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var user models.User
+	if err := ctrl.DB.First(&user, userID).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+	if err := ctrl.DB.WithContext(c).Save(&user).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": utils.TOTPURI(ctrl.Issuer, user.Username, secret),
+	})
+}
+
+// Verify confirms enrollment with the first code from the authenticator app,
+// enables two-factor, and returns recovery codes plus fresh tokens that carry
+// the second factor. Other sessions are revoked.
+func (ctrl *TwoFactorController) Verify(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := ctrl.DB.First(&user, userID).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start enrollment first"})
+		return
+	}
+	if !verifyTOTPCode(ctrl.DB.WithContext(c), &user, input.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	var codes []string
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	tokens, err := ctrl.rotateSessions(c, user.ID)
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	ctrl.audit(c, user.ID, user.ID, "enable_2fa")
+	tokens["recovery_codes"] = codes
+	tokens["message"] = "Two-factor authentication enabled"
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Disable turns off two-factor for the current user after re-checking the
+// password and a current TOTP or recovery code.
+func (ctrl *TwoFactorController) Disable(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var input struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := ctrl.DB.First(&user, userID).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if !utils.CheckPasswordHash(input.Password, user.Password) ||
+		(!verifyTOTPCode(ctrl.DB.WithContext(c), &user, input.Code) && !useRecoveryCode(ctrl.DB.WithContext(c), user.ID, input.Code)) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	if err := disableTwoFactor(ctrl.DB.WithContext(c), user.ID); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	tokens, err := ctrl.rotateSessions(c, user.ID)
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	ctrl.audit(c, user.ID, user.ID, "disable_2fa")
+	tokens["message"] = "Two-factor authentication disabled"
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of the current user.
+func (ctrl *TwoFactorController) RegenerateRecoveryCodes(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := ctrl.DB.First(&user, userID).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if !verifyTOTPCode(ctrl.DB.WithContext(c), &user, input.Code) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(ctrl.DB.WithContext(c), user.ID)
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	ctrl.audit(c, user.ID, user.ID, "regenerate_recovery_codes")
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// ResetUserTwoFactor lets an admin remove two-factor from a user who lost
+// both their device and recovery codes.
+func (ctrl *TwoFactorController) ResetUserTwoFactor(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var user models.User
+	if err := ctrl.DB.First(&user, id).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := disableTwoFactor(ctrl.DB.WithContext(c), user.ID); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset two-factor authentication"})
+		return
+	}
+	if err := utils.RevokeUserTokens(c.Request.Context(), ctrl.DB.WithContext(c), user.ID); err != nil {
+		utils.LogError("Failed to revoke tokens after two-factor reset", err)
+	}
+
+	ctrl.audit(c, c.MustGet("userID").(uint), user.ID, "reset_2fa")
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication reset successfully"})
+}
+
+// rotateSessions revokes every existing token of the user and returns a new
+// pair for the caller, marked according to the user's current 2FA state.
+func (ctrl *TwoFactorController) rotateSessions(c *gin.Context, userID uint) (gin.H, error) {
+	if err := utils.RevokeUserTokens(c.Request.Context(), ctrl.DB.WithContext(c), userID); err != nil {
+		return nil, err
+	}
+	var user models.User
+	if err := ctrl.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	return generateLoginTokens(user, user.TOTPEnabled)
+}
+
+func (ctrl *TwoFactorController) audit(c *gin.Context, actorID, userID uint, action string) {
+	auditLog := models.AuditLog{
+		UserID:    &actorID,
+		Action:    action,
+		TableName: "users",
+		RecordID:  userID,
+		IPAddress: c.ClientIP(),
+	}
+	ctrl.DB.Create(&auditLog)
+}
+
+// verifyTOTPCode checks code against the user's secret and records the
+// accepted time step so the same code cannot be used twice.
+func verifyTOTPCode(db *gorm.DB, user *models.User, code string) bool {
+	if user.TOTPSecret == "" || code == "" {
+		return false
+	}
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok || step <= user.TOTPLastStep {
+		return false
+	}
+	// Conditional update so two concurrent requests cannot both use the step
+	result := db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+	user.TOTPLastStep = step
+	return true
+}
+
+// useRecoveryCode consumes a matching unused recovery code.
+func useRecoveryCode(db *gorm.DB, userID uint, code string) bool {
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return false
+	}
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, utils.HashToken(code)).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+func replaceRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	if err := db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := utils.GenerateRandomToken(8)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw[0:4]+"-"+raw[4:8]+"-"+raw[8:12]+"-"+raw[12:16])
+		records = append(records, models.RecoveryCode{UserID: userID, CodeHash: utils.HashToken(raw)})
+	}
+	if err := db.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func disableTwoFactor(db *gorm.DB, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_enabled":   false,
+			"totp_secret":    "",
+			"totp_last_step": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
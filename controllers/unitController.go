@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+type UnitController struct {
+	DB *gorm.DB
+}
+
+func NewUnitController(db *gorm.DB) *UnitController {
+	return &UnitController{DB: db}
+}
+
+type ItemUnitInput struct {
+	Name   string `json:"name" binding:"required"`
+	Factor int    `json:"factor" binding:"required,min=2"` // Base units per pack
+}
+
+// ItemUnitsInput names the base unit of an item and the packs it comes in.
+// Renaming the base unit does not convert the stock.
+type ItemUnitsInput struct {
+	BaseUnit string          `json:"base_unit" binding:"required"`
+	Units    []ItemUnitInput `json:"units" binding:"dive"`
+}
+
+func (ctrl *UnitController) GetItemUnits(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var item models.Item
+	if err := ctrl.DB.First(&item, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
+	}
+
+	var units []models.ItemUnit
+	if err := ctrl.DB.Where("item_id = ?", item.ID).Order("factor").Find(&units).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch units"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"base_unit": item.BaseUnit, "units": units, "in_units": inUnits(item.Quantity, units)})
+}
+
+// SetItemUnits replaces the units of an item.
+func (ctrl *UnitController) SetItemUnits(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var input ItemUnitsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	baseUnit := strings.TrimSpace(input.BaseUnit)
+
+	var units []models.ItemUnit
+	err := ctrl.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var item models.Item
+		if err := tx.First(&item, id).Error; err != nil {
+			return stockError{http.StatusNotFound, "Item not found"}
+		}
+
+		seen := map[string]bool{strings.ToLower(baseUnit): true}
+		for _, in := range input.Units {
+			name := strings.TrimSpace(in.Name)
+			if seen[strings.ToLower(name)] {
+				return stockError{http.StatusBadRequest, fmt.Sprintf("Unit %s is defined twice", name)}
+			}
+			seen[strings.ToLower(name)] = true
+			units = append(units, models.ItemUnit{ItemID: item.ID, Name: name, Factor: in.Factor})
+		}
+
+		if err := tx.Model(&item).Update("base_unit", baseUnit).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("item_id = ?", item.ID).Delete(&models.ItemUnit{}).Error; err != nil {
+			return err
+		}
+		if len(units) == 0 {
+			return nil
+		}
+		return tx.Create(&units).Error
+	})
+	if err != nil {
+		respondStockError(c, err, "Failed to update units")
+		return
+	}
+	if units == nil {
+		units = []models.ItemUnit{}
+	}
+	c.JSON(http.StatusOK, gin.H{"base_unit": baseUnit, "units": units})
+}
+
+// toBaseQuantity converts quantity in unit to the base unit of item. An
+// empty unit is the base unit. Unit names are matched case-insensitively.
+func toBaseQuantity(tx *gorm.DB, item models.Item, quantity int, unit string) (int, error) {
+	unit = strings.TrimSpace(unit)
+	if unit == "" || strings.EqualFold(unit, item.BaseUnit) {
+		return quantity, nil
+	}
+
+	var units []models.ItemUnit
+	if err := tx.Where("item_id = ?", item.ID).Order("factor").Find(&units).Error; err != nil {
+		return 0, err
+	}
+	names := []string{item.BaseUnit}
+	for _, u := range units {
+		if strings.EqualFold(u.Name, unit) {
+			return quantity * u.Factor, nil
+		}
+		names = append(names, u.Name)
+	}
+	return 0, stockError{http.StatusBadRequest, fmt.Sprintf("%s has no unit %q; use one of %s", item.Name, unit, strings.Join(names, ", "))}
+}
+
+// inUnits breaks quantity down into full packs of each unit, e.g. 50 pcs is
+// 2 boxes of 24.
+func inUnits(quantity int, units []models.ItemUnit) map[string]int {
+	result := make(map[string]int, len(units))
+	for _, u := range units {
+		result[u.Name] = quantity / u.Factor
+	}
+	return result
+}
@@ -55,21 +55,45 @@ func (ctrl *UserController) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	roleChanged := input.Role != "" && input.Role != user.Role
 	if input.Role != "" {
 		user.Role = input.Role
 	}
+	if input.Email != nil {
+		user.Email = normalizeEmail(input.Email)
+	}
 
-	if err := ctrl.DB.Save(&user).Error; err != nil {
+	if err := ctrl.DB.WithContext(c).Save(&user).Error; err != nil {
 		utils.LogError("Failed", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
+
+	// Tokens carry the role, so existing ones must not outlive a role change
+	if roleChanged {
+		if err := utils.RevokeUserTokens(c.Request.Context(), ctrl.DB.WithContext(c), user.ID); err != nil {
+			utils.LogError("Failed to revoke tokens after role change", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke existing sessions"})
+			return
+		}
+		ctrl.DB.First(&user, user.ID)
+	}
 	c.JSON(http.StatusOK, user)
 }
 
 func (ctrl *UserController) DeleteUser(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
-	if err := ctrl.DB.Delete(&models.User{}, id).Error; err != nil {
+	var user models.User
+	if err := ctrl.DB.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err := utils.RevokeUserTokens(c.Request.Context(), ctrl.DB.WithContext(c), user.ID); err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke existing sessions"})
+		return
+	}
+	if err := ctrl.DB.WithContext(c).Delete(&user).Error; err != nil {
 		utils.LogError("Failed", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 		return
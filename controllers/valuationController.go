@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"warehouse-store/models"
+	"warehouse-store/utils"
+)
+
+type ValuationController struct {
+	DB *gorm.DB
+}
+
+func NewValuationController(db *gorm.DB) *ValuationController {
+	return &ValuationController{DB: db}
+}
+
+type itemValue struct {
+	ItemID   uint    `json:"item_id"`
+	Name     string  `json:"name"`
+	Quantity int     `json:"quantity"`
+	UnitCost float64 `json:"unit_cost"`
+	Value    float64 `json:"value"`
+}
+
+type categoryValue struct {
+	CategoryID uint        `json:"category_id"`
+	Name       string      `json:"name"`
+	Value      float64     `json:"value"`
+	Items      []itemValue `json:"items"`
+}
+
+type projectValue struct {
+	ProjectID uint        `json:"project_id"`
+	Name      string      `json:"name"`
+	Value     float64     `json:"value"`
+	Items     []itemValue `json:"items"` // Quantity is what is still out
+}
+
+// GetInventoryValue values the stock in the warehouse at average cost, per
+// item and per category.
+func (ctrl *ValuationController) GetInventoryValue(c *gin.Context) {
+	query := ctrl.DB.Preload("Category").Order("category_id, name")
+	if categoryID := c.Query("category_id"); categoryID != "" {
+		query = query.Where("category_id = ?", categoryID)
+	}
+
+	var items []models.Item
+	if err := query.Find(&items).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to value inventory"})
+		return
+	}
+
+	categories := make([]categoryValue, 0)
+	index := make(map[uint]int)
+	total, unvalued := 0.0, 0
+	for _, item := range items {
+		i, ok := index[item.CategoryID]
+		if !ok {
+			i = len(categories)
+			index[item.CategoryID] = i
+			categories = append(categories, categoryValue{CategoryID: item.CategoryID, Name: item.Category.Name, Items: []itemValue{}})
+		}
+		value := valueOf(item.Quantity, item.UnitCost)
+		categories[i].Items = append(categories[i].Items, itemValue{ItemID: item.ID, Name: item.Name, Quantity: item.Quantity, UnitCost: item.UnitCost, Value: value})
+		categories[i].Value = roundMoney(categories[i].Value + value)
+		total += value
+		if item.UnitCost == 0 && item.Quantity > 0 {
+			unvalued++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"categories": categories, "total": roundMoney(total), "unvalued_items": unvalued})
+}
+
+// GetProjectValue values the equipment still out on projects: borrowed and
+// not yet returned, at the current average cost.
+func (ctrl *ValuationController) GetProjectValue(c *gin.Context) {
+	query := ctrl.DB.Preload("Item").Preload("Project").Order("project_id, item_id")
+	if projectID := c.Query("project_id"); projectID != "" {
+		query = query.Where("project_id = ?", projectID)
+	}
+	if projectID, restricted := c.Get("apiKeyProjectID"); restricted {
+		query = query.Where("project_id = ?", projectID)
+	}
+
+	var borrows []models.TransactionBorrow
+	if err := query.Find(&borrows).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to value projects"})
+		return
+	}
+	returned, err := returnedQuantities(ctrl.DB, borrows)
+	if err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to value projects"})
+		return
+	}
+
+	projects := make([]projectValue, 0)
+	index := make(map[uint]int)
+	total := 0.0
+	for _, borrow := range borrows {
+		out := borrow.BorrowQuantity - returned[borrow.ID]
+		if out <= 0 {
+			continue
+		}
+		i, ok := index[borrow.ProjectID]
+		if !ok {
+			i = len(projects)
+			index[borrow.ProjectID] = i
+			projects = append(projects, projectValue{ProjectID: borrow.ProjectID, Name: borrow.Project.Name, Items: []itemValue{}})
+		}
+
+		value := valueOf(out, borrow.Item.UnitCost)
+		projects[i].Value = roundMoney(projects[i].Value + value)
+		total += value
+
+		// One row per item, however many borrows it took
+		items := projects[i].Items
+		if n := len(items); n > 0 && items[n-1].ItemID == borrow.ItemID {
+			items[n-1].Quantity += out
+			items[n-1].Value = roundMoney(items[n-1].Value + value)
+			continue
+		}
+		projects[i].Items = append(items, itemValue{ItemID: borrow.ItemID, Name: borrow.Item.Name, Quantity: out, UnitCost: borrow.Item.UnitCost, Value: value})
+	}
+	c.JSON(http.StatusOK, gin.H{"projects": projects, "total": roundMoney(total)})
+}
+
+// GetDamageValue totals the value of written-off damage per month, within
+// start_date and end_date (YYYY-MM-DD, inclusive) when given.
+func (ctrl *ValuationController) GetDamageValue(c *gin.Context) {
+	query := ctrl.DB.Preload("Item").Preload("Project").
+		Where("written_off_at IS NOT NULL").Order("written_off_at")
+	if startDate := c.Query("start_date"); startDate != "" {
+		start, err := time.Parse("2006-01-02", startDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start_date must be YYYY-MM-DD"})
+			return
+		}
+		query = query.Where("written_off_at >= ?", start)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		end, err := time.Parse("2006-01-02", endDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "end_date must be YYYY-MM-DD"})
+			return
+		}
+		query = query.Where("written_off_at < ?", end.AddDate(0, 0, 1))
+	}
+	if projectID := c.Query("project_id"); projectID != "" {
+		query = query.Where("project_id = ?", projectID)
+	}
+
+	var reports []models.DamageReport
+	if err := query.Find(&reports).Error; err != nil {
+		utils.LogError("Failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to value damage"})
+		return
+	}
+
+	months := make(map[string]float64)
+	total := 0.0
+	for _, report := range reports {
+		month := report.WrittenOffAt.Format("2006-01")
+		months[month] = roundMoney(months[month] + report.WrittenOffValue)
+		total += report.WrittenOffValue
+	}
+	periods := make([]gin.H, 0, len(months))
+	for _, month := range sortedKeys(months) {
+		periods = append(periods, gin.H{"month": month, "value": months[month]})
+	}
+	c.JSON(http.StatusOK, gin.H{"periods": periods, "total": roundMoney(total), "reports": reports})
+}
+
+// valueWriteOff values a damage report when it is set to written off, and
+// clears the value when it is set back.
+func valueWriteOff(db *gorm.DB, report *models.DamageReport) error {
+	writtenOff := strings.EqualFold(strings.TrimSpace(report.Status), models.DamageWrittenOff)
+	if !writtenOff {
+		report.WrittenOffAt = nil
+		report.WrittenOffValue = 0
+		return nil
+	}
+	if report.WrittenOffAt != nil {
+		return nil
+	}
+
+	var item models.Item
+	if err := db.Select("id", "unit_cost").First(&item, report.ItemID).Error; err != nil {
+		return err
+	}
+	units := int(report.Broken_Drone)
+	if units == 0 {
+		units = 1
+	}
+	now := time.Now()
+	report.Status = models.DamageWrittenOff
+	report.WrittenOffAt = &now
+	report.WrittenOffValue = valueOf(units, item.UnitCost)
+	return nil
+}
+
+// averageCostExpr sets the weighted average unit cost of an item in the
+// UPDATE that raises its quantity by quantity received at unitCost. Stock on
+// hand without a cost does not dilute the average.
+func averageCostExpr(quantity int, unitCost float64) clause.Expr {
+	return gorm.Expr("CASE WHEN unit_cost > 0 AND quantity > 0 THEN (quantity * unit_cost + ?) / (quantity + ?) ELSE ? END",
+		float64(quantity)*unitCost, quantity, unitCost)
+}
+
+// returnedQuantities is how much of each borrow transaction has come back.
+func returnedQuantities(db *gorm.DB, borrows []models.TransactionBorrow) (map[uint]int, error) {
+	ids := make([]uint, 0, len(borrows))
+	for _, borrow := range borrows {
+		ids = append(ids, borrow.ID)
+	}
+	result := make(map[uint]int, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	var rows []struct {
+		BorrowID uint
+		Quantity int
+	}
+	err := db.Model(&models.TransactionReturn{}).Select("borrow_id, SUM(return_quantity) AS quantity").
+		Where("borrow_id IN ?", ids).Group("borrow_id").Scan(&rows).Error
+	for _, row := range rows {
+		result[row.BorrowID] = row.Quantity
+	}
+	return result, err
+}
+
+func valueOf(quantity int, unitCost float64) float64 {
+	return roundMoney(float64(quantity) * unitCost)
+}
+
+func roundMoney(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"math"
+	"testing"
+
+	"gorm.io/gorm"
+	"warehouse-store/models"
+)
+
+func TestAverageCostExpr(t *testing.T) {
+	tests := []struct {
+		name     string
+		quantity int
+		unitCost float64
+		received int
+		cost     float64
+		want     float64
+	}{
+		{"weighted by quantity", 10, 2, 30, 4, 3.5},
+		{"no stock on hand", 0, 2, 5, 4, 4},
+		{"stock on hand without a cost", 10, 0, 5, 4, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, &models.Item{})
+			item := models.Item{Name: "battery", Quantity: tt.quantity, UnitCost: tt.unitCost, Type: "consumable"}
+			if err := db.Create(&item).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			err := db.Model(&item).Updates(map[string]interface{}{
+				"unit_cost": averageCostExpr(tt.received, tt.cost),
+				"quantity":  gorm.Expr("quantity + ?", tt.received),
+			}).Error
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := db.First(&item, item.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if item.Quantity != tt.quantity+tt.received || math.Abs(item.UnitCost-tt.want) > 1e-9 {
+				t.Errorf("after receiving %d at %.2f: %d at %.4f; want %d at %.2f",
+					tt.received, tt.cost, item.Quantity, item.UnitCost, tt.quantity+tt.received, tt.want)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/xuri/excelize/v2"
 	"gorm.io/gorm"
 	"warehouse-store/models"
+	"warehouse-store/utils"
 )
 
 type WarrantyController struct {
@@ -227,7 +228,7 @@ func (wc *WarrantyController) UploadXLSX(c *gin.Context) {
 
 	// Batch insert warranties
 	if len(warranties) > 0 {
-		if err := wc.DB.Create(&warranties).Error; err != nil {
+		if err := wc.DB.WithContext(c).Create(&warranties).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to insert warranty records: " + err.Error(),
 			})
@@ -235,6 +236,15 @@ func (wc *WarrantyController) UploadXLSX(c *gin.Context) {
 		}
 	}
 
+	// Register the boxes named in the sheet that do not exist yet
+	var boxNumbers []uint
+	for _, warranty := range warranties {
+		boxNumbers = append(boxNumbers, warranty.BoxID)
+	}
+	if err := ensureBoxes(wc.DB.WithContext(c), boxNumbers); err != nil {
+		utils.LogError("Failed", err)
+	}
+
 	// Prepare response
 	response := UploadXLSXResponse{
 		Message:       "XLSX file processed successfully",
@@ -334,12 +344,15 @@ func (wc *WarrantyController) CreateWarranty(c *gin.Context) {
 		return
 	}
 
-	if err := wc.DB.Create(&warranty).Error; err != nil {
+	if err := wc.DB.WithContext(c).Create(&warranty).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create warranty",
 		})
 		return
 	}
+	if err := ensureBoxes(wc.DB.WithContext(c), []uint{warranty.BoxID}); err != nil {
+		utils.LogError("Failed", err)
+	}
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Warranty created successfully",
@@ -387,7 +400,7 @@ func (wc *WarrantyController) UpdateWarranty(c *gin.Context) {
 	}
 
 	// Update the warranty
-	if err := wc.DB.Model(&warranty).Updates(updatedWarranty).Error; err != nil {
+	if err := wc.DB.WithContext(c).Model(&warranty).Updates(updatedWarranty).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to update warranty",
 		})
@@ -420,7 +433,7 @@ func (wc *WarrantyController) DeleteWarranty(c *gin.Context) {
 	}
 
 	// Delete the warranty
-	if err := wc.DB.Delete(&warranty).Error; err != nil {
+	if err := wc.DB.WithContext(c).Delete(&warranty).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to delete warranty",
 		})
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -12,17 +20,27 @@ import (
 	"gorm.io/gorm"
 
 	"warehouse-store/config"
-	"warehouse-store/middlewares"
+	"warehouse-store/controllers"
 	"warehouse-store/models"
 	"warehouse-store/routers"
 	"warehouse-store/utils"
 )
 
 func main() {
+	verifyAudit := flag.Bool("verify-audit", false, "verify the audit log hash chain and checkpoints, then exit")
+	flag.Parse()
+
 	utils.InitLogger()
 	defer utils.Logger.Sync()
 	cfg := config.LoadConfig()
 
+	if err := utils.InitJWT(cfg); err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+	if err := utils.InitAuditCheckpointKey(cfg.AuditCheckpointKey); err != nil {
+		log.Fatalf("Failed to load audit checkpoint key: %v", err)
+	}
+
 	// Connect to PostgreSQL
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s  timezone=Asia/Bangkok",
 		cfg.DBHost, cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBPort)
@@ -44,27 +62,40 @@ func main() {
 	}
 
 	// Auto-migrate database schema
-	err = db.AutoMigrate(&models.User{}, &models.Project{}, &models.Category{}, &models.Item{}, &models.TransactionBorrow{}, &models.TransactionReturn{}, &models.DamageReport{}, &models.AuditLog{}, &models.Warranty{})
+	err = db.AutoMigrate(&models.User{}, &models.Project{}, &models.Category{}, &models.Item{}, &models.TransactionBorrow{}, &models.TransactionReturn{}, &models.DamageReport{}, &models.AuditLog{}, &models.Warranty{}, &models.RecoveryCode{}, &models.APIKey{}, &models.Location{}, &models.ItemLocation{}, &models.StockTransfer{}, &models.Box{}, &models.BoxItem{}, &models.BoxEvent{}, &models.BoxLoan{}, &models.Lot{}, &models.BorrowLot{}, &models.IssueLot{}, &models.Supplier{}, &models.PurchaseOrder{}, &models.PurchaseOrderLine{}, &models.GoodsReceipt{}, &models.GoodsReceiptLine{}, &models.Stocktake{}, &models.StocktakeLine{}, &models.StocktakeCount{}, &models.StockAdjustment{}, &models.Kit{}, &models.KitComponent{}, &models.KitLoan{}, &models.TransactionIssue{}, &models.ItemUnit{}, &models.DepreciationRun{}, &models.DepreciationEntry{}, &models.UsageLog{}, &models.MaintenanceRule{}, &models.WorkOrder{})
 	if err != nil {
 		log.Fatalf("Failed to auto-migrate database: %v", err)
 	}
+	if err := utils.MigrateAuditChain(db); err != nil {
+		log.Fatalf("Failed to migrate audit chain: %v", err)
+	}
 	log.Println("Database migration completed")
 
-	// Connect to Redis (optional for this example, but good practice for caching)
+	if err := utils.RegisterAuditCallbacks(db); err != nil {
+		log.Fatalf("Failed to register audit callbacks: %v", err)
+	}
+
+	if *verifyAudit {
+		os.Exit(runAuditVerification(db, cfg))
+	}
+	utils.StartAuditChaining(db, cfg.AuditChainInterval)
+	utils.StartAuditCheckpoints(db, cfg.AuditCheckpointFile, cfg.AuditCheckpointEvery)
+	controllers.StartDepreciationRuns(db, cfg.DepreciationEvery)
+
+	// Connect to Redis (optional; token revocation falls back to the database without it)
 	rdb := redis.NewClient(&redis.Options{
 		Addr: cfg.RedisAddr,
 		DB:   0, // use default DB
 	})
 
-	_, err = rdb.Ping(rdb.Context()).Result()
-	if err != nil {
-		log.Printf("Could not connect to Redis: %v (Redis caching will not be used)", err)
-	} else {
-		log.Println("Connected to Redis")
-	}
+	utils.ConnectRedis(rdb, cfg.RedisRetryEvery)
+
+	utils.InitMailer(cfg)
+	utils.InitNotifiers(cfg)
 
 	// Setup Gin router
-	r := routers.SetupRouter(db)
+	auditWriter := utils.NewAuditWriter(db, cfg.AuditBufferSize, cfg.AuditBatchSize, cfg.AuditFlushInterval, cfg.AuditOverflow)
+	r := routers.SetupRouter(db, cfg, auditWriter)
 
 	// Configure CORS middleware
 	r.Use(cors.New(cors.Config{
@@ -76,13 +107,57 @@ func main() {
 		MaxAge:           parseDuration(cfg.CORSMaxAge),
 	}))
 
-	r.Use(middlewares.AuditLogger(db))
+	// Run the server until SIGINT or SIGTERM
+	srv := &http.Server{Addr: ":" + cfg.ServerPort, Handler: r}
+	go func() {
+		log.Printf("Server listening on :%s", cfg.ServerPort)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("Shutting down server")
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Server shutdown: %v", err)
+	}
+	// Only after in-flight requests are done, so their entries are flushed too
+	if err := auditWriter.Close(ctx); err != nil {
+		log.Printf("Audit entries still buffered at shutdown were lost: %v", err)
+	}
+	if dropped := auditWriter.Dropped(); dropped > 0 {
+		log.Printf("Audit entries dropped since start: %d", dropped)
+	}
+	if _, err := utils.ChainAuditLog(db); err != nil {
+		log.Printf("Failed to chain the last audit entries: %v", err)
+	}
+}
+
+// runAuditVerification prints the audit chain and checkpoint reports and
+// returns the process exit code: 0 when both verify, 1 otherwise.
+func runAuditVerification(db *gorm.DB, cfg *config.Config) int {
+	chain, err := utils.VerifyAuditChain(db)
+	if err != nil {
+		log.Printf("Failed to verify audit chain: %v", err)
+		return 1
+	}
+	checkpoints, err := utils.VerifyAuditCheckpoints(db, cfg.AuditCheckpointFile)
+	if err != nil {
+		log.Printf("Failed to read audit checkpoints: %v", err)
+		return 1
+	}
 
-	// Run the server
-	log.Printf("Server listening on :%s", cfg.ServerPort)
-	if err := r.Run(":" + cfg.ServerPort); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	report, _ := json.MarshalIndent(map[string]interface{}{"chain": chain, "checkpoints": checkpoints}, "", "  ")
+	fmt.Println(string(report))
+	if !chain.Valid || !checkpoints.Valid {
+		return 1
 	}
+	return 0
 }
 
 func parseDuration(seconds string) time.Duration {
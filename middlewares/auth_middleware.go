@@ -3,13 +3,25 @@ package middlewares
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"warehouse-store/models"
 	"warehouse-store/utils"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// apiKeyRole is the role given to API key requests; it never matches a real
+// role, so keys cannot reach role-restricted routes.
+const apiKeyRole = "api_key"
+
+func AuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if apiKey := extractAPIKey(c); apiKey != "" {
+			authenticateAPIKey(c, db, apiKey)
+			return
+		}
+
 		tokenString := c.GetHeader("Authorization")
 		if tokenString == "" || !strings.HasPrefix(tokenString, "Bearer ") {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
@@ -20,15 +32,74 @@ func AuthMiddleware() gin.HandlerFunc {
 		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
 
 		claims, err := utils.ParseToken(tokenString)
-		if err != nil {
+		if err != nil || claims.Purpose != "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
+		// Reject tokens issued before the user was deleted, demoted or reset their password
+		version, err := utils.CurrentTokenVersion(c.Request.Context(), db, claims.UserID)
+		if err != nil || version != claims.TokenVersion {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
+
 		c.Set("userID", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("role", claims.Role)
+		c.Set("mfa", claims.MFA)
 		c.Next()
 	}
 }
+
+// extractAPIKey accepts keys from X-API-Key or "Authorization: ApiKey <key>".
+func extractAPIKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "ApiKey ") {
+		return strings.TrimPrefix(header, "ApiKey ")
+	}
+	return ""
+}
+
+func authenticateAPIKey(c *gin.Context, db *gorm.DB, key string) {
+	var apiKey models.APIKey
+	if !utils.IsAPIKey(key) || db.Where("key_hash = ?", utils.HashToken(key)).First(&apiKey).Error != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt)) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "API key expired or revoked"})
+		c.Abort()
+		return
+	}
+
+	// The key acts as its owner, so it stops working once the owner is deleted
+	var owner models.User
+	if err := db.Select("id", "username").First(&owner, apiKey.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "API key owner no longer exists"})
+		c.Abort()
+		return
+	}
+
+	// Throttle last-used writes to one per minute per key
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > time.Minute {
+		db.Model(&apiKey).UpdateColumn("last_used_at", now)
+	}
+
+	c.Set("userID", owner.ID)
+	c.Set("username", owner.Username)
+	c.Set("role", apiKeyRole)
+	c.Set("apiKeyID", apiKey.ID)
+	c.Set("scopes", strings.Split(apiKey.Scopes, ","))
+	if apiKey.ProjectID != nil {
+		c.Set("apiKeyProjectID", *apiKey.ProjectID)
+	}
+	c.Next()
+}
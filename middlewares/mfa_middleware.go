@@ -0,0 +1,28 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireMFA rejects sessions that were not established with a second factor.
+// When required is false it is a no-op, so it can be toggled by config.
+func RequireMFA(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !required {
+			c.Next()
+			return
+		}
+		mfa, _ := c.Get("mfa")
+		if ok, _ := mfa.(bool); !ok {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":        "Two-factor authentication required",
+				"mfa_required": true,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
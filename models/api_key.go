@@ -0,0 +1,50 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// APIKey lets machine clients such as scanning stations authenticate without
+// a human login. Requests made with a key act as UserID and are limited to
+// Scopes and, when set, to a single project.
+type APIKey struct {
+	gorm.Model
+	Name        string   `gorm:"not null"`
+	Prefix      string   `gorm:"not null"`                      // Non-secret part of the key, shown to identify it
+	KeyHash     string   `gorm:"uniqueIndex;not null" json:"-"` // SHA-256 of the full key
+	Scopes      string   `gorm:"not null"`                      // Comma separated, see APIKeyScopes
+	UserID      uint     `gorm:"not null"`
+	User        User     `gorm:"foreignkey:UserID"`
+	ProjectID   *uint    // Optional restriction to one project
+	Project     *Project `gorm:"foreignkey:ProjectID"`
+	ExpiresAt   *time.Time
+	LastUsedAt  *time.Time
+	RevokedAt   *time.Time
+	CreatedByID uint `gorm:"not null"`
+}
+
+// APIKeyScopes lists the permissions that can be granted to an API key.
+var APIKeyScopes = []string{
+	"items:read",
+	"projects:read",
+	"categories:read",
+	"transactions:read",
+	"transactions:write",
+	"damage-reports:read",
+	"damage-reports:write",
+	"locations:read",
+	"locations:write",
+	"labels:read",
+	"boxes:read",
+	"boxes:write",
+	"lots:read",
+	"purchasing:read",
+	"purchasing:write",
+	"receiving:write",
+	"stocktakes:read",
+	"stocktakes:write",
+	"kits:read",
+	"maintenance:read",
+	"maintenance:write",
+}
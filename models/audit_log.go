@@ -4,12 +4,17 @@ import "gorm.io/gorm"
 
 type AuditLog struct {
 	gorm.Model
-	UserID    uint   `gorm:"not null"`
+	UserID    *uint  `gorm:"index"` // Actor; nil for anonymous or system actions
 	User      User   `gorm:"foreignkey:UserID"`
-	Action    string `gorm:"not null"` // e.g., "create_item", "update_item"
-	TableName string `gorm:"not null"` // e.g., "items"
+	Action    string `gorm:"not null"` // e.g., "create", "update", "delete", "login"
+	TableName string `gorm:"not null"` // Entity type, e.g., "items"
 	RecordID  uint   `gorm:"not null"`
-	OldValue  string `gorm:"type:text"`
-	NewValue  string `gorm:"type:text"`
+	OldValue  string `gorm:"type:text"` // JSON snapshot of a deleted record
+	NewValue  string `gorm:"type:text"` // JSON snapshot of a created record
+	Changes   string `gorm:"type:text"` // JSON field-level diff of an update: {"field": {"old": x, "new": y}}
 	IPAddress string
+	APIKeyID  *uint   `gorm:"index"`       // Set when the action was made with an API key
+	PrevHash  string  `gorm:"size:64"`     // Hash of the entry before this one
+	Hash      string  `gorm:"size:64"`     // SHA-256 over PrevHash and this entry's content
+	ChainSeq  *uint64 `gorm:"uniqueIndex"` // Position in the chain; nil until the entry is linked
 }
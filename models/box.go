@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Box statuses.
+const (
+	BoxAvailable  = "available"
+	BoxBorrowed   = "borrowed"
+	BoxIncomplete = "incomplete" // Came back with contents missing
+)
+
+// Box is a transport case holding serialized drones (warranties whose BoxID
+// is the box Number) and accessory items.
+type Box struct {
+	gorm.Model
+	Number      uint   `gorm:"uniqueIndex;not null"` // The XX of the "BOX_XX" label, referenced by Warranty.BoxID
+	Name        string `gorm:"not null"`
+	Description string
+	Status      string `gorm:"not null;default:available"`
+	SealNumber  string // Number on the current tamper seal; empty when unsealed
+	SealedAt    *time.Time
+	Items       []BoxItem `gorm:"foreignkey:BoxID"`
+}
+
+// BoxItem is an accessory packed in a box, e.g. batteries or a controller.
+type BoxItem struct {
+	gorm.Model
+	BoxID    uint `gorm:"not null;uniqueIndex:idx_box_item"`
+	ItemID   uint `gorm:"not null;uniqueIndex:idx_box_item"`
+	Item     Item `gorm:"foreignkey:ItemID"`
+	Quantity int  `gorm:"not null"`
+}
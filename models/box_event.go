@@ -0,0 +1,24 @@
+package models
+
+import "gorm.io/gorm"
+
+// Box event types.
+const (
+	BoxEventSeal    = "seal"
+	BoxEventUnseal  = "unseal"
+	BoxEventBorrow  = "borrow"
+	BoxEventReturn  = "return"
+	BoxEventCheckIn = "check-in"
+)
+
+// BoxEvent records what happened to a box and who did it.
+type BoxEvent struct {
+	gorm.Model
+	BoxID      uint   `gorm:"not null;index"`
+	Type       string `gorm:"not null"`
+	UserID     uint   `gorm:"not null"`
+	User       User   `gorm:"foreignkey:UserID"`
+	SealNumber string
+	Note       string
+	Missing    string `gorm:"type:text"` // JSON list of contents missing at return or check-in
+}
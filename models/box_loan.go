@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BoxLoan is a whole box borrowed for a project. Its contents are borrowed
+// as one TransactionBorrow per item.
+type BoxLoan struct {
+	gorm.Model
+	BoxID      uint    `gorm:"not null;index"`
+	Box        Box     `gorm:"foreignkey:BoxID"`
+	ProjectID  uint    `gorm:"not null"`
+	Project    Project `gorm:"foreignkey:ProjectID"`
+	UserID     uint    `gorm:"not null"`
+	User       User    `gorm:"foreignkey:UserID"`
+	BorrowDate string  `gorm:"not null"`
+	DueDate    string  `gorm:"not null"`
+	ReturnDate string
+	ReturnedAt *time.Time
+	Borrows    []TransactionBorrow `gorm:"foreignkey:BoxLoanID"`
+}
@@ -2,8 +2,20 @@ package models
 
 import "gorm.io/gorm"
 
+// Depreciation methods of a category's serialized assets.
+const (
+	DepreciationStraightLine     = "straight_line"
+	DepreciationDecliningBalance = "declining_balance"
+)
+
 type Category struct {
 	gorm.Model
-	Name        string `gorm:"unique;not null"`
-	Description string
+	Name                string `gorm:"unique;not null"`
+	Description         string
+	DefaultReorderPoint *int // Used by items of this category without their own
+	DefaultTargetLevel  *int
+	DepreciationMethod  string   // Empty when assets of this category are not depreciated
+	UsefulLifeMonths    *int     // Required for straight_line
+	DepreciationRate    *float64 // Annual rate for declining_balance, e.g. 0.4
+	SalvageRate         *float64 // Share of the cost left at the end, 0 when not set
 }
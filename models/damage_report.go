@@ -1,16 +1,26 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DamageWrittenOff is the status of a damage report whose units are
+// written off; their value at that time is kept with the report.
+const DamageWrittenOff = "Written Off"
 
 type DamageReport struct {
 	gorm.Model
-	ItemID       uint    `gorm:"not null"`
-	Item         Item    `gorm:"foreignkey:ItemID"` //Belongs To relationship
-	ReporterID   uint    `gorm:"not null"`
-	Reporter     User    `gorm:"foreignkey:ReporterID"`
-	ProjectID    uint    `gorm:"not null"`
-	Project      Project `gorm:"foreignkey:ProjectID"` //Belongs To relationship
-	Description  string  `gorm:"not null"`
-	Status       string  `gorm:"default:'Pending'"`
-	Broken_Drone uint
+	ItemID          uint    `gorm:"not null"`
+	Item            Item    `gorm:"foreignkey:ItemID"` //Belongs To relationship
+	ReporterID      uint    `gorm:"not null"`
+	Reporter        User    `gorm:"foreignkey:ReporterID"`
+	ProjectID       uint    `gorm:"not null"`
+	Project         Project `gorm:"foreignkey:ProjectID"` //Belongs To relationship
+	Description     string  `gorm:"not null"`
+	Status          string  `gorm:"default:'Pending'"`
+	Broken_Drone    uint
+	WrittenOffAt    *time.Time
+	WrittenOffValue float64 // Broken_Drone units (at least one) at the item's unit cost
 }
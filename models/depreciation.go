@@ -0,0 +1,27 @@
+package models
+
+import "gorm.io/gorm"
+
+// DepreciationRun posts one month of depreciation of the serialized assets.
+// Each month is posted once.
+type DepreciationRun struct {
+	gorm.Model
+	Period  string `gorm:"unique;not null"` // YYYY-MM
+	UserID  *uint  // Nil for the scheduled run
+	User    *User  `gorm:"foreignkey:UserID"`
+	Assets  int
+	Amount  float64
+	Entries []DepreciationEntry `gorm:"foreignkey:RunID"`
+}
+
+// DepreciationEntry is the depreciation of one asset in one month.
+type DepreciationEntry struct {
+	gorm.Model
+	RunID        uint     `gorm:"not null;index"`
+	WarrantyID   uint     `gorm:"not null;uniqueIndex:idx_depreciation_asset_period"`
+	Warranty     Warranty `gorm:"foreignkey:WarrantyID"`
+	Period       string   `gorm:"not null;uniqueIndex:idx_depreciation_asset_period"`
+	OpeningValue float64
+	Amount       float64
+	BookValue    float64 // At the end of the month
+}
@@ -0,0 +1,29 @@
+package models
+
+import "gorm.io/gorm"
+
+// GoodsReceipt is one delivery, booked against a purchase order or received
+// directly.
+type GoodsReceipt struct {
+	gorm.Model
+	PurchaseOrderID *uint  `gorm:"index"`
+	UserID          uint   `gorm:"not null"`
+	User            User   `gorm:"foreignkey:UserID"`
+	ReceivedDate    string `gorm:"not null"`
+	Note            string
+	Lines           []GoodsReceiptLine `gorm:"foreignkey:GoodsReceiptID"`
+}
+
+type GoodsReceiptLine struct {
+	gorm.Model
+	GoodsReceiptID      uint     `gorm:"not null;index"`
+	PurchaseOrderLineID *uint    `gorm:"index"`
+	ItemID              uint     `gorm:"not null"`
+	Item                Item     `gorm:"foreignkey:ItemID"`
+	Quantity            int      `gorm:"not null"`
+	LotID               *uint    // Lot the units were booked into
+	Lot                 *Lot     `gorm:"foreignkey:LotID"`
+	LocationID          *uint    // Bin the units were put away in
+	SerialCount         int      // Warranty records created for received serial numbers
+	UnitCost            *float64 // Cost per base unit, when known
+}
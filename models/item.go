@@ -1,6 +1,16 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Item types.
+const (
+	ItemReturnable = "returnable" // Borrowed and expected back (default)
+	ItemConsumable = "consumable" // Issued to a project and used up, e.g. zip ties
+	ItemSerialized = "serialized" // Returnable, tracked by serial number per unit
+)
 
 type Item struct {
 	gorm.Model
@@ -11,4 +21,11 @@ type Item struct {
 	CategoryID  uint     // Foreign key to Category table
 	Category    Category // Belongs To relationship
 	Remark      string
+	ReorderPoint  *int       // Low-stock threshold; nil uses the category default
+	TargetLevel   *int       // Quantity to restock up to; nil uses the category default
+	LowStockSince *time.Time // Set while the quantity is at or below the reorder point
+	LotPolicy     string     // "fefo" or "fifo", see LotPolicyFEFO
+	Type          string     `gorm:"not null;default:returnable"` // See ItemReturnable
+	BaseUnit      string     `gorm:"not null;default:pcs"`        // Unit Quantity is counted in, see ItemUnit
+	UnitCost      float64    `gorm:"not null;default:0"`          // Weighted average cost per base unit, from receipts
 }
@@ -0,0 +1,15 @@
+package models
+
+import "gorm.io/gorm"
+
+// ItemLocation is the quantity of an item stored in one bin. The bin
+// quantities of an item never add up to more than Item.Quantity; the rest is
+// in the warehouse but not put away yet.
+type ItemLocation struct {
+	gorm.Model
+	ItemID     uint     `gorm:"not null;uniqueIndex:idx_item_location"`
+	Item       Item     `gorm:"foreignkey:ItemID"`
+	LocationID uint     `gorm:"not null;uniqueIndex:idx_item_location"`
+	Location   Location `gorm:"foreignkey:LocationID"`
+	Quantity   int      `gorm:"not null"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "gorm.io/gorm"
+
+// ItemUnit is a pack an item comes in, e.g. a box of 24 propellers. Stock
+// is always kept in the item's BaseUnit; Factor converts one pack to it.
+type ItemUnit struct {
+	gorm.Model
+	ItemID uint   `gorm:"not null;uniqueIndex:idx_item_unit"`
+	Name   string `gorm:"not null;uniqueIndex:idx_item_unit"` // e.g. "box"
+	Factor int    `gorm:"not null"`                           // Base units per pack, e.g. 24
+}
@@ -0,0 +1,22 @@
+package models
+
+import "gorm.io/gorm"
+
+// Kit is a set of items that are always borrowed together, e.g. a show
+// drone kit of one drone, two batteries, a charger and spare props. A kit
+// holds no stock of its own; it is built from its component items.
+type Kit struct {
+	gorm.Model
+	Name        string `gorm:"uniqueIndex;not null"`
+	Description string
+	Components  []KitComponent `gorm:"foreignkey:KitID"`
+}
+
+// KitComponent is how many of an item one kit contains.
+type KitComponent struct {
+	gorm.Model
+	KitID    uint `gorm:"not null;uniqueIndex:idx_kit_item"`
+	ItemID   uint `gorm:"not null;uniqueIndex:idx_kit_item"`
+	Item     Item `gorm:"foreignkey:ItemID"`
+	Quantity int  `gorm:"not null"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// KitLoan is one or more kits borrowed for a project. The components are
+// borrowed as one TransactionBorrow per item; consumable components are
+// issued instead.
+type KitLoan struct {
+	gorm.Model
+	KitID      uint    `gorm:"not null;index"`
+	Kit        Kit     `gorm:"foreignkey:KitID"`
+	Quantity   int     `gorm:"not null"` // Number of kits
+	ProjectID  uint    `gorm:"not null"`
+	Project    Project `gorm:"foreignkey:ProjectID"`
+	UserID     uint    `gorm:"not null"`
+	User       User    `gorm:"foreignkey:UserID"`
+	BorrowDate string  `gorm:"not null"`
+	DueDate    string  `gorm:"not null"`
+	ReturnDate string
+	ReturnedAt *time.Time
+	Borrows    []TransactionBorrow `gorm:"foreignkey:KitLoanID"`
+	Issues     []TransactionIssue  `gorm:"foreignkey:KitLoanID"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "gorm.io/gorm"
+
+// Location types, from outermost to innermost. Stock is only held in bins.
+const (
+	LocationWarehouse = "warehouse"
+	LocationZone      = "zone"
+	LocationBin       = "bin"
+)
+
+// Location is a warehouse, a zone inside a warehouse or a bin (shelf slot)
+// inside a zone.
+type Location struct {
+	gorm.Model
+	Type        string `gorm:"not null;index"`       // "warehouse", "zone" or "bin"
+	Code        string `gorm:"uniqueIndex;not null"` // Short label printed on the shelf, e.g. "WH1-A-03"
+	Name        string `gorm:"not null"`
+	Description string
+	ParentID    *uint     `gorm:"index"` // Warehouse of a zone, zone of a bin
+	Parent      *Location `gorm:"foreignkey:ParentID"`
+}
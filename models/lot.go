@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// How stock is chosen from an item's lots when none is given on borrow.
+const (
+	LotPolicyFEFO = "fefo" // First expired, first out (default)
+	LotPolicyFIFO = "fifo" // First received, first out
+)
+
+// Lot is a batch of an item received together, e.g. batteries from one
+// production run. Lot quantities of an item never add up to more than
+// Item.Quantity; the rest is stock without a known lot.
+type Lot struct {
+	gorm.Model
+	ItemID           uint      `gorm:"not null;uniqueIndex:idx_item_lot"`
+	Item             Item      `gorm:"foreignkey:ItemID"`
+	Code             string    `gorm:"not null;uniqueIndex:idx_item_lot"`
+	ReceivedDate     time.Time `gorm:"not null"`
+	ManufactureDate  *time.Time
+	ExpiryDate       *time.Time `gorm:"index"`
+	Supplier         string
+	ReceivedQuantity int `gorm:"not null"`
+	Quantity         int `gorm:"not null"` // Still in the warehouse
+}
+
+// BorrowLot is the part of a borrow transaction that came from one lot.
+type BorrowLot struct {
+	gorm.Model
+	BorrowID         uint              `gorm:"not null;index"`
+	Borrow           TransactionBorrow `gorm:"foreignkey:BorrowID"`
+	LotID            uint              `gorm:"not null;index"`
+	Lot              Lot               `gorm:"foreignkey:LotID"`
+	Quantity         int               `gorm:"not null"`
+	ReturnedQuantity int               `gorm:"not null;default:0"`
+}
+
+// IssueLot is the part of an issue of a consumable that came from one lot.
+// Issued units do not come back, but recalls still need to find them.
+type IssueLot struct {
+	gorm.Model
+	IssueID  uint             `gorm:"not null;index"`
+	Issue    TransactionIssue `gorm:"foreignkey:IssueID"`
+	LotID    uint             `gorm:"not null;index"`
+	Lot      Lot              `gorm:"foreignkey:LotID"`
+	Quantity int              `gorm:"not null"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "gorm.io/gorm"
+
+// MaintenanceRule says how often the serialized units of an item, or of
+// every item in a category, need servicing. A unit is due when its usage
+// since the last completed work order for the rule reaches any threshold.
+type MaintenanceRule struct {
+	gorm.Model
+	Name               string `gorm:"not null"`
+	Description        string
+	ItemID             *uint     `gorm:"index"` // Either ItemID or CategoryID
+	Item               *Item     `gorm:"foreignkey:ItemID"`
+	CategoryID         *uint     `gorm:"index"`
+	Category           *Category `gorm:"foreignkey:CategoryID"`
+	EveryFlights       *int
+	EveryFlightHours   *int
+	EveryBatteryCycles *int
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Purchase order states. Lines can only be changed in draft; receipts are
+// booked against sent and partially received orders.
+const (
+	PurchaseOrderDraft             = "draft"
+	PurchaseOrderSent              = "sent"
+	PurchaseOrderPartiallyReceived = "partially_received"
+	PurchaseOrderReceived          = "received"
+	PurchaseOrderCancelled         = "cancelled"
+)
+
+type PurchaseOrder struct {
+	gorm.Model
+	SupplierID   uint     `gorm:"not null;index"`
+	Supplier     Supplier `gorm:"foreignkey:SupplierID"`
+	Status       string   `gorm:"not null;index"`
+	Reference    string   // Supplier quote or order number
+	ExpectedDate string   // Expected delivery date
+	Notes        string
+	CreatedByID  uint `gorm:"not null"`
+	SentAt       *time.Time
+	ClosedAt     *time.Time          // Fully received or cancelled
+	Lines        []PurchaseOrderLine `gorm:"foreignkey:PurchaseOrderID"`
+	Receipts     []GoodsReceipt      `gorm:"foreignkey:PurchaseOrderID"`
+}
+
+type PurchaseOrderLine struct {
+	gorm.Model
+	PurchaseOrderID  uint    `gorm:"not null;index"`
+	ItemID           uint    `gorm:"not null;index"`
+	Item             Item    `gorm:"foreignkey:ItemID"`
+	Quantity         int     `gorm:"not null"`
+	ReceivedQuantity int     `gorm:"not null;default:0"`
+	UnitPrice        float64 `gorm:"not null;default:0"`
+}
+
+// Outstanding is how much of the line is still to be delivered.
+func (l PurchaseOrderLine) Outstanding() int {
+	return l.Quantity - l.ReceivedQuantity
+}
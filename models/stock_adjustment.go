@@ -0,0 +1,18 @@
+package models
+
+import "gorm.io/gorm"
+
+// StockAdjustment is a correction of Item.Quantity outside borrowing and
+// receiving, e.g. the variance found by a stocktake.
+type StockAdjustment struct {
+	gorm.Model
+	ItemID      uint      `gorm:"not null;index"`
+	Item        Item      `gorm:"foreignkey:ItemID"`
+	LocationID  *uint     `gorm:"index"` // Bin that was corrected, if any
+	Location    *Location `gorm:"foreignkey:LocationID"`
+	Quantity    int       `gorm:"not null"` // Positive for found stock, negative for losses
+	Reason      string    `gorm:"not null"`
+	StocktakeID *uint     `gorm:"index"`
+	UserID      uint      `gorm:"not null"`
+	User        User      `gorm:"foreignkey:UserID"`
+}
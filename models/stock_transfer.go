@@ -0,0 +1,20 @@
+package models
+
+import "gorm.io/gorm"
+
+// StockTransfer records a movement of stock between bins. A nil
+// FromLocationID puts away unlocated stock; a nil ToLocationID takes stock
+// out of its bin without placing it elsewhere.
+type StockTransfer struct {
+	gorm.Model
+	ItemID         uint      `gorm:"not null;index"`
+	Item           Item      `gorm:"foreignkey:ItemID"`
+	FromLocationID *uint     `gorm:"index"`
+	FromLocation   *Location `gorm:"foreignkey:FromLocationID"`
+	ToLocationID   *uint     `gorm:"index"`
+	ToLocation     *Location `gorm:"foreignkey:ToLocationID"`
+	Quantity       int       `gorm:"not null"`
+	UserID         uint      `gorm:"not null"`
+	User           User      `gorm:"foreignkey:UserID"`
+	Note           string
+}
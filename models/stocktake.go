@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// What a stocktake counts.
+const (
+	StocktakeFull     = "full"     // Every item
+	StocktakeCategory = "category" // The items of one category
+	StocktakeLocation = "location" // The bins of one location
+)
+
+// Stocktake states. Items of a counting stocktake cannot be borrowed.
+const (
+	StocktakeCounting  = "counting"
+	StocktakeApproved  = "approved"
+	StocktakeCancelled = "cancelled"
+)
+
+// Stocktake is a physical count of part of the warehouse, reconciled with
+// the system quantities when it is approved.
+type Stocktake struct {
+	gorm.Model
+	Name         string    `gorm:"not null"`
+	Scope        string    `gorm:"not null"` // "full", "category" or "location"
+	CategoryID   *uint     `gorm:"index"`
+	Category     *Category `gorm:"foreignkey:CategoryID"`
+	LocationID   *uint     `gorm:"index"`
+	Location     *Location `gorm:"foreignkey:LocationID"`
+	Status       string    `gorm:"not null;index"`
+	Note         string
+	CreatedByID  uint `gorm:"not null"`
+	CreatedBy    User `gorm:"foreignkey:CreatedByID"`
+	ApprovedByID *uint
+	ApprovedBy   *User `gorm:"foreignkey:ApprovedByID"`
+	ApprovedAt   *time.Time
+	Lines        []StocktakeLine `gorm:"foreignkey:StocktakeID"`
+}
+
+// StocktakeLine is one item to count; in a location stocktake, one item in
+// one bin.
+type StocktakeLine struct {
+	gorm.Model
+	StocktakeID    uint             `gorm:"not null;index"`
+	ItemID         uint             `gorm:"not null;index"`
+	Item           Item             `gorm:"foreignkey:ItemID"`
+	LocationID     *uint            `gorm:"index"`
+	Location       *Location        `gorm:"foreignkey:LocationID"`
+	SystemQuantity int              `gorm:"not null"` // Quantity when the count started
+	Counts         []StocktakeCount `gorm:"foreignkey:LineID"`
+}
+
+// StocktakeCount is what one user counted on a line. Several users may
+// count parts of the same line; the line total is the sum of their counts.
+type StocktakeCount struct {
+	gorm.Model
+	LineID   uint `gorm:"not null;uniqueIndex:idx_line_user"`
+	UserID   uint `gorm:"not null;uniqueIndex:idx_line_user"`
+	User     User `gorm:"foreignkey:UserID"`
+	Quantity int  `gorm:"not null"`
+	Note     string
+}
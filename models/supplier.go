@@ -0,0 +1,14 @@
+package models
+
+import "gorm.io/gorm"
+
+// Supplier is a vendor that purchase orders are placed with.
+type Supplier struct {
+	gorm.Model
+	Name        string `gorm:"unique;not null"`
+	ContactName string
+	Email       string
+	Phone       string
+	Address     string
+	Notes       string
+}
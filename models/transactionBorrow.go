@@ -5,13 +5,16 @@ import "gorm.io/gorm"
 
 type TransactionBorrow struct {
 	gorm.Model
-	UserID         uint    `gorm:"not null"`
-	User           User    `gorm:"foreignkey:UserID"`
-	ItemID         uint    `gorm:"not null"`
-	Item           Item    `gorm:"foreignkey:ItemID"`
-	ProjectID      uint    `gorm:"not null"`
-	Project        Project `gorm:"foreignkey:ProjectID"`
-	BorrowQuantity int     `gorm:"not null"`
-	BorrowDate     string  `gorm:"not null"`
-	DueDate        string  `gorm:"not null"` // Expected return date
+	UserID         uint        `gorm:"not null"`
+	User           User        `gorm:"foreignkey:UserID"`
+	ItemID         uint        `gorm:"not null"`
+	Item           Item        `gorm:"foreignkey:ItemID"`
+	ProjectID      uint        `gorm:"not null"`
+	Project        Project     `gorm:"foreignkey:ProjectID"`
+	BorrowQuantity int         `gorm:"not null"`
+	BorrowDate     string      `gorm:"not null"`
+	DueDate        string      `gorm:"not null"` // Expected return date
+	BoxLoanID      *uint       `gorm:"index"`    // Set when borrowed as part of a whole box
+	KitLoanID      *uint       `gorm:"index"`    // Set when borrowed as a kit component
+	Lots           []BorrowLot `gorm:"foreignkey:BorrowID"`
 }
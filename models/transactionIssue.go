@@ -0,0 +1,20 @@
+package models
+
+import "gorm.io/gorm"
+
+// TransactionIssue hands consumables to a project. Unlike a borrow, nothing
+// is expected back.
+type TransactionIssue struct {
+	gorm.Model
+	UserID    uint    `gorm:"not null"`
+	User      User    `gorm:"foreignkey:UserID"`
+	ItemID    uint    `gorm:"not null;index"`
+	Item      Item    `gorm:"foreignkey:ItemID"`
+	ProjectID uint    `gorm:"not null;index"`
+	Project   Project `gorm:"foreignkey:ProjectID"`
+	Quantity  int     `gorm:"not null"`
+	IssueDate string  `gorm:"not null;index"` // YYYY-MM-DD
+	Note      string
+	KitLoanID *uint      `gorm:"index"` // Set when issued as a kit component
+	Lots      []IssueLot `gorm:"foreignkey:IssueID"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "gorm.io/gorm"
+
+// UsageLog is how much one serialized unit was used while it was out,
+// recorded when it comes back.
+type UsageLog struct {
+	gorm.Model
+	WarrantyID    uint     `gorm:"not null;index"`
+	Warranty      Warranty `gorm:"foreignkey:WarrantyID"`
+	ReturnID      *uint    `gorm:"index"` // Set when logged on an item return
+	BoxLoanID     *uint    `gorm:"index"` // Set when logged on a box return
+	KitLoanID     *uint    `gorm:"index"` // Set when logged on a kit return
+	UserID        uint     `gorm:"not null"`
+	User          User     `gorm:"foreignkey:UserID"`
+	Flights       int
+	FlightMinutes int
+	BatteryCycles int
+	Note          string
+}
@@ -7,10 +7,22 @@ import (
 
 type User struct {
 	gorm.Model
-	Username string `gorm:"unique;not null"`
-	Password string `gorm:"not null"`
-	Role     string `gorm:"not null;default:'user'"`
-	// Email 			 string `gorm:"unique;not null"`
-	ResetToken       string
-	ResetTokenExpiry time.Time
+	Username string  `gorm:"unique;not null"`
+	Password string  `gorm:"not null"`
+	Role     string  `gorm:"not null;default:'user'"`
+	Email    *string `gorm:"uniqueIndex"` // Optional so existing accounts stay valid; NULLs do not collide
+	// ResetToken holds the SHA-256 of the single-use token mailed to the user
+	ResetToken       string    `gorm:"index" json:"-"`
+	ResetTokenExpiry time.Time `json:"-"`
+	ResetFailures    int       `gorm:"not null;default:0" json:"-"` // Wrong two-factor codes given with the current reset token
+	TokenVersion     uint      `gorm:"not null;default:0" json:"-"` // Bumped to revoke every token issued before
+	TOTPSecret       string    `json:"-"`                           // Set on enrollment, only trusted once TOTPEnabled
+	TOTPEnabled      bool      `gorm:"not null;default:false"`
+	TOTPLastStep     int64     `json:"-"`                               // Last accepted time step, prevents code replay
+	OIDCSubject      *string   `gorm:"column:oidc_subject;uniqueIndex"` // "sub" claim of the linked single sign-on identity
+	// LocalLoginDisabled forces the user to sign in through single sign-on
+	LocalLoginDisabled bool `gorm:"not null;default:false"`
+	// OIDCProvisioned marks accounts created by single sign-on; only their
+	// role follows the IdP groups
+	OIDCProvisioned bool `gorm:"column:oidc_provisioned;not null;default:false"`
 }
@@ -1,6 +1,10 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Warranty struct {
 	gorm.Model
@@ -9,7 +13,9 @@ type Warranty struct {
 	BuyDate           string `gorm:"not null"`
 	TimeWarranty      string `gorm:"not null"`
 	Status            string `gorm:"not null"`
-	BoxID            uint `gorm:"not null"`
+	BoxID            uint `gorm:"not null"` // Number of the Box it is packed in, 0 when unboxed
 	Lot			  string 
 	Remark           string 
+	AcquisitionDate  *time.Time // Start of depreciation; BuyDate when not set
+	AcquisitionCost  *float64   // Cost of this unit; the item's unit cost when not set
 }
\ No newline at end of file
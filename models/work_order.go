@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Work order states. A unit with an open work order is due for maintenance
+// and cannot be borrowed.
+const (
+	WorkOrderOpen      = "open"
+	WorkOrderCompleted = "completed"
+	WorkOrderCancelled = "cancelled"
+)
+
+// WorkOrder is maintenance of one serialized unit, opened by a maintenance
+// rule or by hand, and closed with a record of the work done.
+type WorkOrder struct {
+	gorm.Model
+	WarrantyID    uint             `gorm:"not null;index"`
+	Warranty      Warranty         `gorm:"foreignkey:WarrantyID"`
+	RuleID        *uint            `gorm:"index"` // Nil when opened by hand
+	Rule          *MaintenanceRule `gorm:"foreignkey:RuleID"`
+	Status        string           `gorm:"not null;index"`
+	Reason        string           `gorm:"not null"`
+	Flights       int              // Usage since the last service when opened
+	FlightMinutes int
+	BatteryCycles int
+	CreatedByID   *uint // Nil when opened by a rule
+	CreatedBy     *User `gorm:"foreignkey:CreatedByID"`
+	CompletedByID *uint
+	CompletedBy   *User `gorm:"foreignkey:CompletedByID"`
+	CompletedAt   *time.Time
+	WorkDone      string
+	Cost          *float64
+}
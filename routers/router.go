@@ -3,11 +3,13 @@ package routers
 import (
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"warehouse-store/config"
 	"warehouse-store/controllers"
 	"warehouse-store/middlewares"
+	"warehouse-store/utils"
 )
 
-func SetupRouter(db *gorm.DB) *gin.Engine {
+func SetupRouter(db *gorm.DB, cfg *config.Config, auditWriter *utils.AuditWriter) *gin.Engine {
 	r := gin.Default()
 
 	// CORS (if frontend and backend are on different origins)
@@ -24,63 +26,199 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 		c.Next()
 	})
 
+	// Registered before the routes so it runs for every one of them
+	r.Use(middlewares.AuditLogger(auditWriter, cfg.AuditSkipReads))
+
 	// Initialize controllers
-	authController := controllers.NewAuthController(db)
+	userLoginLimiter := utils.NewLoginLimiter(cfg.LoginMaxAttempts, cfg.LoginLockoutDuration)
+	ipLoginLimiter := utils.NewLoginLimiter(cfg.LoginIPMaxAttempts, cfg.LoginLockoutDuration)
+
+	authController := controllers.NewAuthController(db, userLoginLimiter, ipLoginLimiter)
 	userController := controllers.NewUserController(db)
 	projectController := controllers.NewProjectController(db)
 	itemController := controllers.NewItemController(db)
 	damageReportController := controllers.NewDamageReportController(db)
 	categoryController := controllers.NewCategoryController(db)
-	passwordResetController := controllers.NewPasswordResetController(db)
+	passwordResetController := controllers.NewPasswordResetController(db, cfg.PasswordResetURL, userLoginLimiter, ipLoginLimiter)
 	combinedReportController := controllers.NewCombinedController(db)
 	transactionBorrowController := controllers.NewTransactionBorrowController(db)
 	transactionReturnController := controllers.NewTransactionReturnController(db)
 	warantyController := controllers.NewWarrantyController(db)
+	twoFactorController := controllers.NewTwoFactorController(db, cfg.TOTPIssuer)
+	apiKeyController := controllers.NewAPIKeyController(db)
+	auditLogController := controllers.NewAuditLogController(db, cfg.AuditCheckpointFile)
+	locationController := controllers.NewLocationController(db)
+	labelController := controllers.NewLabelController(db)
+	boxController := controllers.NewBoxController(db)
+	lotController := controllers.NewLotController(db)
+	supplierController := controllers.NewSupplierController(db)
+	purchaseOrderController := controllers.NewPurchaseOrderController(db)
+	receivingController := controllers.NewReceivingController(db)
+	stocktakeController := controllers.NewStocktakeController(db)
+	kitController := controllers.NewKitController(db)
+	transactionIssueController := controllers.NewTransactionIssueController(db)
+	unitController := controllers.NewUnitController(db)
+	valuationController := controllers.NewValuationController(db)
+	depreciationController := controllers.NewDepreciationController(db)
+	maintenanceController := controllers.NewMaintenanceController(db)
+
+	var oidcProvider *utils.OIDCProvider
+	if cfg.OIDCIssuerURL != "" {
+		oidcProvider = utils.NewOIDCProvider(cfg.OIDCIssuerURL, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.OIDCRedirectURL, cfg.OIDCScopes)
+	}
+	oidcController := controllers.NewOIDCController(db, oidcProvider, cfg.OIDCGroupsClaim, cfg.OIDCRoleMapping, cfg.OIDCDefaultRole, cfg.OIDCFrontendURL)
 
 	// Public routes
 	r.POST("/register", authController.Register)
 	r.POST("/login", authController.Login)
+	r.POST("/login/2fa", authController.LoginMFA)
 	r.POST("/request-password-reset", passwordResetController.RequestReset)
 	r.POST("/reset-password", passwordResetController.ResetPassword)
+	r.GET("/.well-known/jwks.json", authController.JWKS)
+	r.GET("/auth/oidc/login", oidcController.Login)
+	r.GET("/auth/oidc/callback", oidcController.Callback)
 
 	// Authenticated routes
 	authorized := r.Group("/")
-	authorized.Use(middlewares.AuthMiddleware())
+	authorized.Use(middlewares.AuthMiddleware(db))
 	{
 		// User & Admin Routes for their own data or common operations
-		authorized.GET("/items", itemController.GetItems)
-		authorized.GET("/items/:id", itemController.GetItemByID)
-		authorized.GET("/projects", projectController.GetProjects)
-		authorized.GET("/projects/:id", projectController.GetProjectByID)
-		authorized.GET("/projects/filter-month/:year/:month", projectController.GetProjectsByMonth)
+		authorized.GET("/items", middlewares.RequireScope("items:read"), itemController.GetItems)
+		authorized.GET("/items/low-stock", middlewares.RequireScope("items:read"), itemController.GetLowStockItems)
+		authorized.GET("/items/:id", middlewares.RequireScope("items:read"), itemController.GetItemByID)
+		authorized.GET("/items/:id/units", middlewares.RequireScope("items:read"), unitController.GetItemUnits)
+		authorized.GET("/projects", middlewares.RequireScope("projects:read"), projectController.GetProjects)
+		authorized.GET("/projects/:id", middlewares.RequireScope("projects:read"), projectController.GetProjectByID)
+		authorized.GET("/projects/filter-month/:year/:month", middlewares.RequireScope("projects:read"), projectController.GetProjectsByMonth)
 
 		// Category routes (accessible to all authenticated users for viewing)
-		authorized.GET("/categories", categoryController.GetCategories)
-		authorized.GET("/categories/:id", categoryController.GetCategoryByID)
+		authorized.GET("/categories", middlewares.RequireScope("categories:read"), categoryController.GetCategories)
+		authorized.GET("/categories/:id", middlewares.RequireScope("categories:read"), categoryController.GetCategoryByID)
 
 		// New Borrow/Return routes with separate controllers
-		authorized.POST("/transactions/borrow", transactionBorrowController.BorrowItem)
-		authorized.POST("/transactions/return", transactionReturnController.ReturnItem)
-		authorized.GET("/transactions/borrows", transactionBorrowController.GetAllBorrowTransactions)
-		authorized.GET("/transactions/returns", transactionReturnController.GetAllReturnTransactions)
+		authorized.POST("/transactions/borrow", middlewares.RequireScope("transactions:write"), transactionBorrowController.BorrowItem)
+		authorized.POST("/transactions/return", middlewares.RequireScope("transactions:write"), transactionReturnController.ReturnItem)
+		authorized.GET("/transactions/borrows", middlewares.RequireScope("transactions:read"), transactionBorrowController.GetAllBorrowTransactions)
+		authorized.GET("/transactions/returns", middlewares.RequireScope("transactions:read"), transactionReturnController.GetAllReturnTransactions)
+		authorized.POST("/transactions/issue", middlewares.RequireScope("transactions:write"), transactionIssueController.IssueItem)
+		authorized.GET("/transactions/issues", middlewares.RequireScope("transactions:read"), transactionIssueController.GetIssueTransactions)
+		authorized.GET("/transactions/consumption", middlewares.RequireScope("transactions:read"), transactionIssueController.GetConsumption)
+
+		// Storage locations and stock movements between bins
+		authorized.GET("/locations", middlewares.RequireScope("locations:read"), locationController.GetLocations)
+		authorized.GET("/locations/:id", middlewares.RequireScope("locations:read"), locationController.GetLocationByID)
+		authorized.GET("/locations/:id/contents", middlewares.RequireScope("locations:read"), locationController.GetLocationContents)
+		authorized.GET("/items/:id/locations", middlewares.RequireScope("locations:read"), locationController.GetItemLocations)
+		authorized.GET("/locations/transfers", middlewares.RequireScope("locations:read"), locationController.GetTransfers)
+		authorized.POST("/locations/transfers", middlewares.RequireScope("locations:write"), locationController.TransferStock)
+
+		// Lots of consumables and recalls
+		authorized.GET("/lots", middlewares.RequireScope("lots:read"), lotController.GetLots)
+		authorized.GET("/lots/:id", middlewares.RequireScope("lots:read"), lotController.GetLotByID)
+		authorized.GET("/lots/:id/recall", middlewares.RequireScope("lots:read"), lotController.GetLotRecall)
+		authorized.GET("/items/:id/lots", middlewares.RequireScope("lots:read"), lotController.GetItemLots)
+
+		// Suppliers, purchase orders and goods receipt
+		authorized.GET("/suppliers", middlewares.RequireScope("purchasing:read"), supplierController.GetSuppliers)
+		authorized.GET("/suppliers/:id", middlewares.RequireScope("purchasing:read"), supplierController.GetSupplierByID)
+		authorized.GET("/purchase-orders", middlewares.RequireScope("purchasing:read"), purchaseOrderController.GetPurchaseOrders)
+		authorized.GET("/purchase-orders/:id", middlewares.RequireScope("purchasing:read"), purchaseOrderController.GetPurchaseOrderByID)
+		authorized.GET("/items/:id/purchase-orders", middlewares.RequireScope("purchasing:read"), purchaseOrderController.GetItemPurchaseOrders)
+		authorized.POST("/purchase-orders/:id/receipts", middlewares.RequireScope("purchasing:write"), purchaseOrderController.ReceiveGoods)
+		authorized.GET("/receipts", middlewares.RequireScope("purchasing:read"), receivingController.GetReceipts)
+		authorized.GET("/receipts/:id", middlewares.RequireScope("purchasing:read"), receivingController.GetReceiptByID)
+
+		// Receiving without a purchase order, with serial capture
+		authorized.POST("/receiving", middlewares.RequireScope("receiving:write"), receivingController.ReceiveStock)
+
+		// Stocktakes: counting and variance
+		authorized.GET("/stocktakes", middlewares.RequireScope("stocktakes:read"), stocktakeController.GetStocktakes)
+		authorized.GET("/stocktakes/:id", middlewares.RequireScope("stocktakes:read"), stocktakeController.GetStocktakeByID)
+		authorized.GET("/stocktakes/:id/variance", middlewares.RequireScope("stocktakes:read"), stocktakeController.GetVariance)
+		authorized.POST("/stocktakes/:id/counts", middlewares.RequireScope("stocktakes:write"), stocktakeController.RecordCounts)
+		authorized.GET("/stock-adjustments", middlewares.RequireScope("stocktakes:read"), stocktakeController.GetStockAdjustments)
+
+		// Kits borrowed and returned as a whole
+		authorized.GET("/kits", middlewares.RequireScope("kits:read"), kitController.GetKits)
+		authorized.GET("/kits/:id", middlewares.RequireScope("kits:read"), kitController.GetKitByID)
+		authorized.GET("/kit-loans", middlewares.RequireScope("kits:read"), kitController.GetKitLoans)
+		authorized.POST("/kits/:id/borrow", middlewares.RequireScope("transactions:write"), kitController.BorrowKit)
+		authorized.POST("/kit-loans/:id/return", middlewares.RequireScope("transactions:write"), kitController.ReturnKit)
+
+		// Maintenance
+		authorized.GET("/maintenance/rules", middlewares.RequireScope("maintenance:read"), maintenanceController.GetMaintenanceRules)
+		authorized.GET("/maintenance/due", middlewares.RequireScope("maintenance:read"), maintenanceController.GetMaintenanceDue)
+		authorized.GET("/maintenance/assets/:serial", middlewares.RequireScope("maintenance:read"), maintenanceController.GetAssetMaintenance)
+		authorized.GET("/work-orders", middlewares.RequireScope("maintenance:read"), maintenanceController.GetWorkOrders)
+		authorized.GET("/work-orders/:id", middlewares.RequireScope("maintenance:read"), maintenanceController.GetWorkOrderByID)
+		authorized.POST("/work-orders", middlewares.RequireScope("maintenance:write"), maintenanceController.CreateWorkOrder)
+		authorized.POST("/work-orders/:id/complete", middlewares.RequireScope("maintenance:write"), maintenanceController.CompleteWorkOrder)
+		authorized.POST("/work-orders/:id/cancel", middlewares.RequireScope("maintenance:write"), maintenanceController.CancelWorkOrder)
+
+		// Drone cases: seals, check-in and whole-box borrowing
+		authorized.GET("/boxes", middlewares.RequireScope("boxes:read"), boxController.GetBoxes)
+		authorized.GET("/boxes/:id", middlewares.RequireScope("boxes:read"), boxController.GetBoxByID)
+		authorized.GET("/boxes/:id/events", middlewares.RequireScope("boxes:read"), boxController.GetBoxEvents)
+		authorized.POST("/boxes/:id/seal", middlewares.RequireScope("boxes:write"), boxController.SealBox)
+		authorized.POST("/boxes/:id/unseal", middlewares.RequireScope("boxes:write"), boxController.UnsealBox)
+		authorized.POST("/boxes/:id/check-in", middlewares.RequireScope("boxes:write"), boxController.CheckInBox)
+		authorized.POST("/boxes/:id/borrow", middlewares.RequireScope("transactions:write"), boxController.BorrowBox)
+		authorized.POST("/boxes/:id/return", middlewares.RequireScope("transactions:write"), boxController.ReturnBox)
+
+		// Barcode/QR labels and scanner lookups
+		authorized.GET("/labels/items/:id", middlewares.RequireScope("labels:read"), labelController.GetItemLabel)
+		authorized.GET("/labels/serials/:serial", middlewares.RequireScope("labels:read"), labelController.GetSerialLabel)
+		authorized.GET("/labels/boxes/:id", middlewares.RequireScope("labels:read"), labelController.GetBoxLabel)
+		authorized.POST("/labels/sheet", middlewares.RequireScope("labels:read"), labelController.GetLabelSheet)
+		authorized.GET("/scan/:code", middlewares.RequireScope("labels:read"), labelController.Scan)
 
 		// Report damage by any authenticated user
-		authorized.POST("/damage-reports", damageReportController.CreateDamageReport)
-		authorized.GET("/damage-reports", damageReportController.GetDamageReports)
-		authorized.PUT("/damage-reports/:id/status", damageReportController.UpdateDamageReportStatus)
+		authorized.POST("/damage-reports", middlewares.RequireScope("damage-reports:write"), damageReportController.CreateDamageReport)
+		authorized.GET("/damage-reports", middlewares.RequireScope("damage-reports:read"), damageReportController.GetDamageReports)
+		authorized.PUT("/damage-reports/:id/status", middlewares.RequireScope("damage-reports:write"), damageReportController.UpdateDamageReportStatus)
+
+		// Routes for a logged-in person only, not API keys
+		session := authorized.Group("/")
+		session.Use(middlewares.RequireUserSession())
+		{
+			// Add refresh token route
+			session.POST("/refresh-token", authController.RefreshToken)
+
+			// Two-factor authentication for the current user
+			session.POST("/2fa/enroll", twoFactorController.Enroll)
+			session.POST("/2fa/verify", twoFactorController.Verify)
+			session.POST("/2fa/disable", twoFactorController.Disable)
+			session.POST("/2fa/recovery-codes", twoFactorController.RegenerateRecoveryCodes)
 
-		// Add refresh token route
-		authorized.POST("/refresh-token", authController.RefreshToken)
+			// Link a single sign-on identity to the current user
+			session.POST("/auth/oidc/link", oidcController.Link)
+		}
 
 		// Admin routes
 		admin := authorized.Group("/")
-		admin.Use(middlewares.AuthorizeRole("admin"))
+		admin.Use(middlewares.AuthorizeRole("admin"), middlewares.RequireMFA(cfg.RequireAdmin2FA))
 		{
 			// User Management
 			admin.GET("/users", userController.GetUsers)
 			admin.GET("/users/:id", userController.GetUserByID)
 			admin.PUT("/users/:id", userController.UpdateUser)
 			admin.DELETE("/users/:id", userController.DeleteUser)
+			admin.POST("/users/:id/unlock", authController.UnlockUser)
+			admin.DELETE("/users/:id/2fa", twoFactorController.ResetUserTwoFactor)
+			admin.PUT("/users/:id/local-login", oidcController.SetLocalLogin)
+
+			// API keys for scanners and automation
+			admin.GET("/admin/api-keys", apiKeyController.GetAPIKeys)
+			admin.POST("/admin/api-keys", apiKeyController.CreateAPIKey)
+			admin.DELETE("/admin/api-keys/:id", apiKeyController.RevokeAPIKey)
+
+			// Audit trail
+			admin.GET("/admin/audit-logs", auditLogController.GetAuditLogs)
+			admin.GET("/admin/audit-logs/export", auditLogController.ExportAuditLogs)
+			admin.GET("/admin/audit-logs/verify", auditLogController.VerifyAuditLogs)
+			admin.GET("/items/:id/history", auditLogController.GetItemHistory)
+			admin.GET("/projects/:id/history", auditLogController.GetProjectHistory)
+			admin.GET("/users/:id/history", auditLogController.GetUserHistory)
 
 			// Project Management
 			admin.POST("/projects", projectController.CreateProject)
@@ -91,6 +229,48 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 			admin.POST("/items", itemController.CreateItem)
 			admin.PUT("/items/:id", itemController.UpdateItem)
 			admin.DELETE("/items/:id", itemController.DeleteItem)
+			admin.PUT("/items/:id/units", unitController.SetItemUnits)
+
+			// Location Management
+			admin.POST("/locations", locationController.CreateLocation)
+			admin.PUT("/locations/:id", locationController.UpdateLocation)
+			admin.DELETE("/locations/:id", locationController.DeleteLocation)
+
+			// Lot Management
+			admin.POST("/items/:id/lots", lotController.CreateLot)
+			admin.PUT("/lots/:id", lotController.UpdateLot)
+			admin.DELETE("/lots/:id", lotController.DeleteLot)
+
+			// Supplier & Purchase Order Management
+			admin.POST("/suppliers", supplierController.CreateSupplier)
+			admin.PUT("/suppliers/:id", supplierController.UpdateSupplier)
+			admin.DELETE("/suppliers/:id", supplierController.DeleteSupplier)
+			admin.POST("/purchase-orders", purchaseOrderController.CreatePurchaseOrder)
+			admin.PUT("/purchase-orders/:id", purchaseOrderController.UpdatePurchaseOrder)
+			admin.DELETE("/purchase-orders/:id", purchaseOrderController.DeletePurchaseOrder)
+			admin.POST("/purchase-orders/:id/send", purchaseOrderController.SendPurchaseOrder)
+			admin.POST("/purchase-orders/:id/cancel", purchaseOrderController.CancelPurchaseOrder)
+
+			// Stocktake Management
+			admin.POST("/stocktakes", stocktakeController.CreateStocktake)
+			admin.POST("/stocktakes/:id/approve", stocktakeController.ApproveStocktake)
+			admin.POST("/stocktakes/:id/cancel", stocktakeController.CancelStocktake)
+
+			// Kit Management
+			admin.POST("/kits", kitController.CreateKit)
+			admin.PUT("/kits/:id", kitController.UpdateKit)
+			admin.DELETE("/kits/:id", kitController.DeleteKit)
+
+			// Maintenance Rules
+			admin.POST("/maintenance/rules", maintenanceController.CreateMaintenanceRule)
+			admin.PUT("/maintenance/rules/:id", maintenanceController.UpdateMaintenanceRule)
+			admin.DELETE("/maintenance/rules/:id", maintenanceController.DeleteMaintenanceRule)
+
+			// Box Management
+			admin.POST("/boxes", boxController.CreateBox)
+			admin.PUT("/boxes/:id", boxController.UpdateBox)
+			admin.DELETE("/boxes/:id", boxController.DeleteBox)
+			admin.PUT("/boxes/:id/contents", boxController.SetBoxContents)
 
 			// Category Management (Admin only)
 			admin.POST("/categories", categoryController.CreateCategory)
@@ -103,6 +283,17 @@ func SetupRouter(db *gorm.DB) *gin.Engine {
 			admin.GET("/admin/transactions/returns", transactionReturnController.GetAllReturnTransactions)
 			admin.GET("/admin/summary-table", combinedReportController.GetFullCombinedData)
 
+			// Inventory valuation
+			admin.GET("/reports/inventory-value", valuationController.GetInventoryValue)
+			admin.GET("/reports/project-value", valuationController.GetProjectValue)
+			admin.GET("/reports/damage-value", valuationController.GetDamageValue)
+
+			// Depreciation
+			admin.GET("/depreciation/book-value", depreciationController.GetBookValue)
+			admin.GET("/depreciation/runs", depreciationController.GetDepreciationRuns)
+			admin.GET("/depreciation/runs/:id", depreciationController.GetDepreciationRunByID)
+			admin.POST("/depreciation/runs", depreciationController.RunDepreciation)
+
 			// Damage Report Management (Admin specific)
 			// These are now available to all authenticated users above
 			// admin.GET("/admin/damage-reports", damageReportController.GetDamageReports)
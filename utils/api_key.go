@@ -0,0 +1,25 @@
+package utils
+
+import "strings"
+
+const apiKeyPrefix = "wms_"
+
+// GenerateAPIKey returns a new key of the form wms_<prefix>_<secret>, the
+// prefix used to identify it in listings and the hash that is stored.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	id, err := GenerateRandomToken(4)
+	if err != nil {
+		return "", "", "", err
+	}
+	secret, err := GenerateRandomToken(32)
+	if err != nil {
+		return "", "", "", err
+	}
+	prefix = apiKeyPrefix + id
+	key = prefix + "_" + secret
+	return key, prefix, HashToken(key), nil
+}
+
+func IsAPIKey(value string) bool {
+	return strings.HasPrefix(value, apiKeyPrefix)
+}
@@ -0,0 +1,278 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"warehouse-store/models"
+)
+
+// Values of these columns are replaced before they reach the audit log.
+var auditRedactedColumns = map[string]bool{
+	"password":    true,
+	"reset_token": true,
+	"totp_secret": true,
+	"key_hash":    true,
+	"code_hash":   true,
+}
+
+// Changes to these columns alone are bookkeeping and not worth an entry.
+var auditIgnoredColumns = map[string]bool{
+	"created_at":      true,
+	"updated_at":      true,
+	"last_used_at":    true,
+	"totp_last_step":  true,
+	"low_stock_since": true,
+}
+
+var auditSkippedTables = map[string]bool{
+	"audit_logs": true,
+}
+
+const (
+	auditRedacted = "[REDACTED]"
+	auditRowsKey  = "audit:rows"
+)
+
+// RegisterAuditCallbacks hooks GORM so every create, update and delete
+// writes an AuditLog entry with the actor, entity and field-level diff. The
+// actor and IP are read from the statement context, so writes made with
+// db.WithContext(c) inside a request are attributed to its user and API key.
+// Every AuditLog insert, from here or elsewhere, is linked into the hash chain
+// by ChainAuditLog once it commits.
+func RegisterAuditCallbacks(db *gorm.DB) error {
+	callbacks := []error{
+		db.Callback().Create().Before("gorm:create").Register("audit:chain", auditChainPrepare),
+		db.Callback().Create().After("gorm:create").Register("audit:after_create", auditAfterCreate),
+		db.Callback().Update().Before("gorm:update").Register("audit:before_update", auditCaptureRows),
+		db.Callback().Update().After("gorm:update").Register("audit:after_update", auditAfterUpdate),
+		db.Callback().Delete().Before("gorm:delete").Register("audit:before_delete", auditCaptureRows),
+		db.Callback().Delete().After("gorm:delete").Register("audit:after_delete", auditAfterDelete),
+	}
+	for _, err := range callbacks {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func auditEnabled(db *gorm.DB) bool {
+	return db.Error == nil && db.Statement.Schema != nil && !auditSkippedTables[db.Statement.Table]
+}
+
+func auditAfterCreate(db *gorm.DB) {
+	if !auditEnabled(db) || db.RowsAffected == 0 {
+		return
+	}
+	stmt := db.Statement
+	eachRecord(stmt.ReflectValue, func(record reflect.Value) {
+		snapshot := make(map[string]interface{})
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			value, _ := field.ValueOf(stmt.Context, record)
+			snapshot[field.DBName] = value
+		}
+		writeAuditLog(db, "create", recordID(snapshot), "", marshalAudit(redact(snapshot)), "")
+	})
+}
+
+// auditCaptureRows loads the rows an update or delete is about to touch so
+// the after callbacks can diff them.
+func auditCaptureRows(db *gorm.DB) {
+	if !auditEnabled(db) {
+		return
+	}
+	rows, ok := findAffectedRows(db)
+	if ok {
+		db.InstanceSet(auditRowsKey, rows)
+	}
+}
+
+func auditAfterUpdate(db *gorm.DB) {
+	if !auditEnabled(db) || db.RowsAffected == 0 {
+		return
+	}
+	value, ok := db.InstanceGet(auditRowsKey)
+	if !ok {
+		return
+	}
+
+	for _, before := range value.([]map[string]interface{}) {
+		id := recordID(before)
+		var after map[string]interface{}
+		if err := auditModelQuery(db).Unscoped().Where(primaryKeyColumn(db)+" = ?", id).Take(&after).Error; err != nil {
+			continue
+		}
+
+		changes := make(map[string]interface{})
+		for column, newValue := range after {
+			if auditIgnoredColumns[column] {
+				continue
+			}
+			oldValue := before[column]
+			if fmt.Sprint(oldValue) == fmt.Sprint(newValue) {
+				continue
+			}
+			if auditRedactedColumns[column] {
+				oldValue, newValue = auditRedacted, auditRedacted
+			}
+			changes[column] = map[string]interface{}{"old": oldValue, "new": newValue}
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		action := "update"
+		if deletedAt, ok := changes["deleted_at"].(map[string]interface{}); ok && deletedAt["new"] == nil {
+			action = "restore"
+		}
+		writeAuditLog(db, action, id, "", "", marshalAudit(changes))
+	}
+}
+
+func auditAfterDelete(db *gorm.DB) {
+	if !auditEnabled(db) || db.RowsAffected == 0 {
+		return
+	}
+	value, ok := db.InstanceGet(auditRowsKey)
+	if !ok {
+		return
+	}
+	for _, before := range value.([]map[string]interface{}) {
+		writeAuditLog(db, "delete", recordID(before), marshalAudit(redact(before)), "", "")
+	}
+}
+
+// findAffectedRows repeats the WHERE clause of the pending statement, plus
+// the primary key of the model it was called on, as a query.
+func findAffectedRows(db *gorm.DB) ([]map[string]interface{}, bool) {
+	stmt := db.Statement
+	query := auditModelQuery(db)
+	if stmt.Unscoped {
+		query = query.Unscoped()
+	}
+	conditions := 0
+
+	if where, ok := stmt.Clauses["WHERE"].Expression.(clause.Where); ok && len(where.Exprs) > 0 {
+		query = query.Clauses(clause.Where{Exprs: where.Exprs})
+		conditions++
+	}
+
+	if pk := stmt.Schema.PrioritizedPrimaryField; pk != nil && stmt.ReflectValue.Kind() == reflect.Struct {
+		if value, zero := pk.ValueOf(stmt.Context, stmt.ReflectValue); !zero {
+			query = query.Where(pk.DBName+" = ?", value)
+			conditions++
+		}
+	}
+
+	// Never audit-scan a whole table; GORM refuses such updates anyway
+	if conditions == 0 {
+		return nil, false
+	}
+
+	var rows []map[string]interface{}
+	if err := query.Find(&rows).Error; err != nil {
+		Logger.Warn("Audit could not load affected rows", zap.String("table", stmt.Table), zap.Error(err))
+		return nil, false
+	}
+	return rows, true
+}
+
+// auditSession is a fresh query on the same connection, so audit reads and
+// writes join the caller's transaction and roll back with it.
+func auditSession(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+}
+
+// auditModelQuery is an audit session bound to the statement's model, which
+// lets GORM resolve primary-key placeholders in copied conditions, apply the
+// soft-delete scope and scan columns with the model's field types.
+func auditModelQuery(db *gorm.DB) *gorm.DB {
+	stmt := db.Statement
+	return auditSession(db).Model(reflect.New(stmt.Schema.ModelType).Interface()).Table(stmt.Table)
+}
+
+func writeAuditLog(db *gorm.DB, action string, id uint, oldValue, newValue, changes string) {
+	ctx := db.Statement.Context
+	entry := models.AuditLog{
+		Action:    action,
+		TableName: db.Statement.Table,
+		RecordID:  id,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		Changes:   changes,
+	}
+	if userID, ok := ctx.Value("userID").(uint); ok {
+		entry.UserID = &userID
+	}
+	if apiKeyID, ok := ctx.Value("apiKeyID").(uint); ok {
+		entry.APIKeyID = &apiKeyID
+	}
+	if ip, ok := ctx.Value("clientIP").(string); ok {
+		entry.IPAddress = ip
+	}
+
+	if err := auditSession(db).Create(&entry).Error; err != nil {
+		Logger.Error("Failed to write audit log", zap.String("table", entry.TableName), zap.Uint("record_id", id), zap.Error(err))
+	}
+}
+
+func primaryKeyColumn(db *gorm.DB) string {
+	if pk := db.Statement.Schema.PrioritizedPrimaryField; pk != nil {
+		return pk.DBName
+	}
+	return "id"
+}
+
+func recordID(row map[string]interface{}) uint {
+	switch id := row["id"].(type) {
+	case uint:
+		return id
+	case int64:
+		return uint(id)
+	case int32:
+		return uint(id)
+	case int:
+		return uint(id)
+	case uint64:
+		return uint(id)
+	}
+	return 0
+}
+
+func redact(row map[string]interface{}) map[string]interface{} {
+	for column := range row {
+		if auditRedactedColumns[column] {
+			row[column] = auditRedacted
+		}
+	}
+	return row
+}
+
+func marshalAudit(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// eachRecord calls fn for every struct in a single value or a slice.
+func eachRecord(value reflect.Value, fn func(reflect.Value)) {
+	value = reflect.Indirect(value)
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			fn(reflect.Indirect(value.Index(i)))
+		}
+	case reflect.Struct:
+		fn(value)
+	}
+}
@@ -0,0 +1,249 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+)
+
+// Key of the Postgres advisory lock that serializes appends to the chain.
+const auditChainLockKey = 0x61756469
+
+const (
+	auditVerifyBatch = 1000
+	auditChainBatch  = 500
+)
+
+// Other databases only serialize within this process.
+var auditChainMu sync.Mutex
+
+// AuditChainReport is the result of walking the audit log hash chain.
+type AuditChainReport struct {
+	Valid     bool   `json:"valid"`
+	Checked   int64  `json:"checked"`
+	Unchained int64  `json:"unchained"` // Committed entries the chainer has not linked yet
+	LastID    uint   `json:"last_id"`
+	LastHash  string `json:"last_hash"`
+	BrokenAt  *uint  `json:"broken_at,omitempty"` // First entry whose link does not verify
+	Reason    string `json:"reason,omitempty"`
+}
+
+// MigrateAuditChain gives entries chained before chain_seq existed their
+// position. They were linked in ID order, so the ID is their position.
+func MigrateAuditChain(db *gorm.DB) error {
+	return db.Model(&models.AuditLog{}).Unscoped().
+		Where("chain_seq IS NULL AND hash <> ''").
+		UpdateColumn("chain_seq", gorm.Expr("id")).Error
+}
+
+// StartAuditChaining links new audit entries into the hash chain every
+// interval. Entries are inserted unlinked inside the business transaction
+// and chained here once committed, so writers never wait on the chain.
+func StartAuditChaining(db *gorm.DB, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := ChainAuditLog(db); err != nil {
+				Logger.Error("Failed to chain audit log", zap.Error(err))
+			}
+		}
+	}()
+}
+
+// ChainAuditLog links every committed entry that is not in the chain yet, in
+// ID order, and returns how many it linked. Entries are ordered by chain_seq
+// rather than ID, so one committed late, after higher IDs were chained, is
+// appended to the end instead of breaking the chain.
+func ChainAuditLog(db *gorm.DB) (int64, error) {
+	var linked int64
+	for {
+		var batch int
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if tx.Dialector.Name() == "postgres" {
+				if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditChainLockKey).Error; err != nil {
+					return fmt.Errorf("locking audit chain: %w", err)
+				}
+			} else {
+				auditChainMu.Lock()
+				defer auditChainMu.Unlock()
+			}
+
+			var head models.AuditLog
+			if err := tx.Unscoped().Select("hash", "chain_seq").Where("chain_seq IS NOT NULL").
+				Order("chain_seq desc").Limit(1).Find(&head).Error; err != nil {
+				return fmt.Errorf("reading audit chain head: %w", err)
+			}
+			var seq uint64
+			if head.ChainSeq != nil {
+				seq = *head.ChainSeq
+			}
+
+			var pending []models.AuditLog
+			if err := tx.Unscoped().Where("chain_seq IS NULL").Order("id").Limit(auditChainBatch).Find(&pending).Error; err != nil {
+				return err
+			}
+
+			prevHash := head.Hash
+			for i := range pending {
+				entry := &pending[i]
+				seq++
+				entry.PrevHash = prevHash
+				entry.Hash = AuditHash(entry)
+				result := tx.Session(&gorm.Session{SkipHooks: true}).Model(&models.AuditLog{}).Unscoped().
+					Where("id = ? AND chain_seq IS NULL", entry.ID).
+					UpdateColumns(map[string]interface{}{"prev_hash": entry.PrevHash, "hash": entry.Hash, "chain_seq": seq})
+				if result.Error != nil {
+					return result.Error
+				}
+				if result.RowsAffected == 0 {
+					return fmt.Errorf("audit entry %d was chained concurrently", entry.ID)
+				}
+				prevHash = entry.Hash
+			}
+			batch = len(pending)
+			return nil
+		})
+		if err != nil {
+			return linked, err
+		}
+		linked += int64(batch)
+		if batch < auditChainBatch {
+			return linked, nil
+		}
+	}
+}
+
+// auditChainPrepare runs before every insert into audit_logs. The entry is
+// linked later by ChainAuditLog, so any link it carries is cleared, and its
+// time is cut to what Postgres stores so the hash computed from the stored
+// row matches.
+func auditChainPrepare(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Table != "audit_logs" {
+		return
+	}
+	eachRecord(db.Statement.ReflectValue, func(record reflect.Value) {
+		if !record.CanAddr() {
+			return
+		}
+		entry, ok := record.Addr().Interface().(*models.AuditLog)
+		if !ok {
+			return
+		}
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = time.Now()
+		}
+		entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Microsecond)
+		entry.PrevHash = ""
+		entry.Hash = ""
+		entry.ChainSeq = nil
+	})
+}
+
+// AuditHash is the chain hash of an entry: SHA-256 over its previous hash and
+// every recorded field, each length-prefixed so fields cannot run together.
+func AuditHash(entry *models.AuditLog) string {
+	optionalID := func(id *uint) string {
+		if id == nil {
+			return ""
+		}
+		return strconv.FormatUint(uint64(*id), 10)
+	}
+	fields := []string{
+		entry.PrevHash,
+		entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+		optionalID(entry.UserID),
+		optionalID(entry.APIKeyID),
+		entry.Action,
+		entry.TableName,
+		strconv.FormatUint(uint64(entry.RecordID), 10),
+		entry.OldValue,
+		entry.NewValue,
+		entry.Changes,
+		entry.IPAddress,
+	}
+
+	h := sha256.New()
+	for _, field := range fields {
+		fmt.Fprintf(h, "%d:%s;", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifyAuditChain walks the whole audit log in chain order and reports the
+// first entry that was altered, removed from between its neighbours or
+// soft-deleted.
+func VerifyAuditChain(db *gorm.DB) (*AuditChainReport, error) {
+	return verifyAuditChainFrom(db, 0, "")
+}
+
+// verifyAuditChainFrom checks the entries chained after entry afterID,
+// expecting the first of them to link to prevHash.
+func verifyAuditChainFrom(db *gorm.DB, afterID uint, prevHash string) (*AuditChainReport, error) {
+	report := &AuditChainReport{Valid: true, LastID: afterID, LastHash: prevHash}
+
+	fail := func(id uint, reason string) {
+		report.Valid = false
+		report.BrokenAt = &id
+		report.Reason = reason
+	}
+
+	if err := db.Model(&models.AuditLog{}).Unscoped().Where("chain_seq IS NULL").Count(&report.Unchained).Error; err != nil {
+		return nil, err
+	}
+
+	var afterSeq uint64
+	if afterID > 0 {
+		var start models.AuditLog
+		if err := db.Unscoped().Select("id", "chain_seq").Where("id = ?", afterID).Limit(1).Find(&start).Error; err != nil {
+			return nil, err
+		}
+		if start.ChainSeq == nil {
+			fail(afterID, "entry is no longer in the chain")
+			return report, nil
+		}
+		afterSeq = *start.ChainSeq
+	}
+
+	for {
+		var batch []models.AuditLog
+		if err := db.Unscoped().Where("chain_seq > ?", afterSeq).Order("chain_seq").Limit(auditVerifyBatch).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		for i := range batch {
+			entry := &batch[i]
+			var reason string
+			switch {
+			case entry.PrevHash != report.LastHash:
+				reason = fmt.Sprintf("does not link to entry %d; entries were removed or reordered", report.LastID)
+			case AuditHash(entry) != entry.Hash:
+				reason = "content does not match its hash"
+			case entry.DeletedAt.Valid:
+				reason = "entry was soft-deleted"
+			}
+			if reason != "" {
+				fail(entry.ID, reason)
+				return report, nil
+			}
+
+			report.Checked++
+			report.LastID = entry.ID
+			report.LastHash = entry.Hash
+			afterSeq = *entry.ChainSeq
+		}
+		if len(batch) < auditVerifyBatch {
+			return report, nil
+		}
+	}
+}
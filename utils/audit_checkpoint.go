@@ -0,0 +1,270 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+)
+
+const auditCheckpointSubject = "audit-checkpoint"
+
+var auditCheckpointKey []byte
+
+// InitAuditCheckpointKey loads the checkpoint signing secret from path,
+// creating a random one on first start. The file must be kept for as long
+// as the checkpoints it signed need to verify.
+func InitAuditCheckpointKey(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		data = []byte(hex.EncodeToString(secret))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		// O_EXCL so two instances starting together cannot overwrite each other
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if errors.Is(err, os.ErrExist) {
+			return InitAuditCheckpointKey(path)
+		}
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err := file.Write(append(data, '\n')); err != nil {
+			return err
+		}
+		LogInfo("Audit checkpoint key created", zap.String("path", path))
+	} else if err != nil {
+		return err
+	}
+
+	key := bytes.TrimSpace(data)
+	if len(key) < 32 {
+		return fmt.Errorf("audit checkpoint key in %q is shorter than 32 bytes", path)
+	}
+	auditCheckpointKey = key
+	return nil
+}
+
+// auditCheckpointVerificationKey accepts HMAC signatures made with the
+// checkpoint key and, for checkpoints written before it existed, signatures
+// made with a JWT key (retired keys stay loadable as public keys).
+func auditCheckpointVerificationKey(token *jwt.Token) (interface{}, error) {
+	if token.Method != jwt.SigningMethodHS256 {
+		return verificationKey(token)
+	}
+	if len(auditCheckpointKey) == 0 {
+		return nil, errors.New("audit checkpoint key not initialized")
+	}
+	return auditCheckpointKey, nil
+}
+
+// AuditCheckpoint records the head of the audit chain at a point in time.
+// Signature is a JWT over the same fields, signed with HMAC-SHA256 using the
+// audit checkpoint key. That key is kept apart from the JWT keys so
+// rotating those never invalidates old checkpoints. A chain rewritten after
+// a checkpoint no longer matches it.
+type AuditCheckpoint struct {
+	CreatedAt time.Time `json:"created_at"`
+	LastID    uint      `json:"last_id"`
+	LastHash  string    `json:"last_hash"`
+	Entries   int64     `json:"entries"` // Chained entries up to and including LastID
+	Signature string    `json:"signature"`
+}
+
+type auditCheckpointClaims struct {
+	LastID   uint   `json:"last_id"`
+	LastHash string `json:"last_hash"`
+	Entries  int64  `json:"entries"`
+	jwt.StandardClaims
+}
+
+// AuditCheckpointReport is the result of checking a checkpoint file.
+type AuditCheckpointReport struct {
+	Valid    bool   `json:"valid"`
+	Checked  int    `json:"checked"`
+	BrokenAt int    `json:"broken_at,omitempty"` // Line number in the checkpoint file
+	Reason   string `json:"reason,omitempty"`
+}
+
+// StartAuditCheckpoints appends a checkpoint to path every interval. An
+// interval of zero disables checkpoints.
+func StartAuditCheckpoints(db *gorm.DB, path string, interval time.Duration) {
+	if interval <= 0 || path == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			checkpoint, err := WriteAuditCheckpoint(db, path)
+			if err != nil {
+				Logger.Error("Failed to write audit checkpoint", zap.String("path", path), zap.Error(err))
+				continue
+			}
+			if checkpoint != nil {
+				LogInfo("Audit checkpoint written", zap.Uint("last_id", checkpoint.LastID), zap.Int64("entries", checkpoint.Entries))
+			}
+		}
+	}()
+}
+
+// WriteAuditCheckpoint verifies the entries added since the last checkpoint
+// in path and appends a new signed checkpoint for the current chain head. It
+// returns nil without writing when nothing was added, and refuses to sign a
+// chain that does not verify.
+func WriteAuditCheckpoint(db *gorm.DB, path string) (*AuditCheckpoint, error) {
+	checkpoints, err := readAuditCheckpoints(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var report *AuditChainReport
+	var entries int64
+	if len(checkpoints) == 0 {
+		report, err = VerifyAuditChain(db)
+	} else {
+		last := checkpoints[len(checkpoints)-1]
+		if err := verifyAuditCheckpoint(db, last); err != nil {
+			return nil, fmt.Errorf("last checkpoint no longer verifies: %w", err)
+		}
+		entries = last.Entries
+		report, err = verifyAuditChainFrom(db, last.LastID, last.LastHash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !report.Valid {
+		return nil, fmt.Errorf("audit chain broken at entry %d: %s", *report.BrokenAt, report.Reason)
+	}
+	if report.Checked == 0 {
+		return nil, nil
+	}
+
+	checkpoint := AuditCheckpoint{
+		CreatedAt: time.Now().UTC(),
+		LastID:    report.LastID,
+		LastHash:  report.LastHash,
+		Entries:   entries + report.Checked,
+	}
+	if len(auditCheckpointKey) == 0 {
+		return nil, errors.New("audit checkpoint key not initialized")
+	}
+	checkpoint.Signature, err = jwt.NewWithClaims(jwt.SigningMethodHS256, &auditCheckpointClaims{
+		LastID:   checkpoint.LastID,
+		LastHash: checkpoint.LastHash,
+		Entries:  checkpoint.Entries,
+		StandardClaims: jwt.StandardClaims{
+			Subject:  auditCheckpointSubject,
+			IssuedAt: checkpoint.CreatedAt.Unix(),
+		},
+	}).SignedString(auditCheckpointKey)
+	if err != nil {
+		return nil, err
+	}
+
+	line, err := json.Marshal(checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// VerifyAuditCheckpoints checks the signature of every checkpoint in path
+// and that the entry it names still carries the signed hash. This catches
+// truncation and wholesale rewrites of the chain that VerifyAuditChain alone
+// cannot see.
+func VerifyAuditCheckpoints(db *gorm.DB, path string) (*AuditCheckpointReport, error) {
+	checkpoints, err := readAuditCheckpoints(path)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AuditCheckpointReport{Valid: true}
+	for i, checkpoint := range checkpoints {
+		if err := verifyAuditCheckpoint(db, checkpoint); err != nil {
+			report.Valid = false
+			report.BrokenAt = i + 1
+			report.Reason = err.Error()
+			break
+		}
+		report.Checked++
+	}
+	return report, nil
+}
+
+func verifyAuditCheckpoint(db *gorm.DB, checkpoint AuditCheckpoint) error {
+	claims := &auditCheckpointClaims{}
+	token, err := jwt.ParseWithClaims(checkpoint.Signature, claims, auditCheckpointVerificationKey)
+	if err != nil || !token.Valid {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	if claims.Subject != auditCheckpointSubject || claims.LastID != checkpoint.LastID ||
+		claims.LastHash != checkpoint.LastHash || claims.Entries != checkpoint.Entries {
+		return errors.New("checkpoint fields do not match their signature")
+	}
+
+	var entry models.AuditLog
+	result := db.Unscoped().Select("id", "hash").Where("id = ?", checkpoint.LastID).Limit(1).Find(&entry)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("entry %d no longer exists", checkpoint.LastID)
+	}
+	if entry.Hash != checkpoint.LastHash {
+		return fmt.Errorf("entry %d hash differs from the checkpoint", checkpoint.LastID)
+	}
+	return nil
+}
+
+func readAuditCheckpoints(path string) ([]AuditCheckpoint, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var checkpoints []AuditCheckpoint
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var checkpoint AuditCheckpoint
+		if err := json.Unmarshal(scanner.Bytes(), &checkpoint); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	return checkpoints, scanner.Err()
+}
@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+)
+
+// Overflow policies for a full AuditWriter buffer.
+const (
+	AuditOverflowBlock = "block" // Wait for room; requests slow down but no entry is lost
+	AuditOverflowDrop  = "drop"  // Discard the entry and count it
+)
+
+// Dropped entries are logged at most this often.
+const auditDropReportInterval = 10 * time.Second
+
+// AuditWriter buffers audit entries in memory and inserts them in batches
+// from a background goroutine, keeping the database off the request path.
+type AuditWriter struct {
+	db            *gorm.DB
+	entries       chan models.AuditLog
+	batchSize     int
+	flushInterval time.Duration
+	dropWhenFull  bool
+
+	dropped uint64 // Entries discarded because the buffer was full or closed
+	failed  uint64 // Entries lost to failed inserts
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+func NewAuditWriter(db *gorm.DB, bufferSize, batchSize int, flushInterval time.Duration, overflow string) *AuditWriter {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if flushInterval <= 0 {
+		flushInterval = time.Second
+	}
+
+	w := &AuditWriter{
+		db:            db,
+		entries:       make(chan models.AuditLog, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		dropWhenFull:  overflow == AuditOverflowDrop,
+		done:          make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write queues an entry. With the drop policy it never blocks.
+func (w *AuditWriter) Write(entry models.AuditLog) {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		atomic.AddUint64(&w.dropped, 1)
+		return
+	}
+
+	if !w.dropWhenFull {
+		w.entries <- entry
+		return
+	}
+	select {
+	case w.entries <- entry:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+}
+
+// Dropped returns how many entries were discarded since start.
+func (w *AuditWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Failed returns how many entries were lost to failed inserts since start.
+func (w *AuditWriter) Failed() uint64 {
+	return atomic.LoadUint64(&w.failed)
+}
+
+// Close stops accepting entries and waits until everything buffered has
+// been written, or ctx ends.
+func (w *AuditWriter) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.entries)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *AuditWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.AuditLog, 0, w.batchSize)
+	var reportedDrops uint64
+	var reportedAt time.Time
+	flush := func() {
+		if drops := w.Dropped(); drops > reportedDrops && time.Since(reportedAt) >= auditDropReportInterval {
+			Logger.Warn("Audit buffer full, entries dropped", zap.Uint64("dropped", drops-reportedDrops), zap.Uint64("total_dropped", drops))
+			reportedDrops, reportedAt = drops, time.Now()
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := w.db.Create(&batch).Error; err != nil {
+			atomic.AddUint64(&w.failed, uint64(len(batch)))
+			Logger.Error("Failed to write audit batch", zap.Int("entries", len(batch)), zap.Error(err))
+		}
+		batch = make([]models.AuditLog, 0, w.batchSize)
+	}
+
+	for {
+		select {
+		case entry, ok := <-w.entries:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= w.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
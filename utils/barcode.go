@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/qr"
+	"github.com/go-pdf/fpdf"
+)
+
+// Supported symbologies for printed labels.
+const (
+	SymbologyCode128 = "code128"
+	SymbologyQR      = "qr"
+)
+
+// Prefixes of the codes printed on item and box labels. Serial numbers are
+// printed as they are, so manufacturer labels scan the same way.
+const (
+	ItemCodePrefix = "ITEM-"
+	BoxCodePrefix  = "BOX_" // Same format as the warranty spreadsheet import
+)
+
+// Code128 bars are drawn this many modules high.
+const code128BarHeight = 40
+
+// ItemCode is the label code of an item.
+func ItemCode(id uint) string {
+	return fmt.Sprintf("%s%d", ItemCodePrefix, id)
+}
+
+// BoxCode is the label code of a box, e.g. "BOX_07".
+func BoxCode(id uint) string {
+	return fmt.Sprintf("%s%02d", BoxCodePrefix, id)
+}
+
+// ParseItemCode returns the item ID of an item label code.
+func ParseItemCode(code string) (uint, bool) {
+	return parseCodeID(code, ItemCodePrefix)
+}
+
+// ParseBoxCode returns the box ID of a box label code.
+func ParseBoxCode(code string) (uint, bool) {
+	return parseCodeID(code, BoxCodePrefix)
+}
+
+func parseCodeID(code, prefix string) (uint, bool) {
+	if !strings.HasPrefix(strings.ToUpper(code), prefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(code[len(prefix):], 10, 32)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// Label is one entry of a label sheet.
+type Label struct {
+	Code     string // Encoded in the barcode and printed below it
+	Title    string
+	Subtitle string
+}
+
+// EncodeBarcode encodes code as a Code128 or QR symbol.
+func EncodeBarcode(code, symbology string) (barcode.Barcode, error) {
+	if code == "" {
+		return nil, fmt.Errorf("nothing to encode")
+	}
+	switch symbology {
+	case SymbologyCode128:
+		return code128.Encode(code)
+	case SymbologyQR:
+		return qr.Encode(code, qr.M, qr.Auto)
+	default:
+		return nil, fmt.Errorf("unsupported symbology %q", symbology)
+	}
+}
+
+// barcodeGrid describes a symbol in modules, including its quiet zone.
+type barcodeGrid struct {
+	bc           barcode.Barcode
+	cols, rows   int
+	quietX       int
+	quietY       int
+	oneDimension bool
+}
+
+func newBarcodeGrid(bc barcode.Barcode) barcodeGrid {
+	bounds := bc.Bounds()
+	g := barcodeGrid{bc: bc, cols: bounds.Dx(), rows: bounds.Dy(), quietX: 4, quietY: 4}
+	if g.rows == 1 {
+		g.oneDimension = true
+		g.rows = code128BarHeight
+		g.quietX, g.quietY = 10, 2
+	}
+	return g
+}
+
+func (g barcodeGrid) width() int  { return g.cols + 2*g.quietX }
+func (g barcodeGrid) height() int { return g.rows + 2*g.quietY }
+
+// dark reports whether the module at (x, y) of the symbol is a bar.
+func (g barcodeGrid) dark(x, y int) bool {
+	if g.oneDimension {
+		y = 0
+	}
+	bounds := g.bc.Bounds()
+	r, _, _, _ := g.bc.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+	return r < 0x8000
+}
+
+// WriteBarcodePNG renders code as a PNG with scale pixels per module.
+func WriteBarcodePNG(w io.Writer, code, symbology string, scale int) error {
+	bc, err := EncodeBarcode(code, symbology)
+	if err != nil {
+		return err
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	g := newBarcodeGrid(bc)
+
+	img := image.NewPaletted(image.Rect(0, 0, g.width()*scale, g.height()*scale), color.Palette{color.White, color.Black})
+	for y := 0; y < g.rows; y++ {
+		for x := 0; x < g.cols; x++ {
+			if !g.dark(x, y) {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				row := (g.quietY+y)*scale + py
+				for px := 0; px < scale; px++ {
+					img.SetColorIndex((g.quietX+x)*scale+px, row, 1)
+				}
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// WriteBarcodeSVG renders code as an SVG with scale user units per module.
+// Adjacent bars are merged into one rectangle to keep the document small.
+func WriteBarcodeSVG(w io.Writer, code, symbology string, scale int) error {
+	bc, err := EncodeBarcode(code, symbology)
+	if err != nil {
+		return err
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	g := newBarcodeGrid(bc)
+
+	var path strings.Builder
+	rowHeight, rows := 1, g.rows
+	if g.oneDimension {
+		rowHeight, rows = g.rows, 1
+	}
+	for y := 0; y < rows; y++ {
+		for x := 0; x < g.cols; {
+			if !g.dark(x, y) {
+				x++
+				continue
+			}
+			start := x
+			for x < g.cols && g.dark(x, y) {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv%dh-%dz", (g.quietX+start)*scale, (g.quietY+y*rowHeight)*scale, (x-start)*scale, rowHeight*scale, (x-start)*scale)
+		}
+	}
+
+	width, height := g.width()*scale, g.height()*scale
+	_, err = fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="%s"/></svg>`,
+		width, height, width, height, width, height, path.String())
+	return err
+}
+
+// Label sheet layout on A4 paper, in millimetres: 3 x 8 labels of 70 x 37.
+const (
+	labelColumns = 3
+	labelRows    = 8
+	labelWidth   = 70.0
+	labelHeight  = 37.0
+	labelTopGap  = (297.0 - labelRows*labelHeight) / 2
+	labelPadding = 3.0
+	labelImageH  = 20.0
+)
+
+// WriteLabelSheetPDF lays labels out on A4 sheets, each with its barcode,
+// title, code and subtitle.
+func WriteLabelSheetPDF(w io.Writer, labels []Label, symbology string) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	translate := pdf.UnicodeTranslatorFromDescriptor("")
+
+	perPage := labelColumns * labelRows
+	for i, label := range labels {
+		if i%perPage == 0 {
+			pdf.AddPage()
+		}
+		col, row := i%labelColumns, (i%perPage)/labelColumns
+		x := float64(col) * labelWidth
+		y := labelTopGap + float64(row)*labelHeight
+
+		var img bytes.Buffer
+		if err := WriteBarcodePNG(&img, label.Code, symbology, 4); err != nil {
+			return fmt.Errorf("label %q: %w", label.Code, err)
+		}
+		name := fmt.Sprintf("label-%d", i)
+		info := pdf.RegisterImageOptionsReader(name, fpdf.ImageOptions{ImageType: "PNG"}, &img)
+		if err := pdf.Error(); err != nil {
+			return err
+		}
+
+		// Fit the symbol into the image area, keeping its aspect ratio
+		maxW := labelWidth - 2*labelPadding
+		imgW, imgH := maxW, maxW*info.Height()/info.Width()
+		if imgH > labelImageH {
+			imgW, imgH = labelImageH*info.Width()/info.Height(), labelImageH
+		}
+		pdf.ImageOptions(name, x+(labelWidth-imgW)/2, y+labelPadding, imgW, imgH, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+
+		textY := y + labelPadding + labelImageH + 1
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.SetXY(x+labelPadding, textY)
+		pdf.CellFormat(maxW, 4, fitText(pdf, translate(label.Title), maxW), "", 2, "C", false, 0, "")
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetX(x + labelPadding)
+		pdf.CellFormat(maxW, 3.5, fitText(pdf, translate(label.Code), maxW), "", 2, "C", false, 0, "")
+		if label.Subtitle != "" {
+			pdf.SetX(x + labelPadding)
+			pdf.CellFormat(maxW, 3.5, fitText(pdf, translate(label.Subtitle), maxW), "", 2, "C", false, 0, "")
+		}
+	}
+	if len(labels) == 0 {
+		pdf.AddPage()
+	}
+	return pdf.Output(w)
+}
+
+// fitText shortens s until it fits in width with the current font.
+func fitText(pdf *fpdf.Fpdf, s string, width float64) string {
+	if pdf.GetStringWidth(s) <= width {
+		return s
+	}
+	for len(s) > 0 && pdf.GetStringWidth(s+"...") > width {
+		s = s[:len(s)-1]
+	}
+	return s + "..."
+}
@@ -1,53 +1,86 @@
 package utils
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
-	"warehouse-store/config"
 )
 
-var jwtSecret = []byte(config.LoadConfig().JWTSecret)
-
 type Claims struct {
 	UserID   uint   `json:"user_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	// TokenVersion must match models.User.TokenVersion for the token to be accepted
+	TokenVersion uint `json:"token_version"`
+	// MFA records that the session was established with a second factor
+	MFA bool `json:"mfa,omitempty"`
+	// Purpose is empty for access and refresh tokens; other values mark
+	// single-purpose tokens that must not be accepted as access tokens
+	Purpose string `json:"purpose,omitempty"`
 	jwt.StandardClaims
 }
 
-func GenerateToken(userID uint, username, role string) (string, error) {
+const MFAChallengePurpose = "mfa_challenge"
+
+func GenerateToken(userID uint, username, role string, tokenVersion uint, mfa bool) (string, error) {
 	expirationTime := time.Now().Add(15 * time.Minute) // Short-lived access token
 	// expirationTime := time.Now().Add(24 * time.Hour) // Short-lived access token
 	claims := &Claims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
+		UserID:       userID,
+		Username:     username,
+		Role:         role,
+		TokenVersion: tokenVersion,
+		MFA:          mfa,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return signToken(claims)
 }
 
-func GenerateRefreshToken(userID uint) (string, error) {
+func GenerateRefreshToken(userID uint, tokenVersion uint, mfa bool) (string, error) {
 	expirationTime := time.Now().Add(7 * 24 * time.Hour) // Long-lived refresh token
 	claims := &Claims{
-		UserID: userID,
+		UserID:       userID,
+		TokenVersion: tokenVersion,
+		MFA:          mfa,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expirationTime.Unix(),
+		},
+	}
+	return signToken(claims)
+}
+
+// GenerateMFAChallengeToken proves the password step of a two-step login
+// succeeded; it is exchanged together with a TOTP code for real tokens.
+func GenerateMFAChallengeToken(userID uint, tokenVersion uint) (string, error) {
+	expirationTime := time.Now().Add(5 * time.Minute)
+	claims := &Claims{
+		UserID:       userID,
+		TokenVersion: tokenVersion,
+		Purpose:      MFAChallengePurpose,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return signToken(claims)
+}
+
+// signToken signs with the active key and names it in the kid header so
+// tokens stay verifiable after the active key is rotated.
+func signToken(claims jwt.Claims) (string, error) {
+	if jwtKeys == nil || jwtKeys.active == nil {
+		return "", fmt.Errorf("JWT keys not initialized")
+	}
+	token := jwt.NewWithClaims(jwtKeys.active.Method, claims)
+	token.Header["kid"] = jwtKeys.active.Kid
+	return token.SignedString(jwtKeys.active.Private)
 }
 
 func ParseToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey)
 
 	if err != nil {
 		return nil, err
@@ -57,3 +90,20 @@ func ParseToken(tokenString string) (*Claims, error) {
 	}
 	return claims, nil
 }
+
+// verificationKey finds the public key named by the kid header of a token.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	if jwtKeys == nil {
+		return nil, fmt.Errorf("JWT keys not initialized")
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := jwtKeys.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	// Never let the token pick the algorithm for a key
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q", token.Method.Alg())
+	}
+	return key.Public, nil
+}
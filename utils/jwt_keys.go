@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.uber.org/zap"
+	"warehouse-store/config"
+)
+
+// signingKey is one entry of the key set. Retired keys may be loaded from a
+// public key only: they still verify tokens issued before a rotation but are
+// never used to sign.
+type signingKey struct {
+	Kid     string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+type keySet struct {
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+var jwtKeys *keySet
+
+// InitJWT loads every PEM key in cfg.JWTKeysDir, using the file name without
+// extension as the kid. cfg.JWTActiveKID selects the signing key; when empty
+// the last private key in name order is used, so date-named files rotate
+// naturally. Outside production an ephemeral key is generated when none are
+// configured; in production that is an error.
+func InitJWT(cfg *config.Config) error {
+	set, err := loadKeySet(cfg.JWTKeysDir, cfg.JWTActiveKID)
+	if err != nil {
+		return err
+	}
+
+	if set.active == nil {
+		if cfg.AppEnv == "production" {
+			return fmt.Errorf("no JWT signing key configured in %q", cfg.JWTKeysDir)
+		}
+		key, err := ephemeralSigningKey()
+		if err != nil {
+			return err
+		}
+		Logger.Warn("No JWT signing key configured, using an ephemeral key; tokens will not survive a restart",
+			zap.String("kid", key.Kid))
+		set.keys[key.Kid] = key
+		set.active = key
+	}
+
+	jwtKeys = set
+	LogInfo("JWT keys loaded", zap.String("active_kid", set.active.Kid), zap.Int("keys", len(set.keys)))
+	return nil
+}
+
+func loadKeySet(dir, activeKID string) (*keySet, error) {
+	set := &keySet{keys: make(map[string]*signingKey)}
+	if dir == "" {
+		return set, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		kid := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(file), ".pem"), ".pub")
+		key, err := loadSigningKey(file, kid)
+		if err != nil {
+			return nil, fmt.Errorf("loading JWT key %s: %w", file, err)
+		}
+		if existing, ok := set.keys[kid]; ok && existing.Private != nil {
+			continue // A private key already covers this kid
+		}
+		set.keys[kid] = key
+		if key.Private != nil && activeKID == "" {
+			set.active = key
+		}
+	}
+
+	if activeKID != "" {
+		key, ok := set.keys[activeKID]
+		if !ok || key.Private == nil {
+			return nil, fmt.Errorf("JWT_ACTIVE_KID %q has no private key in %q", activeKID, dir)
+		}
+		set.active = key
+	}
+	return set, nil
+}
+
+func loadSigningKey(path, kid string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{Kid: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.Private, key.Public = SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.Public = SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", parsed)
+	}
+	return key, nil
+}
+
+func ephemeralSigningKey() (*signingKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	suffix, err := GenerateRandomToken(4)
+	if err != nil {
+		return nil, err
+	}
+	return &signingKey{Kid: "ephemeral-" + suffix, Method: SigningMethodEdDSA, Private: private, Public: public}, nil
+}
+
+// JWKS returns the public half of every loaded key as a JSON Web Key Set so
+// other services can verify tokens.
+func JWKS() map[string]interface{} {
+	keys := make([]map[string]interface{}, 0)
+	if jwtKeys == nil {
+		return map[string]interface{}{"keys": keys}
+	}
+
+	kids := make([]string, 0, len(jwtKeys.keys))
+	for kid := range jwtKeys.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	for _, kid := range kids {
+		key := jwtKeys.keys[kid]
+		jwk := map[string]interface{}{
+			"kid": key.Kid,
+			"use": "sig",
+			"alg": key.Method.Alg(),
+		}
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		keys = append(keys, jwk)
+	}
+	return map[string]interface{}{"keys": keys}
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// LoginLimiter counts failed logins per key (username or client IP). Each
+// failure past the free attempts adds an exponentially growing delay before
+// the next attempt is accepted, and reaching MaxAttempts locks the key out for
+// LockoutDuration. Counters live in Redis when available so every instance
+// shares them, with an in-memory fallback.
+type LoginLimiter struct {
+	MaxAttempts     int
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	LockoutDuration time.Duration
+
+	mu    sync.Mutex
+	local map[string]*loginAttempts
+}
+
+type loginAttempts struct {
+	Failures    int
+	LockedUntil time.Time
+	ExpiresAt   time.Time
+}
+
+func NewLoginLimiter(maxAttempts int, lockoutDuration time.Duration) *LoginLimiter {
+	return &LoginLimiter{
+		MaxAttempts:     maxAttempts,
+		FreeAttempts:    2,
+		BaseDelay:       time.Second,
+		LockoutDuration: lockoutDuration,
+		local:           make(map[string]*loginAttempts),
+	}
+}
+
+func loginAttemptsKey(key string) string {
+	return "login_attempts:" + key
+}
+
+// RetryAfter reports how long the caller must wait before key may try again;
+// zero means the attempt is allowed.
+func (l *LoginLimiter) RetryAfter(ctx context.Context, key string) time.Duration {
+	state := l.load(ctx, key)
+	if wait := time.Until(state.LockedUntil); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// RegisterFailure records a failed attempt and returns the resulting wait.
+func (l *LoginLimiter) RegisterFailure(ctx context.Context, key string) time.Duration {
+	if RedisClient() != nil {
+		wait, err := l.registerFailureRedis(ctx, key)
+		if err == nil {
+			return wait
+		}
+		Logger.Warn("Redis login limiter unavailable, using in-memory counters", zap.Error(err))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruneLocal()
+	state, ok := l.local[key]
+	if !ok || time.Now().After(state.ExpiresAt) {
+		state = &loginAttempts{}
+		l.local[key] = state
+	}
+	state.Failures++
+	state.LockedUntil = time.Now().Add(l.delayFor(state.Failures))
+	state.ExpiresAt = time.Now().Add(l.LockoutDuration)
+	return time.Until(state.LockedUntil)
+}
+
+// Reset clears the counters for key, e.g. after a successful login or an
+// admin unlock.
+func (l *LoginLimiter) Reset(ctx context.Context, key string) {
+	if RedisClient() != nil {
+		if err := RedisClient().Del(ctx, loginAttemptsKey(key)).Err(); err != nil {
+			Logger.Warn("Failed to reset Redis login attempts", zap.Error(err))
+		}
+	}
+	l.mu.Lock()
+	delete(l.local, key)
+	l.mu.Unlock()
+}
+
+// delayFor is the wait imposed after the given number of consecutive failures.
+func (l *LoginLimiter) delayFor(failures int) time.Duration {
+	if failures >= l.MaxAttempts {
+		return l.LockoutDuration
+	}
+	if failures <= l.FreeAttempts {
+		return 0
+	}
+	delay := l.BaseDelay << uint(failures-l.FreeAttempts-1)
+	if delay > l.LockoutDuration {
+		return l.LockoutDuration
+	}
+	return delay
+}
+
+func (l *LoginLimiter) registerFailureRedis(ctx context.Context, key string) (time.Duration, error) {
+	redisKey := loginAttemptsKey(key)
+	failures, err := RedisClient().HIncrBy(ctx, redisKey, "failures", 1).Result()
+	if err != nil {
+		return 0, err
+	}
+	lockedUntil := time.Now().Add(l.delayFor(int(failures)))
+	pipe := RedisClient().TxPipeline()
+	pipe.HSet(ctx, redisKey, "locked_until", lockedUntil.Unix())
+	pipe.Expire(ctx, redisKey, l.LockoutDuration)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return time.Until(lockedUntil), nil
+}
+
+func (l *LoginLimiter) load(ctx context.Context, key string) loginAttempts {
+	if RedisClient() != nil {
+		values, err := RedisClient().HGetAll(ctx, loginAttemptsKey(key)).Result()
+		if err == nil {
+			var state loginAttempts
+			state.Failures, _ = strconv.Atoi(values["failures"])
+			if unix, err := strconv.ParseInt(values["locked_until"], 10, 64); err == nil {
+				state.LockedUntil = time.Unix(unix, 0)
+			}
+			return state
+		}
+		Logger.Warn("Redis login limiter unavailable, using in-memory counters", zap.Error(err))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state, ok := l.local[key]
+	if !ok || time.Now().After(state.ExpiresAt) {
+		return loginAttempts{}
+	}
+	return *state
+}
+
+// pruneLocal drops expired in-memory records so the map cannot grow without
+// bound while Redis is down. The caller must hold l.mu.
+func (l *LoginLimiter) pruneLocal() {
+	if len(l.local) < 10000 {
+		return
+	}
+	now := time.Now()
+	for key, state := range l.local {
+		if now.After(state.ExpiresAt) {
+			delete(l.local, key)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+	"warehouse-store/config"
+)
+
+//go:embed templates/*.tmpl
+var mailTemplateFS embed.FS
+
+// MailSender delivers a plain-text email.
+type MailSender interface {
+	Send(to, subject, body string) error
+}
+
+var Mailer MailSender
+
+// InitMailer selects the SMTP sender, or the log sender used for development
+// and testing when MAIL_DRIVER is not "smtp".
+func InitMailer(cfg *config.Config) {
+	if cfg.MailDriver == "smtp" {
+		Mailer = &SMTPMailSender{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		}
+		return
+	}
+	Mailer = &LogMailSender{Dir: cfg.MailLogDir, From: cfg.MailFrom}
+}
+
+type SMTPMailSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPMailSender) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	return smtp.SendMail(s.Host+":"+s.Port, auth, s.From, []string{to}, buildMessage(s.From, to, subject, body))
+}
+
+// LogMailSender writes each email to its own file under Dir and logs it,
+// so reset links can be picked up locally without a mail server.
+type LogMailSender struct {
+	Dir  string
+	From string
+}
+
+func (s *LogMailSender) Send(to, subject, body string) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s_%s.eml", time.Now().Format("20060102T150405.000000000"), sanitizeFileName(to))
+	path := filepath.Join(s.Dir, name)
+	if err := os.WriteFile(path, buildMessage(s.From, to, subject, body), 0600); err != nil {
+		return err
+	}
+	LogInfo("Email written to file", zap.String("to", to), zap.String("subject", subject), zap.String("path", path))
+	return nil
+}
+
+// SendTemplateMail renders the subject and body blocks of the named template
+// and sends the result through the configured Mailer.
+func SendTemplateMail(to, name string, data interface{}) error {
+	if Mailer == nil {
+		return fmt.Errorf("mailer not initialized")
+	}
+	// Each file defines its own "subject" and "body", so parse them separately
+	tmpl, err := template.ParseFS(mailTemplateFS, "templates/"+name+".tmpl")
+	if err != nil {
+		return fmt.Errorf("unknown mail template %q: %w", name, err)
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return err
+	}
+	return Mailer.Send(to, strings.TrimSpace(subject.String()), body.String())
+}
+
+func buildMessage(from, to, subject, body string) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+	msg.WriteString(body)
+	return msg.Bytes()
+}
+
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
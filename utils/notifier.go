@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"warehouse-store/config"
+)
+
+// Notification is an event people should hear about, such as an item
+// running low on stock.
+type Notification struct {
+	Event     string                 `json:"event"` // e.g. "low_stock", "restocked"
+	Subject   string                 `json:"subject"`
+	Message   string                 `json:"message"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// Notifier delivers notifications over one channel.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+var Notifiers []Notifier
+
+// InitNotifiers enables the channels listed in NOTIFY_CHANNELS: "log",
+// "email" (to NOTIFY_EMAILS through the Mailer) and "webhook" (JSON POST to
+// NOTIFY_WEBHOOK_URL).
+func InitNotifiers(cfg *config.Config) {
+	Notifiers = nil
+	for _, channel := range cfg.NotifyChannels {
+		switch strings.TrimSpace(channel) {
+		case "":
+		case "log":
+			Notifiers = append(Notifiers, &LogNotifier{})
+		case "email":
+			Notifiers = append(Notifiers, &EmailNotifier{To: nonEmpty(cfg.NotifyEmails)})
+		case "webhook":
+			Notifiers = append(Notifiers, &WebhookNotifier{URL: cfg.NotifyWebhookURL, Client: &http.Client{Timeout: 10 * time.Second}})
+		default:
+			Logger.Warn("Unknown notification channel", zap.String("channel", channel))
+		}
+	}
+}
+
+// Notify hands n to every configured channel in the background, so callers
+// on the request path never wait for delivery. Failures are logged.
+func Notify(n Notification) {
+	if n.CreatedAt.IsZero() {
+		n.CreatedAt = time.Now()
+	}
+	for _, notifier := range Notifiers {
+		go func(notifier Notifier) {
+			if err := notifier.Notify(n); err != nil {
+				Logger.Error("Failed to deliver notification", zap.String("event", n.Event), zap.String("channel", fmt.Sprintf("%T", notifier)), zap.Error(err))
+			}
+		}(notifier)
+	}
+}
+
+// LogNotifier writes notifications to the application log.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(n Notification) error {
+	Logger.Warn(n.Subject, zap.String("event", n.Event), zap.String("message", n.Message), zap.Any("data", n.Data))
+	return nil
+}
+
+// EmailNotifier mails notifications with the "notification" template.
+type EmailNotifier struct {
+	To []string
+}
+
+func (e *EmailNotifier) Notify(n Notification) error {
+	for _, to := range e.To {
+		if err := SendTemplateMail(to, "notification", n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WebhookNotifier posts notifications as JSON, e.g. to a chat integration.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (w *WebhookNotifier) Notify(n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	resp, err := w.Client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+func nonEmpty(values []string) []string {
+	var result []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
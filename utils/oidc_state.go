@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// OIDCLoginState is remembered between the redirect to the identity provider
+// and its callback, keyed by the state parameter.
+type OIDCLoginState struct {
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	// LinkUserID is set when a signed-in user links their account instead
+	// of logging in
+	LinkUserID uint `json:"link_user_id,omitempty"`
+}
+
+const oidcStateTTL = 10 * time.Minute
+
+var (
+	oidcStateMu    sync.Mutex
+	oidcStateLocal = make(map[string]oidcStateEntry)
+)
+
+type oidcStateEntry struct {
+	State     OIDCLoginState
+	ExpiresAt time.Time
+}
+
+func oidcStateKey(state string) string {
+	return "oidc_state:" + state
+}
+
+// SaveOIDCState stores login state in Redis, or in memory when Redis is
+// unavailable (which then only works with a single instance).
+func SaveOIDCState(ctx context.Context, state string, data OIDCLoginState) error {
+	if RedisClient() != nil {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		err = RedisClient().Set(ctx, oidcStateKey(state), payload, oidcStateTTL).Err()
+		if err == nil {
+			return nil
+		}
+		Logger.Warn("Failed to store OIDC state in Redis, using memory", zap.Error(err))
+	}
+
+	oidcStateMu.Lock()
+	defer oidcStateMu.Unlock()
+	now := time.Now()
+	for key, entry := range oidcStateLocal {
+		if now.After(entry.ExpiresAt) {
+			delete(oidcStateLocal, key)
+		}
+	}
+	oidcStateLocal[state] = oidcStateEntry{State: data, ExpiresAt: now.Add(oidcStateTTL)}
+	return nil
+}
+
+// TakeOIDCState returns and deletes the state so a callback cannot be replayed.
+func TakeOIDCState(ctx context.Context, state string) (OIDCLoginState, error) {
+	var data OIDCLoginState
+	if RedisClient() != nil {
+		pipe := RedisClient().TxPipeline()
+		get := pipe.Get(ctx, oidcStateKey(state))
+		pipe.Del(ctx, oidcStateKey(state))
+		if _, err := pipe.Exec(ctx); err == nil {
+			if err := json.Unmarshal([]byte(get.Val()), &data); err != nil {
+				return data, err
+			}
+			return data, nil
+		}
+	}
+
+	oidcStateMu.Lock()
+	defer oidcStateMu.Unlock()
+	entry, ok := oidcStateLocal[state]
+	delete(oidcStateLocal, state)
+	if !ok || time.Now().After(entry.ExpiresAt) {
+		return data, fmt.Errorf("unknown or expired login state")
+	}
+	return entry.State, nil
+}
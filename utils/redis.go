@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+var redisClient atomic.Pointer[redis.Client]
+
+// RedisClient is nil until the server has answered; callers must fall back
+// to the database in that case.
+func RedisClient() *redis.Client {
+	return redisClient.Load()
+}
+
+func InitRedis(client *redis.Client) {
+	redisClient.Store(client)
+}
+
+// ConnectRedis enables Redis once client answers a ping. While it does not,
+// the ping is retried every retry in the background, so Redis being down at
+// startup does not leave it disabled until a restart. A retry of zero gives
+// up after the first ping.
+func ConnectRedis(client *redis.Client, retry time.Duration) {
+	err := enableRedis(client)
+	if err == nil {
+		return
+	}
+	Logger.Warn("Could not connect to Redis, using the database until it is reachable", zap.Error(err))
+	if retry <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(retry)
+		defer ticker.Stop()
+		for range ticker.C {
+			if enableRedis(client) == nil {
+				return
+			}
+		}
+	}()
+}
+
+func enableRedis(client *redis.Client) error {
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return err
+	}
+	// Tokens may have been revoked while the cache was off; drop the versions
+	// cached before that so they cannot be served again
+	if err := clearTokenVersions(ctx, client); err != nil {
+		return err
+	}
+	InitRedis(client)
+	LogInfo("Connected to Redis")
+	return nil
+}
+
+func clearTokenVersions(ctx context.Context, client *redis.Client) error {
+	iter := client.Scan(ctx, 0, tokenVersionPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		if err := client.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
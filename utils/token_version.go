@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"warehouse-store/models"
+)
+
+const (
+	tokenVersionCacheTTL = 15 * time.Minute
+	tokenVersionPrefix   = "token_version:"
+)
+
+func tokenVersionKey(userID uint) string {
+	return fmt.Sprintf("%s%d", tokenVersionPrefix, userID)
+}
+
+// CurrentTokenVersion returns the token version a user's tokens must carry to
+// be accepted. Redis is consulted first; when it is unavailable or has no
+// entry the value is read from the database. A deleted user yields an error.
+func CurrentTokenVersion(ctx context.Context, db *gorm.DB, userID uint) (uint, error) {
+	if rdb := RedisClient(); rdb != nil {
+		val, err := rdb.Get(ctx, tokenVersionKey(userID)).Result()
+		if err == nil {
+			if version, err := strconv.ParseUint(val, 10, 64); err == nil {
+				return uint(version), nil
+			}
+		} else if err != redis.Nil {
+			Logger.Warn("Redis token version lookup failed, falling back to database", zap.Error(err))
+		}
+	}
+
+	var user models.User
+	if err := db.Select("id", "token_version").First(&user, userID).Error; err != nil {
+		return 0, err
+	}
+
+	cacheTokenVersion(ctx, userID, user.TokenVersion)
+	return user.TokenVersion, nil
+}
+
+// RevokeUserTokens invalidates every access and refresh token issued to the
+// user so far by bumping their token version. The cached version is dropped
+// before and after the bump rather than overwritten, so the cache can only
+// miss; when Redis cannot drop it the revocation fails instead of leaving
+// the old version valid for the rest of its TTL.
+func RevokeUserTokens(ctx context.Context, db *gorm.DB, userID uint) error {
+	if err := uncacheTokenVersion(ctx, userID); err != nil {
+		return err
+	}
+	if err := db.Model(&models.User{}).Where("id = ?", userID).
+		UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error; err != nil {
+		return err
+	}
+	// A lookup that read the old version between the two steps may have
+	// cached it again
+	return uncacheTokenVersion(ctx, userID)
+}
+
+func cacheTokenVersion(ctx context.Context, userID, version uint) {
+	rdb := RedisClient()
+	if rdb == nil {
+		return
+	}
+	if err := rdb.Set(ctx, tokenVersionKey(userID), version, tokenVersionCacheTTL).Err(); err != nil {
+		Logger.Warn("Failed to cache token version", zap.Error(err))
+	}
+}
+
+func uncacheTokenVersion(ctx context.Context, userID uint) error {
+	rdb := RedisClient()
+	if rdb == nil {
+		return nil
+	}
+	if err := rdb.Del(ctx, tokenVersionKey(userID)).Err(); err != nil {
+		return fmt.Errorf("failed to drop cached token version: %w", err)
+	}
+	return nil
+}